@@ -34,23 +34,89 @@ func NewStartCommand(cfg *config.StartCommandConfig, reverseProxySvc *service.Re
 	parentCommand.AddCommand(startCommand.GetCobraCommand())
 
 	startCommand.cobraCmd.Flags().SortFlags = false
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.Config))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.ConfigWatchInterval))
 	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.LogLevel))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.TraceLogBodyMaxSize))
 	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.LocalPort))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.MetricsPort))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.AdminPort))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.AdminToken))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.TracingEndpoint))
+	startCommand.cobraCmd.Flags().Float64Var(buildFlagArgs(&cfg.TracingSampleRatio))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.TracingServiceName))
 	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.RemoteURI))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.UpstreamURIs))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.LoadBalancing))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.LoadBalancingHashKey))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.HealthCheckPath))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.HealthCheckInterval))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.HealthCheckTimeout))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.EjectionFailures))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.EjectionDuration))
+	startCommand.cobraCmd.Flags().Float64Var(buildFlagArgs(&cfg.CircuitBreakerFailureRatio))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.CircuitBreakerMinRequests))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.CircuitBreakerWindow))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.CircuitBreakerCooldown))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.CircuitBreakerTrialRequests))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.CircuitBreakerStatus))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.CircuitBreakerBody))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.RetryAttempts))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.RetryStatuses))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.RetryMethods))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.RetryBackoff))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.RetryBackoffMax))
 	startCommand.cobraCmd.Flags().Float64Var(buildFlagArgs(&cfg.ThrottleRateLimit))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.FaultDelay))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.FaultDelayJitter))
+	startCommand.cobraCmd.Flags().Float64Var(buildFlagArgs(&cfg.FaultErrorPercentage))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.FaultErrorStatus))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.FaultErrorBody))
+	startCommand.cobraCmd.Flags().Float64Var(buildFlagArgs(&cfg.FaultResetPercentage))
+	startCommand.cobraCmd.Flags().Float64Var(buildFlagArgs(&cfg.FaultTruncatePercentage))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.FaultSeed))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.MockResponse))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.Record))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.Replay))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.MirrorURIs))
+	startCommand.cobraCmd.Flags().Float64Var(buildFlagArgs(&cfg.MirrorPercentage))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.MirrorTimeout))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.MirrorCompare))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.ReverseProxyCacheSize))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.DecodedBodyMaxSize))
 	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.TransformRequestUrlSED))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.AdditionalRequestQueryParams))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.RemoveRequestQueryParams))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.SetRequestQueryParams))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.RenameRequestQueryParams))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.TransformRequestQueryJQ))
 	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.AdditionalRequestHeaders))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.RemoveRequestHeaders))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.SetRequestHeaders))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.RenameRequestHeaders))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.TransformRequestHeadersSED))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.TransformRequestHeadersJQ))
 	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.TransformRequestBodySED))
 	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.TransformRequestBodyJQ))
 	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.AdditionalResponseHeaders))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.RemoveResponseHeaders))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.SetResponseHeaders))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.RenameResponseHeaders))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.TransformResponseHeadersSED))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.TransformResponseHeadersJQ))
 	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.TransformResponseBodySED))
 	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.TransformResponseBodyJQ))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.RewriteResponseRules))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.TransformJqOutput))
+	startCommand.cobraCmd.Flags().StringVar(buildFlagArgs(&cfg.TransformJqResults))
+	startCommand.cobraCmd.Flags().IntVar(buildFlagArgs(&cfg.TransformDiffMaxSize))
+	startCommand.cobraCmd.Flags().StringArrayVar(buildFlagArgs(&cfg.Routes))
 
 	startCommand.cobraCmd.Example = startCommand.getExamples()
 	startCommand.cobraCmd.SetHelpTemplate(
 		startCommand.cobraCmd.HelpTemplate() +
-			"\n*Use CLI flags, environment variables or request headers to configure settings. " +
-			"The settings will be applied in the following priority: environment variables -> CLI flags -> request headers\n")
+			"\n*Use config file, CLI flags, environment variables or request headers to configure settings. " +
+			"The settings will be applied in the following priority: config file -> environment variables -> CLI flags -> request headers\n")
 
 	return startCommand
 }
@@ -63,31 +129,100 @@ func (c *StartCommand) runE(cmd *cobra.Command, args []string) error {
 	if c.errSetFromEnv != nil {
 		return c.errSetFromEnv
 	}
-	if err := c.cfg.Validate(); err != nil {
+	loadConfig := c.getConfigLoader(cmd)
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if err = cfg.Validate(); err != nil {
 		return err
 	}
-	if err := c.reverseProxySvc.Start(c.cfg); !errors.Is(err, http.ErrServerClosed) {
+	if err = c.reverseProxySvc.Start(cfg, loadConfig); !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 	return nil
 }
 
+// getConfigLoader returns the loader which applies the config file on top of the current config state (defaults, env variables and CLI flags)
+func (c *StartCommand) getConfigLoader(cmd *cobra.Command) service.ConfigLoader {
+	baseCfg := *c.cfg
+	return func() (*config.StartCommandConfig, error) {
+		cfg := baseCfg
+		if cfg.Config.Value != "" {
+			isSet := func(flagName string) bool { return cfg.IsSetByEnv(flagName) || cmd.Flags().Changed(flagName) }
+			if err := cfg.SetFromFile(cfg.Config.Value, isSet); err != nil {
+				return nil, err
+			}
+		}
+		return &cfg, nil
+	}
+}
+
 func (c *StartCommand) getExamples() string {
 	textTemplate := `  # Start the proxy with default values
   {{ .Cmd.CommandPath }}
   
+  # Start the proxy with options from the YAML or JSON config file
+  {{ .Cmd.CommandPath }} --{{ .Cfg.Config.GetFlagName }} protty.yaml
+
   # Start the proxy with specific log level
   {{ .Cmd.CommandPath }} --{{ .Cfg.LogLevel.GetFlagName }} info
 
+  # Start the proxy with the exchange logs including first 1KB of the request and response bodies
+  {{ .Cmd.CommandPath }} --{{ .Cfg.LogLevel.GetFlagName }} trace --{{ .Cfg.TraceLogBodyMaxSize.GetFlagName }} 1024
+
   # Start the proxy with a specific local port
   {{ .Cmd.CommandPath }} --{{ .Cfg.LocalPort.GetFlagName }} 8080
   
+  # Start the proxy with the Prometheus metrics on :9090/metrics
+  {{ .Cmd.CommandPath }} --{{ .Cfg.MetricsPort.GetFlagName }} 9090
+
+  # Start the proxy with the admin API on :9091 port protected by the token
+  {{ .Cmd.CommandPath }} --{{ .Cfg.AdminPort.GetFlagName }} 9091 --{{ .Cfg.AdminToken.GetFlagName }} secret
+
+  # Start the proxy sending the traces of 10% of the requests to the OpenTelemetry collector
+  {{ .Cmd.CommandPath }} --{{ .Cfg.TracingEndpoint.GetFlagName }} http://localhost:4318 --{{ .Cfg.TracingSampleRatio.GetFlagName }} 0.1
+
   # Start the proxy with a specific remote URI and specific throttle rate limit 
   {{ .Cmd.CommandPath }} --{{ .Cfg.RemoteURI.GetFlagName }} https://www.githubstatus.com --{{ .Cfg.ThrottleRateLimit.GetFlagName }} 2
 
+  # Start the proxy balancing the requests between the healthy upstreams, the second one gets twice more requests
+  {{ .Cmd.CommandPath }} --{{ .Cfg.UpstreamURIs.GetFlagName }} http://10.0.0.1:8080 --{{ .Cfg.UpstreamURIs.GetFlagName }} 'http://10.0.0.2:8080 2' --{{ .Cfg.LoadBalancing.GetFlagName }} weighted --{{ .Cfg.HealthCheckPath.GetFlagName }} /health
+
+  # Start the proxy sending the requests of the same user to the same upstream
+  {{ .Cmd.CommandPath }} --{{ .Cfg.UpstreamURIs.GetFlagName }} http://10.0.0.1:8080 --{{ .Cfg.UpstreamURIs.GetFlagName }} http://10.0.0.2:8080 --{{ .Cfg.LoadBalancing.GetFlagName }} consistent-hash --{{ .Cfg.LoadBalancingHashKey.GetFlagName }} cookie:session
+
+  # Start the proxy fast-failing the requests for 30 seconds if the half of the upstream requests fail
+  {{ .Cmd.CommandPath }} --{{ .Cfg.CircuitBreakerFailureRatio.GetFlagName }} 0.5 --{{ .Cfg.CircuitBreakerCooldown.GetFlagName }} 30
+
+  # Start the proxy retrying the idempotent requests up to 3 times on the connection errors, 429 and 5xx responses
+  {{ .Cmd.CommandPath }} --{{ .Cfg.RetryAttempts.GetFlagName }} 3 --{{ .Cfg.RetryStatuses.GetFlagName }} 429,5xx
+
+  # Start the proxy with 200-300ms latency and 10% of the requests answered with 503 status code
+  {{ .Cmd.CommandPath }} --{{ .Cfg.FaultDelay.GetFlagName }} 200 --{{ .Cfg.FaultDelayJitter.GetFlagName }} 100 --{{ .Cfg.FaultErrorPercentage.GetFlagName }} 10
+
+  # Start the proxy answering the POST /users requests with the mock response and proxying the rest ones
+  {{ .Cmd.CommandPath }} --{{ .Cfg.Routes.GetFlagName }} '{"path-prefix": "/users", "methods": ["POST"], "options": {"{{ .Cfg.MockResponse.GetFlagName }}": {"status": 201, "body": "{\"id\": 1}"}}}'
+
+  # Start the proxy with recording the traffic and then replay it without the remote resource
+  {{ .Cmd.CommandPath }} --{{ .Cfg.Record.GetFlagName }} traffic.har
+  {{ .Cmd.CommandPath }} --{{ .Cfg.Replay.GetFlagName }} traffic.har
+
+  # Start the proxy with mirroring of the 10% requests to the new backend version and logging of the diverged responses
+  {{ .Cmd.CommandPath }} --{{ .Cfg.MirrorURIs.GetFlagName }} https://v2.example.com --{{ .Cfg.MirrorPercentage.GetFlagName }} 10 --{{ .Cfg.MirrorCompare.GetFlagName }} body
+
   # Start the proxy with a specific additional request headers
   {{ .Cmd.CommandPath }} --{{ .Cfg.AdditionalRequestHeaders.GetFlagName }} 'Authorization: Bearer authtoken-with:any:symbols' --{{ .Cfg.AdditionalRequestHeaders.GetFlagName }} 'X-Another-One: another-value'
 
+  # Start the proxy with injecting the API key query parameter and removing the tracking ones
+  {{ .Cmd.CommandPath }} --{{ .Cfg.SetRequestQueryParams.GetFlagName }} 'api_key=secret' --{{ .Cfg.TransformRequestQueryJQ.GetFlagName }} 'with_entries(select(.key | startswith("utm_") | not))'
+
+  # Start the proxy with removing the request cookies and rewriting the upstream redirects to the proxy
+  {{ .Cmd.CommandPath }} --{{ .Cfg.RemoveRequestHeaders.GetFlagName }} Cookie --{{ .Cfg.TransformResponseHeadersSED.GetFlagName }} 'Location: s|https://example.com|http://localhost:8080|'
+
+  # Start the proxy with removing all the X-Internal-* response headers
+  {{ .Cmd.CommandPath }} --{{ .Cfg.TransformResponseHeadersJQ.GetFlagName }} 'with_entries(select(.key | startswith("X-Internal-") | not))'
+
   # Start the proxy with a specific SED expression for response transformation
   {{ .Cmd.CommandPath }} --{{ .Cfg.TransformResponseBodySED.GetFlagName }} 's|old|new|g'
 
@@ -98,7 +233,16 @@ func (c *StartCommand) getExamples() string {
   {{ .Cfg.TransformResponseBodySED.GetEnvName }}_0='s|old|new-stage-1|g' {{ .Cfg.TransformResponseBodySED.GetEnvName }}_1='s|new-stage-1|new-stage-2|g' {{ .Cmd.CommandPath }}
 
   # Start the proxy with a specific JQ expressions pipeline for response transformation
-  {{ .Cmd.CommandPath }} --{{ .Cfg.TransformResponseBodyJQ.GetFlagName }} '.[] | .id'`
+  {{ .Cmd.CommandPath }} --{{ .Cfg.TransformResponseBodyJQ.GetFlagName }} '.[] | .id'
+
+  # Start the proxy with replacing the upstream 404 responses of the API with the empty list
+  {{ .Cmd.CommandPath }} --{{ .Cfg.RewriteResponseRules.GetFlagName }} '{"status": "404", "path": "^/api/", "set-status": 200, "set-body": "[]", "set-headers": {"Content-Type": "application/json"}}'
+
+  # Start the proxy with logging of the diff (up to 4KB) made by every transformation stage
+  {{ .Cmd.CommandPath }} --{{ .Cfg.TransformResponseBodySED.GetFlagName }} 's|old|new|g' --{{ .Cfg.TransformDiffMaxSize.GetFlagName }} 4096
+
+  # Start the proxy with a specific remote URI and response transformation for the /api/* requests
+  {{ .Cmd.CommandPath }} --{{ .Cfg.Routes.GetFlagName }} '{"path-prefix": "/api/", "options": {"{{ .Cfg.RemoteURI.GetFlagName }}": "https://api.example.com", "{{ .Cfg.TransformResponseBodyJQ.GetFlagName }}": [".data"]}}'`
 
 	t, b := new(template.Template), new(strings.Builder)
 	err := template.Must(t.Parse(textTemplate)).Execute(b, struct {
@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/mgerasimchuk/protty/internal/infrastructure/service"
+	"github.com/spf13/cobra"
+)
+
+// transformDiffMaxSize is the default diff size limit for the transform command, the diff is the main output for the debugging
+const transformDiffMaxSize = 64 * 1024
+
+type TransformCommand struct {
+	cobraCmd     *cobra.Command
+	transformSvc *service.TransformService
+	cfg          *config.StartCommandConfig
+	input        string
+	match        config.TransformMatchContext // exchange data for the stage conditions
+}
+
+func NewTransformCommand(cfg *config.StartCommandConfig, transformSvc *service.TransformService, parentCommand *cobra.Command) *TransformCommand {
+	transformCommand := &TransformCommand{
+		transformSvc: transformSvc,
+		cfg:          cfg,
+	}
+
+	transformCommand.cobraCmd = &cobra.Command{
+		Use:   "transform",
+		Short: "Apply the body transformation pipelines offline and print the result with the diff of every stage",
+		RunE:  transformCommand.runE,
+	}
+
+	parentCommand.AddCommand(transformCommand.GetCobraCommand())
+
+	cfg.TransformDiffMaxSize.Value = transformDiffMaxSize
+	transformCommand.cobraCmd.Flags().SortFlags = false
+	transformCommand.cobraCmd.Flags().StringVarP(&transformCommand.input, "input", "i", "-", "Path to the file with the body, - for stdin")
+	transformCommand.cobraCmd.Flags().StringVar(&transformCommand.match.Method, "method", http.MethodGet, "Request method for the stage conditions")
+	transformCommand.cobraCmd.Flags().StringVar(&transformCommand.match.Path, "path", "/", "Request path for the stage conditions")
+	transformCommand.cobraCmd.Flags().StringVar(&transformCommand.match.ContentType, "content-type", "application/json", "Body content type for the stage conditions")
+	transformCommand.cobraCmd.Flags().IntVar(&transformCommand.match.StatusCode, "status-code", http.StatusOK, "Response status code for the stage conditions")
+	transformCommand.cobraCmd.Flags().StringVar(buildTransformFlagArgs(&cfg.Config))
+	transformCommand.cobraCmd.Flags().StringArrayVar(buildTransformFlagArgs(&cfg.TransformRequestBodySED))
+	transformCommand.cobraCmd.Flags().StringArrayVar(buildTransformFlagArgs(&cfg.TransformRequestBodyJQ))
+	transformCommand.cobraCmd.Flags().StringArrayVar(buildTransformFlagArgs(&cfg.TransformResponseBodySED))
+	transformCommand.cobraCmd.Flags().StringArrayVar(buildTransformFlagArgs(&cfg.TransformResponseBodyJQ))
+	transformCommand.cobraCmd.Flags().StringVar(buildTransformFlagArgs(&cfg.TransformJqOutput))
+	transformCommand.cobraCmd.Flags().StringVar(buildTransformFlagArgs(&cfg.TransformJqResults))
+	transformCommand.cobraCmd.Flags().IntVar(buildTransformFlagArgs(&cfg.TransformDiffMaxSize))
+
+	transformCommand.cobraCmd.Example = transformCommand.getExamples()
+	transformCommand.cobraCmd.SetHelpTemplate(
+		transformCommand.cobraCmd.HelpTemplate() +
+			"\n*The request body pipelines are applied before the response body ones, the same as the proxy does. " +
+			"The result is printed to stdout, the changes of every stage are printed to stderr\n")
+
+	return transformCommand
+}
+
+func (c *TransformCommand) GetCobraCommand() *cobra.Command {
+	return c.cobraCmd
+}
+
+func (c *TransformCommand) runE(cmd *cobra.Command, args []string) error {
+	if c.cfg.Config.Value != "" {
+		// the environment variables aren't applied to the transform command, so only the flags take priority over the file
+		isSetByFlag := func(flagName string) bool { return cmd.Flags().Changed(flagName) }
+		if err := c.cfg.SetFromFile(c.cfg.Config.Value, isSetByFlag); err != nil {
+			return err
+		}
+	}
+
+	var body []byte
+	var err error
+	if c.input == "-" {
+		body, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		body, err = os.ReadFile(c.input)
+	}
+	if err != nil {
+		return err
+	}
+
+	requestMatch := c.match
+	requestMatch.StatusCode = 0
+	body, changes, err := c.transformSvc.TransformRequestBody(*c.cfg, requestMatch, body, c.cfg.TransformDiffMaxSize.Value)
+	for _, change := range changes {
+		_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "ModifyRequestBody: %s\n", change)
+	}
+	if err != nil {
+		return err
+	}
+
+	body, changes, err = c.transformSvc.TransformResponseBody(*c.cfg, c.match, body, c.cfg.TransformDiffMaxSize.Value)
+	for _, change := range changes {
+		_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "ModifyResponseBody: %s\n", change)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = cmd.OutOrStdout().Write(body)
+	return err
+}
+
+func (c *TransformCommand) getExamples() string {
+	textTemplate := `  # Check a SED expressions pipeline on the body from stdin
+  echo '{"status": "old"}' | {{ .Cmd.CommandPath }} --{{ .Cfg.TransformResponseBodySED.GetFlagName }} 's|old|new-stage-1|g' --{{ .Cfg.TransformResponseBodySED.GetFlagName }} 's|new-stage-1|new-stage-2|g'
+
+  # Check a JQ expressions pipeline on the body from the file
+  {{ .Cmd.CommandPath }} --input response.json --{{ .Cfg.TransformResponseBodyJQ.GetFlagName }} '.items' --{{ .Cfg.TransformResponseBodyJQ.GetFlagName }} '.[] | .id'
+
+  # Check the conditional JQ stage on the error response
+  {{ .Cmd.CommandPath }} --input error.html --content-type text/html --status-code 500 --{{ .Cfg.TransformResponseBodyJQ.GetFlagName }} '{"expr": ".data", "content-type": "application/*json", "status": "2xx"}'
+
+  # Check the pipelines from the config file
+  {{ .Cmd.CommandPath }} --input response.json --{{ .Cfg.Config.GetFlagName }} protty.yaml`
+
+	t, b := new(template.Template), new(strings.Builder)
+	err := template.Must(t.Parse(textTemplate)).Execute(b, struct {
+		Cmd *cobra.Command
+		Cfg *config.StartCommandConfig
+	}{c.cobraCmd, c.cfg})
+	if err != nil {
+		panic(err)
+	}
+	return b.String()
+}
+
+// buildTransformFlagArgs is the buildFlagArgs version without the env variable and request header aliases,
+// cos the transform command is configured with the flags and the config file only
+func buildTransformFlagArgs[T config.OptionValueType](o *config.Option[T]) (*T, string, T, string) {
+	o.MarkAsAddedToCLI()
+	return &o.Value, o.GetFlagName(), o.Value, o.Description
+}
@@ -12,7 +12,9 @@ type ProttyApp struct {
 	logger          *logrus.Logger
 	rootCmd         *cli.RootCommand
 	startCmd        *cli.StartCommand
+	transformCmd    *cli.TransformCommand
 	reverseProxySvc *service.ReverseProxyService
+	transformSvc    *service.TransformService
 }
 
 func NewProttyApp(cfg *config.StartCommandConfig) *ProttyApp {
@@ -21,8 +23,10 @@ func NewProttyApp(cfg *config.StartCommandConfig) *ProttyApp {
 	app.logger.SetLevel(cfg.GetLogLevelLogrus())
 	app.logger.SetFormatter(&logrus.JSONFormatter{})
 	app.rootCmd = cli.NewRootCommand()
-	app.reverseProxySvc = service.NewReverseProxyService(app.logger)
+	app.transformSvc = service.NewTransformService()
+	app.reverseProxySvc = service.NewReverseProxyService(app.transformSvc, app.logger)
 	app.startCmd = cli.NewStartCommand(cfg, app.reverseProxySvc, app.rootCmd.GetCobraCommand())
+	app.transformCmd = cli.NewTransformCommand(config.GetStartCommandConfig(), app.transformSvc, app.rootCmd.GetCobraCommand())
 	return app
 }
 
@@ -55,6 +55,31 @@ func TestStartCommand(t *testing.T) {
 			args{targetPath: "/", targetResponseBody: `{"code": 100, "message": "message body"}`, prottyFlags: append(prottyStart, "--transform-response-body-jq", ".message")},
 			want{responseBody: "message body"},
 		},
+		{
+			"Flags configuration JQ expression with multiple results as array",
+			args{targetPath: "/", targetResponseBody: `{"items": [{"id": 1}, {"id": "two"}]}`, prottyFlags: append(prottyStart, "--transform-response-body-jq", ".items[].id", "--transform-jq-results", "array")},
+			want{responseBody: `[1,"two"]`},
+		},
+		{
+			"Flags configuration JQ expression skipped by the content type condition",
+			args{targetPath: "/", targetResponseBody: `{"message": "message body"}`, prottyFlags: append(prottyStart,
+				"--transform-response-body-jq", `{"expr": ".message", "content-type": "application/json"}`)},
+			want{responseBody: `{"message": "message body"}`},
+		},
+		{
+			"Flags configuration routes",
+			args{targetPath: "/", targetResponseBody: "ok", prottyFlags: append(prottyStart,
+				"--routes", `{"path-prefix": "/not-matched", "options": {"transform-response-body-sed": "s|ok|not-matched|g"}}`,
+				"--routes", `{"path-prefix": "/", "options": {"transform-response-body-sed": "s|ok|matched|g"}}`)},
+			want{responseBody: "matched"},
+		},
+		{
+			"Flags configuration rewrite rules",
+			args{targetPath: "/", targetResponseBody: "ok", prottyFlags: append(prottyStart,
+				"--rewrite-response-rules", `{"status": "200", "body": "^ok$", "set-status": 503, "set-body": "unavailable"}`,
+				"--transform-response-body-sed", `{"expr": "s|unavailable|maintenance|", "status": "503"}`)},
+			want{responseBody: "maintenance"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
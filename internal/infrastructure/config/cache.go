@@ -0,0 +1,8 @@
+package config
+
+// PurgeCaches removes the parsed transform stages, rewrite rules and mock responses from their caches
+func PurgeCaches() {
+	transformStages.Purge()
+	rewriteRules.Purge()
+	mockResponses.Purge()
+}
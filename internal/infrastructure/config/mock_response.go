@@ -0,0 +1,144 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"text/template"
+
+	"github.com/mgerasimchuk/protty/pkg/util"
+)
+
+// mockResponsesCacheSize is the max number of cached parsed mock responses, mocks are parsed for every exchange
+const mockResponsesCacheSize = 1000
+
+var mockResponses = util.NewLRU[string, *MockResponse](mockResponsesCacheSize, nil)
+
+// mockTemplateFuncs are available in the mock body templates in addition to the text/template builtin ones
+var mockTemplateFuncs = template.FuncMap{
+	"json": func(value any) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+// MockResponse is the response sent to the client without sending the request to the remote resource.
+// For example {"status": 200, "headers": {"Content-Type": "application/json"}, "body": "{\"id\": \"{{ .Query.Get \"id\" }}\"}", "template": true}
+type MockResponse struct {
+	// Status is the response status code, 200 by default
+	Status int `json:"status"`
+	// Headers are the response headers
+	Headers map[string]string `json:"headers"`
+	// Body is the inline response body
+	Body string `json:"body"`
+	// BodyFile is the path of the file with the response body, the file is read for every response
+	BodyFile string `json:"body-file"`
+	// Template enables rendering of the body as Go template with MockTemplateData
+	Template bool `json:"template"`
+	// Delay is the latency in milliseconds before sending the response
+	Delay int `json:"delay"`
+
+	template *template.Template
+}
+
+// MockTemplateData is the request data available in the mock body templates, Body is the decoded JSON request body
+// (nil if the body isn't a valid JSON)
+type MockTemplateData struct {
+	Method  string
+	Path    string
+	Query   url.Values
+	Headers http.Header
+	Body    any
+	RawBody string
+}
+
+// ParseMockResponse parses the mock in JSON format, the parsed mocks are cached by the value
+func ParseMockResponse(value string) (*MockResponse, error) {
+	if mock, ok := mockResponses.Get(value); ok {
+		return mock, nil
+	}
+
+	mock := &MockResponse{}
+	decoder := json.NewDecoder(bytes.NewBufferString(value))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(mock); err != nil {
+		return nil, fmt.Errorf("%s: %w", util.GetFuncName(decoder.Decode), err)
+	}
+	if mock.Status == 0 {
+		mock.Status = http.StatusOK
+	}
+	if mock.Status < 100 || mock.Status > 999 {
+		return nil, fmt.Errorf("status: invalid status code %d", mock.Status)
+	}
+	if mock.Delay < 0 {
+		return nil, fmt.Errorf("delay: should be greater than or equal to 0")
+	}
+	if mock.Body != "" && mock.BodyFile != "" {
+		return nil, fmt.Errorf("body and body-file can't be set together")
+	}
+	if mock.BodyFile != "" {
+		if _, err := os.Stat(mock.BodyFile); err != nil {
+			return nil, fmt.Errorf("body-file: %s: %w", util.GetFuncName(os.Stat), err)
+		}
+	}
+	if mock.Template && mock.BodyFile == "" {
+		var err error
+		if mock.template, err = parseMockTemplate(mock.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	mockResponses.Add(value, mock)
+	return mock, nil
+}
+
+// checkRequestHeaderMockResponse rejects the mock fields reading the server files or rendering the templates in the mock
+// set by the request header, they can be set by the trusted sources only (config file, env variables, flags and routes)
+func checkRequestHeaderMockResponse(value string) error {
+	mock := MockResponse{}
+	if err := json.Unmarshal([]byte(value), &mock); err != nil {
+		return fmt.Errorf("%s: %w", util.GetFuncName(json.Unmarshal), err)
+	}
+	if mock.BodyFile != "" || mock.Template {
+		return fmt.Errorf("body-file and template can't be set by the request header")
+	}
+	return nil
+}
+
+// RenderBody returns the response body, the template is rendered with the data
+func (m *MockResponse) RenderBody(data MockTemplateData) ([]byte, error) {
+	body := []byte(m.Body)
+	if m.BodyFile != "" {
+		var err error
+		if body, err = os.ReadFile(m.BodyFile); err != nil {
+			return nil, fmt.Errorf("%s: %w", util.GetFuncName(os.ReadFile), err)
+		}
+	}
+	if !m.Template {
+		return body, nil
+	}
+
+	tmpl := m.template
+	if tmpl == nil { // the file templates are parsed for every response to pick up the file changes
+		var err error
+		if tmpl, err = parseMockTemplate(string(body)); err != nil {
+			return nil, err
+		}
+	}
+	b := bytes.Buffer{}
+	if err := tmpl.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("%s: %w", util.GetFuncName(tmpl.Execute), err)
+	}
+	return b.Bytes(), nil
+}
+
+func parseMockTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("mock").Funcs(mockTemplateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", util.GetFuncName((*template.Template).Parse), err)
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,111 @@
+//go:build unit
+// +build unit
+
+package config
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMockResponse(t *testing.T) {
+	bodyFile := filepath.Join(t.TempDir(), "body.json")
+	assert.NoError(t, os.WriteFile(bodyFile, []byte(`{"id": "{{ .Query.Get "id" }}"}`), 0o600))
+
+	tests := []struct {
+		msg        string
+		value      string
+		wantStatus int
+		wantErr    bool
+	}{
+		{"Default status", `{"body": "ok"}`, http.StatusOK, false},
+		{"Body file", `{"status": 201, "body-file": "` + bodyFile + `", "template": true}`, http.StatusCreated, false},
+		{"Body template", `{"body": "{{ .Path }}", "template": true}`, http.StatusOK, false},
+		{"Invalid body template", `{"body": "{{ .Path ", "template": true}`, 0, true},
+		{"Body and body file", `{"body": "ok", "body-file": "` + bodyFile + `"}`, 0, true},
+		{"Absent body file", `{"body-file": "` + bodyFile + `.absent"}`, 0, true},
+		{"Invalid status", `{"status": 42}`, 0, true},
+		{"Negative delay", `{"delay": -1}`, 0, true},
+		{"Unknown field", `{"code": 200}`, 0, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			mock, err := ParseMockResponse(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, mock.Status)
+		})
+	}
+}
+
+func TestStartCommandConfig_SetFromHTTPRequestHeaders_MockResponse(t *testing.T) {
+	tests := []struct {
+		msg     string
+		value   string
+		wantErr bool
+	}{
+		{"Inline body", `{"status": 404, "body": "not found"}`, false},
+		{"Body file", `{"body-file": "/etc/passwd"}`, true},
+		{"Template", `{"body": "{{ .Path }}", "template": true}`, true},
+		{"Invalid JSON", `{"body": `, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			cfg := GetStartCommandConfig()
+			_, err := cfg.SetFromHTTPRequestHeaders(http.Header{"X-Protty-Mock-Response": {tt.value}}, nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Empty(t, cfg.MockResponse.Value)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.value, cfg.MockResponse.Value)
+		})
+	}
+}
+
+func TestMockResponse_RenderBody(t *testing.T) {
+	bodyFile := filepath.Join(t.TempDir(), "body.txt")
+	assert.NoError(t, os.WriteFile(bodyFile, []byte(`{{ .Method }} {{ .Headers.Get "X-User" }}`), 0o600))
+
+	data := MockTemplateData{
+		Method:  http.MethodPost,
+		Path:    "/users/42",
+		Query:   url.Values{"id": {"42"}},
+		Headers: http.Header{"X-User": {"admin"}},
+		Body:    map[string]any{"name": "John", "tags": []any{"a", "b"}},
+		RawBody: `{"name": "John", "tags": ["a", "b"]}`,
+	}
+	tests := []struct {
+		msg   string
+		value string
+		want  string
+	}{
+		{"Inline body", `{"body": "{{ .Path }}"}`, "{{ .Path }}"},
+		{"Inline template", `{"body": "{{ .Path }} {{ .Query.Get \"id\" }} {{ .Body.name }} {{ json .Body.tags }} {{ .Body.absent }}", "template": true}`, `/users/42 42 John ["a","b"] <no value>`},
+		{"File template", `{"body-file": "` + bodyFile + `", "template": true}`, "POST admin"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			mock, err := ParseMockResponse(tt.value)
+			assert.NoError(t, err)
+			body, err := mock.RenderBody(data)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+}
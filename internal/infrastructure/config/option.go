@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"github.com/mgerasimchuk/protty/pkg/util"
 	"strings"
 )
@@ -14,6 +15,8 @@ type Option[T OptionValueType] struct {
 	Description  string
 	IsAddedToCLI bool
 	Value        T
+	// Source is a place where the value has been taken from (for example "protty.yaml:12"), empty for defaults, env variables and flags
+	Source string
 }
 
 func (o *Option[T]) GetHeaderName() string {
@@ -32,3 +35,26 @@ func (o *Option[T]) GetFlagName() string {
 func (o *Option[T]) MarkAsAddedToCLI() {
 	o.IsAddedToCLI = true
 }
+
+// WrapError adds the option name and the value source (if it's known) to the error
+func (o *Option[T]) WrapError(err error) error {
+	return &OptionError{Flag: o.GetFlagName(), Source: o.Source, Err: err}
+}
+
+// OptionError is the error of the option, Flag is the option flag name (e.g. transform-request-body-jq)
+type OptionError struct {
+	Flag   string
+	Source string
+	Err    error
+}
+
+func (e *OptionError) Error() string {
+	if e.Source != "" {
+		return fmt.Sprintf("%s: %s: %s", e.Source, e.Flag, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Flag, e.Err)
+}
+
+func (e *OptionError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/mgerasimchuk/protty/pkg/util"
+)
+
+// rewriteRulesCacheSize is the max number of cached parsed rewrite rules, rules are parsed for every exchange
+const rewriteRulesCacheSize = 1000
+
+var rewriteRules = util.NewLRU[string, *RewriteRule](rewriteRulesCacheSize, nil)
+
+// RewriteRule rewrites the upstream response status, headers and body, if all the rule conditions are met.
+// For example {"status": "404", "path": "^/api/", "set-status": 200, "set-body": "{}"}
+type RewriteRule struct {
+	// Name is used in logs
+	Name string `json:"name"`
+	TransformConditions
+	// Body matches the response body by regular expression
+	Body string `json:"body"`
+
+	// SetStatus replaces the response status code
+	SetStatus int `json:"set-status"`
+	// SetBody replaces the response body
+	SetBody *string `json:"set-body"`
+	// SetHeaders replaces the response headers values
+	SetHeaders map[string]string `json:"set-headers"`
+	// RemoveHeaders removes the response headers
+	RemoveHeaders []string `json:"remove-headers"`
+
+	bodyRegex *regexp.Regexp
+}
+
+// ParseRewriteRule parses the rule in JSON format, the parsed rules are cached by the value
+func ParseRewriteRule(value string) (*RewriteRule, error) {
+	if rule, ok := rewriteRules.Get(value); ok {
+		return rule, nil
+	}
+
+	rule := &RewriteRule{}
+	decoder := json.NewDecoder(bytes.NewBufferString(value))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(rule); err != nil {
+		return nil, fmt.Errorf("%s: %w", util.GetFuncName(decoder.Decode), err)
+	}
+	if err := rule.compile(); err != nil {
+		return nil, err
+	}
+	if rule.Body != "" {
+		var err error
+		if rule.bodyRegex, err = regexp.Compile(rule.Body); err != nil {
+			return nil, fmt.Errorf("body: %s: %w", util.GetFuncName(regexp.Compile), err)
+		}
+	}
+	if rule.SetStatus != 0 && (rule.SetStatus < 100 || rule.SetStatus > 999) {
+		return nil, fmt.Errorf("set-status: invalid status code %d", rule.SetStatus)
+	}
+	if rule.SetStatus == 0 && rule.SetBody == nil && len(rule.SetHeaders) == 0 && len(rule.RemoveHeaders) == 0 {
+		return nil, fmt.Errorf("the rule should have at least one of set-status, set-body, set-headers or remove-headers")
+	}
+
+	rewriteRules.Add(value, rule)
+	return rule, nil
+}
+
+// MatchResponse returns true if the response matches all the rule conditions
+func (r *RewriteRule) MatchResponse(ctx TransformMatchContext, body []byte) bool {
+	if !r.Match(ctx) {
+		return false
+	}
+	return r.bodyRegex == nil || r.bodyRegex.Match(body)
+}
+
+// Apply rewrites the response status code, headers and body, it returns the rewritten ones
+func (r *RewriteRule) Apply(statusCode int, header http.Header, body []byte) (int, http.Header, []byte) {
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if r.SetStatus != 0 {
+		statusCode = r.SetStatus
+	}
+	for _, name := range r.RemoveHeaders {
+		header.Del(name)
+	}
+	for name, value := range r.SetHeaders {
+		header.Set(name, value)
+	}
+	if r.SetBody != nil {
+		body = []byte(*r.SetBody)
+	}
+	return statusCode, header, body
+}
@@ -0,0 +1,59 @@
+//go:build unit
+// +build unit
+
+package config
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRewriteRule(t *testing.T) {
+	tests := []struct {
+		msg     string
+		value   string
+		wantErr bool
+	}{
+		{"Status rewrite", `{"status": "404", "set-status": 200}`, false},
+		{"All the conditions and actions", `{"name": "stub", "status": "5xx", "path": "^/api/", "method": "GET", "content-type": "application/json", "body": "error", "set-status": 200, "set-body": "{}", "set-headers": {"X-Stub": "1"}, "remove-headers": ["Retry-After"]}`, false},
+		{"Empty set body", `{"set-body": ""}`, false},
+		{"Without actions", `{"status": "404"}`, true},
+		{"Invalid set status", `{"set-status": 42}`, true},
+		{"Invalid body regex", `{"body": "(", "set-status": 200}`, true},
+		{"Invalid status condition", `{"status": "2xy", "set-status": 200}`, true},
+		{"Unknown field", `{"host": "example.com", "set-status": 200}`, true},
+		{"Not a JSON", `s|a|b|`, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			_, err := ParseRewriteRule(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRewriteRule_MatchResponseAndApply(t *testing.T) {
+	rule, err := ParseRewriteRule(`{"status": "404", "path": "^/api/", "body": "not found", "set-status": 200, "set-body": "[]", "set-headers": {"Content-Type": "application/json"}, "remove-headers": ["X-Error"]}`)
+	assert.NoError(t, err)
+
+	ctx := TransformMatchContext{Method: http.MethodGet, Path: "/api/users", StatusCode: http.StatusNotFound}
+	assert.True(t, rule.MatchResponse(ctx, []byte("user not found")))
+	assert.False(t, rule.MatchResponse(ctx, []byte("gone")))
+	assert.False(t, rule.MatchResponse(TransformMatchContext{Path: "/users", StatusCode: http.StatusNotFound}, []byte("not found")))
+	assert.False(t, rule.MatchResponse(TransformMatchContext{Path: "/api/users", StatusCode: http.StatusOK}, []byte("not found")))
+
+	header := http.Header{"Content-Type": {"text/plain"}, "X-Error": {"1"}}
+	statusCode, gotHeader, body := rule.Apply(http.StatusNotFound, header, []byte("user not found"))
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, http.Header{"Content-Type": {"application/json"}}, gotHeader)
+	assert.Equal(t, "[]", string(body))
+	assert.Equal(t, http.Header{"Content-Type": {"text/plain"}, "X-Error": {"1"}}, header, "the source header shouldn't be modified")
+}
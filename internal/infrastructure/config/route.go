@@ -0,0 +1,114 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mgerasimchuk/protty/pkg/util"
+)
+
+// Route is a routing rule, options of the first matched route override the config options for the request
+type Route struct {
+	// Name is used in logs, by default it's route-<index>
+	Name string `json:"name"`
+	// PathPrefix matches the request path prefix
+	PathPrefix string `json:"path-prefix"`
+	// PathRegex matches the request path by regular expression
+	PathRegex string `json:"path-regex"`
+	// Methods matches any of the listed request methods
+	Methods []string `json:"methods"`
+	// Host matches the request host (without port) by glob pattern, for example *.example.com
+	Host string `json:"host"`
+	// Headers matches request headers values by regular expressions
+	Headers map[string]string `json:"headers"`
+	// Options override the config options, keys are the option flag names
+	Options map[string]any `json:"options"`
+
+	pathRegex     *regexp.Regexp
+	headerRegexes map[string]*regexp.Regexp
+}
+
+// ParseRoutes parses routes in JSON format and compiles their regular expressions
+func ParseRoutes(values []string) ([]Route, error) {
+	routes := make([]Route, 0, len(values))
+	for i, value := range values {
+		route := Route{}
+		decoder := json.NewDecoder(bytes.NewBufferString(value))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&route); err != nil {
+			return nil, fmt.Errorf("route %d: %s: %w", i, util.GetFuncName(decoder.Decode), err)
+		}
+		if route.Name == "" {
+			route.Name = fmt.Sprintf("route-%d", i)
+		}
+		if route.PathRegex != "" {
+			var err error
+			if route.pathRegex, err = regexp.Compile(route.PathRegex); err != nil {
+				return nil, fmt.Errorf("route %s: %s: %w", route.Name, util.GetFuncName(regexp.Compile), err)
+			}
+		}
+		if _, err := path.Match(route.Host, ""); err != nil {
+			return nil, fmt.Errorf("route %s: %s: %w", route.Name, util.GetFuncName(path.Match), err)
+		}
+		route.headerRegexes = map[string]*regexp.Regexp{}
+		for headerName, headerRegex := range route.Headers {
+			compiled, err := regexp.Compile(headerRegex)
+			if err != nil {
+				return nil, fmt.Errorf("route %s: %s: %w", route.Name, util.GetFuncName(regexp.Compile), err)
+			}
+			route.headerRegexes[headerName] = compiled
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// FindRoute returns the first route matched the request or nil
+func FindRoute(routes []Route, req *http.Request) *Route {
+	for i := range routes {
+		if routes[i].Match(req) {
+			return &routes[i]
+		}
+	}
+	return nil
+}
+
+// Match returns true if the request matches all the route conditions
+func (r *Route) Match(req *http.Request) bool {
+	if r.PathPrefix != "" && !strings.HasPrefix(req.URL.Path, r.PathPrefix) {
+		return false
+	}
+	if r.pathRegex != nil && !r.pathRegex.MatchString(req.URL.Path) {
+		return false
+	}
+	if len(r.Methods) > 0 {
+		isMethodMatched := false
+		for _, method := range r.Methods {
+			isMethodMatched = isMethodMatched || strings.EqualFold(method, req.Method)
+		}
+		if !isMethodMatched {
+			return false
+		}
+	}
+	if r.Host != "" {
+		host, _, err := net.SplitHostPort(req.Host)
+		if err != nil {
+			host = req.Host
+		}
+		if isMatched, _ := path.Match(strings.ToLower(r.Host), strings.ToLower(host)); !isMatched {
+			return false
+		}
+	}
+	for headerName, headerRegex := range r.headerRegexes {
+		if !headerRegex.MatchString(req.Header.Get(headerName)) {
+			return false
+		}
+	}
+	return true
+}
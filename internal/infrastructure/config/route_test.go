@@ -0,0 +1,93 @@
+//go:build unit
+// +build unit
+
+package config
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindRoute(t *testing.T) {
+	routes, err := ParseRoutes([]string{
+		`{"name": "users", "path-prefix": "/users", "methods": ["get"]}`,
+		`{"path-regex": "^/orders/\\d+$", "host": "*.example.com"}`,
+		`{"name": "beta", "headers": {"X-Beta": "^(1|true)$"}}`,
+	})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		method string
+		target string
+		header map[string]string
+		want   string
+	}{
+		{"GET", "http://localhost/users/1", nil, "users"},
+		{"POST", "http://localhost/users/1", nil, ""},
+		{"GET", "http://api.example.com:8080/orders/10", nil, "route-1"},
+		{"GET", "http://example.org/orders/10", nil, ""},
+		{"GET", "http://api.example.com/orders/abc", nil, ""},
+		{"DELETE", "http://localhost/any", map[string]string{"X-Beta": "true"}, "beta"},
+		{"DELETE", "http://localhost/any", map[string]string{"X-Beta": "false"}, ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			route := FindRoute(routes, req)
+			if tt.want == "" {
+				assert.Nil(t, route)
+				return
+			}
+			if assert.NotNil(t, route) {
+				assert.Equal(t, tt.want, route.Name)
+			}
+		})
+	}
+}
+
+func TestParseRoutes_Error(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{`{"path-prefix": "/api"`, "unexpected EOF"},
+		{`{"unknown-field": "/api"}`, `unknown field "unknown-field"`},
+		{`{"path-regex": "("}`, "regexp.Compile"},
+		{`{"headers": {"X-Test": "["}}`, "regexp.Compile"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.value, func(t *testing.T) {
+			t.Parallel()
+			_, err := ParseRoutes([]string{tt.value})
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestStartCommandConfig_SetFromRoute(t *testing.T) {
+	routes, err := ParseRoutes([]string{`{"options": {"remote-uri": "http://api", "throttle-rate-limit": 2.5, "transform-response-body-jq": [".data", ".id"], "additional-request-headers": "X-Test: 1"}}`})
+	assert.NoError(t, err)
+
+	cfg := GetStartCommandConfig()
+	assert.NoError(t, cfg.SetFromRoute(routes[0]))
+	assert.Equal(t, "http://api", cfg.RemoteURI.Value)
+	assert.Equal(t, 2.5, cfg.ThrottleRateLimit.Value)
+	assert.Equal(t, []string{".data", ".id"}, cfg.TransformResponseBodyJQ.Value)
+	assert.Equal(t, []string{"X-Test: 1"}, cfg.AdditionalRequestHeaders.Value)
+
+	for _, options := range []string{`{"unknown-option": 1}`, `{"routes": []}`, `{"remote-uri": ["a", "b"]}`, `{"admin-token": "secret"}`} {
+		routes, err = ParseRoutes([]string{`{"options": ` + options + `}`})
+		assert.NoError(t, err)
+		assert.Error(t, GetStartCommandConfig().SetFromRoute(routes[0]), options)
+	}
+}
@@ -2,10 +2,12 @@ package config
 
 import (
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
 	"github.com/facette/natsort"
 	"github.com/mgerasimchuk/protty/pkg/util"
 	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 	"net/http"
 	"net/url"
 	"os"
@@ -15,18 +17,99 @@ import (
 	"strings"
 )
 
+// ConfigFileVersion is the version of the config file format supported by the current build
+const ConfigFileVersion = 1
+
+// SecretMask replaces the values of the secret options (tagged with secret) in the logs and the admin API
+const SecretMask = "********"
+
+// AdminAPISource is the source of the option values set with the admin API
+const AdminAPISource = "admin API"
+
+// MirrorCompare option values
+const (
+	MirrorCompareStatus = "status"
+	MirrorCompareBody   = "body"
+)
+
 type StartCommandConfig struct {
-	LogLevel                  Option[string]   `default:"debug" description:"Verbosity level (panic, fatal, error, warn, info, debug, trace)"`
-	LocalPort                 Option[int]      `default:"80" description:"Listening port for the proxy"`
-	RemoteURI                 Option[string]   `default:"https://example.com:443" description:"URI of the remote resource"`
-	ThrottleRateLimit         Option[float64]  `description:"How many requests can be send to the remote resource per second"`
-	TransformRequestUrlSED    Option[string]   `description:"SED expression for request URL transformation"`
-	AdditionalRequestHeaders  Option[[]string] `description:"Array of additional request headers in format Header: Value"`
-	TransformRequestBodySED   Option[[]string] `description:"Pipeline of SED expressions for request body transformation"`
-	TransformRequestBodyJQ    Option[[]string] `description:"Pipeline of JQ expressions for request body transformation"`
-	AdditionalResponseHeaders Option[[]string] `description:"Array of additional response headers in format Header: Value"`
-	TransformResponseBodySED  Option[[]string] `description:"Pipeline of SED expressions for response body transformation"`
-	TransformResponseBodyJQ   Option[[]string] `description:"Pipeline of JQ expressions for response body transformation"`
+	Config                       Option[string]   `description:"Path to the YAML or JSON config file"`
+	ConfigWatchInterval          Option[int]      `default:"5" description:"Interval in seconds for checking the config file changes to reload the config, 0 disables the checking (SIGHUP signal reloads the config anyway)"`
+	LogLevel                     Option[string]   `default:"debug" description:"Verbosity level (panic, fatal, error, warn, info, debug, trace)"`
+	TraceLogBodyMaxSize          Option[int]      `description:"Max size in bytes of the request and response bodies in the trace level exchange logs, 0 disables the bodies logging"`
+	LocalPort                    Option[int]      `default:"80" description:"Listening port for the proxy"`
+	MetricsPort                  Option[int]      `description:"Listening port of the Prometheus metrics endpoint (/metrics), 0 disables the metrics"`
+	AdminPort                    Option[int]      `description:"Listening port of the admin HTTP API for inspecting and changing the runtime config, 0 disables the API"`
+	AdminToken                   Option[string]   `secret:"true" description:"Bearer token of the admin API requests (Authorization: Bearer <token>), empty disables the authentication"`
+	TracingEndpoint              Option[string]   `description:"OTLP/HTTP endpoint of the OpenTelemetry traces collector (e.g. http://localhost:4318/v1/traces, the path is /v1/traces if it's omitted), empty disables the tracing"`
+	TracingSampleRatio           Option[float64]  `default:"1" description:"Ratio of the traced requests from 0 to 1, the sampling decision of the incoming traceparent header is respected"`
+	TracingServiceName           Option[string]   `default:"protty" description:"Service name of the traces"`
+	RemoteURI                    Option[string]   `default:"https://example.com:443" description:"URI of the remote resource"`
+	UpstreamURIs                 Option[[]string] `description:"Array of upstream URIs in format URI or URI weight (e.g. http://10.0.0.1:8080 3), the requests are balanced between them instead of sending to the remote-uri one"`
+	LoadBalancing                Option[string]   `default:"round-robin" description:"Balancing of the requests between the upstream URIs: round-robin, weighted (smooth weighted round-robin), least-connections (the least in-flight requests per weight) or consistent-hash (by the load-balancing-hash-key)"`
+	LoadBalancingHashKey         Option[string]   `description:"Key of the consistent hashing in format header:Name or cookie:Name, the requests without the key are balanced in round-robin manner"`
+	HealthCheckPath              Option[string]   `description:"Path of the active HTTP health checks of the upstream URIs (2xx status codes are healthy, others eject the upstream until the next successful check), empty disables the checks"`
+	HealthCheckInterval          Option[int]      `default:"10" description:"Interval in seconds between the health checks"`
+	HealthCheckTimeout           Option[int]      `default:"2000" description:"Timeout in milliseconds of the health checks"`
+	EjectionFailures             Option[int]      `default:"5" description:"Number of the consecutive failures (connection errors and 5xx status codes) ejecting the upstream from the balancing, 0 disables the passive ejection"`
+	EjectionDuration             Option[int]      `default:"30" description:"Duration in seconds of the upstream ejection after the consecutive failures"`
+	CircuitBreakerFailureRatio   Option[float64]  `description:"Ratio (0-1) of the failed upstream requests (connection errors and 5xx status codes) in the window opening the circuit breaker of the upstream, 0 disables the circuit breakers"`
+	CircuitBreakerMinRequests    Option[int]      `default:"20" description:"Min number of the requests in the window to check the failure ratio"`
+	CircuitBreakerWindow         Option[int]      `default:"10" description:"Duration in seconds of the window the failure ratio is counted in"`
+	CircuitBreakerCooldown       Option[int]      `default:"30" description:"Duration in seconds of the open state (the requests are fast-failed), then the trial requests are sent in the half-open state"`
+	CircuitBreakerTrialRequests  Option[int]      `default:"1" description:"Number of the trial requests in the half-open state, the circuit breaker is closed if all of them succeed and opened again on any failure"`
+	CircuitBreakerStatus         Option[int]      `default:"503" description:"Status code of the fast-fail responses sent while the circuit breaker is open"`
+	CircuitBreakerBody           Option[string]   `description:"Body of the fast-fail responses"`
+	RetryAttempts                Option[int]      `description:"Max number of the retries of the failed upstream requests (connection errors and retry-statuses responses), 0 disables the retries"`
+	RetryStatuses                Option[string]   `default:"502,503,504" description:"Comma separated status codes, ranges and classes of the retried upstream responses, for example 429,5xx"`
+	RetryMethods                 Option[[]string] `default:"GET,HEAD,OPTIONS,TRACE,PUT,DELETE" description:"Array of the retried request methods (the idempotent ones by default), the requests with the Idempotency-Key header are retried regardless of the method"`
+	RetryBackoff                 Option[int]      `default:"100" description:"Base delay in milliseconds between the retries, it's doubled for every retry and randomized (full jitter), the Retry-After response header overrides it"`
+	RetryBackoffMax              Option[int]      `default:"5000" description:"Max delay in milliseconds between the retries, the responses with the longer Retry-After header aren't retried"`
+	ThrottleRateLimit            Option[float64]  `description:"How many requests can be send to the remote resource per second"`
+	FaultDelay                   Option[int]      `description:"Fixed latency in milliseconds added to every request before sending it to the remote resource"`
+	FaultDelayJitter             Option[int]      `description:"Max random latency in milliseconds added to the fixed one"`
+	FaultErrorPercentage         Option[float64]  `description:"Percentage (0-100) of the requests answered with the fault status and body without sending them to the remote resource"`
+	FaultErrorStatus             Option[int]      `default:"503" description:"Status code of the fault responses"`
+	FaultErrorBody               Option[string]   `description:"Body of the fault responses"`
+	FaultResetPercentage         Option[float64]  `description:"Percentage (0-100) of the responses with the connection aborted in the middle of the body"`
+	FaultTruncatePercentage      Option[float64]  `description:"Percentage (0-100) of the responses truncated to the half of the body (the Content-Length header is kept)"`
+	FaultSeed                    Option[int]      `description:"Seed of the fault injection random generator, 0 means the random seed. The faults sequence is reproducible for the same seed, since the proxy for the config is created"`
+	MockResponse                 Option[string]   `description:"Mock response in JSON format (status, headers, body, body-file, template and delay fields) sent without sending the request to the remote resource, usually it's set in the route options to mock the specific endpoints"`
+	Record                       Option[string]   `description:"Path of the HAR file to record the exchanges to (with the client and the upstream versions of the requests and responses), the file is truncated on start"`
+	Replay                       Option[string]   `description:"Path of the HAR file with the recorded exchanges to answer the requests instead of the remote resource, the requests are matched by method, path, query and body (the not matched ones get 502 status code)"`
+	MirrorURIs                   Option[[]string] `description:"Array of shadow URIs the transformed requests are asynchronously duplicated to, their responses are discarded"`
+	MirrorPercentage             Option[float64]  `default:"100" description:"Percentage (0-100) of the mirrored requests"`
+	MirrorTimeout                Option[int]      `default:"5000" description:"Timeout in milliseconds of the mirrored requests"`
+	MirrorCompare                Option[string]   `description:"Comparison of the shadow responses with the primary ones, the divergences are logged at warn level: status (status codes only) or body (status codes and bodies), empty disables the comparison"`
+	ReverseProxyCacheSize        Option[int]      `default:"100" description:"Max number of cached reverse proxies (one is created for every unique combination of the options, e.g. overridden by routes or request headers)"`
+	DecodedBodyMaxSize           Option[int]      `default:"33554432" description:"Max size in bytes of the decoded (decompressed) request and response bodies, the bodies exceeding it are passed without transforming, 0 disables the limit"`
+	TransformRequestUrlSED       Option[string]   `description:"SED expression for request URL transformation"`
+	AdditionalRequestQueryParams Option[[]string] `description:"Array of additional request query parameters in format name=value (the value is URL encoded by protty)"`
+	RemoveRequestQueryParams     Option[[]string] `description:"Array of request query parameter names to remove"`
+	SetRequestQueryParams        Option[[]string] `description:"Array of request query parameters in format name=value replacing the existing values (the value is URL encoded by protty)"`
+	RenameRequestQueryParams     Option[[]string] `description:"Array of request query parameter renamings in format old=new"`
+	TransformRequestQueryJQ      Option[[]string] `description:"Pipeline of JQ expressions for request query transformation (the query is passed as an object of the decoded value arrays)"`
+	AdditionalRequestHeaders     Option[[]string] `description:"Array of additional request headers in format Header: Value"`
+	RemoveRequestHeaders         Option[[]string] `description:"Array of request header names to remove"`
+	SetRequestHeaders            Option[[]string] `description:"Array of request headers in format Header: Value replacing the existing values"`
+	RenameRequestHeaders         Option[[]string] `description:"Array of request header renamings in format Old-Header: New-Header"`
+	TransformRequestHeadersSED   Option[[]string] `description:"Array of SED expressions for request header values transformation in format Header: expression"`
+	TransformRequestHeadersJQ    Option[[]string] `description:"Pipeline of JQ expressions for request headers transformation (headers are passed as an object of the value arrays)"`
+	TransformRequestBodySED      Option[[]string] `description:"Pipeline of SED expressions for request body transformation"`
+	TransformRequestBodyJQ       Option[[]string] `description:"Pipeline of JQ expressions for request body transformation"`
+	AdditionalResponseHeaders    Option[[]string] `description:"Array of additional response headers in format Header: Value"`
+	RemoveResponseHeaders        Option[[]string] `description:"Array of response header names to remove"`
+	SetResponseHeaders           Option[[]string] `description:"Array of response headers in format Header: Value replacing the existing values"`
+	RenameResponseHeaders        Option[[]string] `description:"Array of response header renamings in format Old-Header: New-Header"`
+	TransformResponseHeadersSED  Option[[]string] `description:"Array of SED expressions for response header values transformation in format Header: expression"`
+	TransformResponseHeadersJQ   Option[[]string] `description:"Pipeline of JQ expressions for response headers transformation (headers are passed as an object of the value arrays)"`
+	TransformResponseBodySED     Option[[]string] `description:"Pipeline of SED expressions for response body transformation"`
+	TransformResponseBodyJQ      Option[[]string] `description:"Pipeline of JQ expressions for response body transformation"`
+	RewriteResponseRules         Option[[]string] `description:"Array of response rewriting rules in JSON format (name, status, path, method, content-type and body conditions, set-status, set-body, set-headers and remove-headers actions), the matched rules are applied in order to the upstream response before the transformations"`
+	TransformJqOutput            Option[string]   `default:"raw" description:"Output of the JQ expressions results (raw - strings without quotes like jq -r, json - JSON encoded strings)"`
+	TransformJqResults           Option[string]   `default:"ndjson" description:"Output of the multiple JQ expression results (ndjson - one result per line like jq, array - JSON array of the results)"`
+	TransformDiffMaxSize         Option[int]      `description:"Max size in bytes of the diff logged at debug level for every transformation stage (structural diff for JQ), 0 disables the diff logging"`
+	Routes                       Option[[]string] `description:"Array of routing rules in JSON format (name, path-prefix, path-regex, methods, host, headers, options), options of the first matched rule override the config for the request"`
 }
 
 func GetStartCommandConfig() *StartCommandConfig {
@@ -47,9 +130,13 @@ func GetStartCommandConfig() *StartCommandConfig {
 			optDescriptionField.SetString(tagValue)
 		}
 
-		// Set default from struct tag
+		// Set default from struct tag, the slice defaults are comma separated
 		if tagValue := opt.Tag.Get("default"); tagValue != "" {
-			if err := setOptValue(&optValueField, tagValue); err != nil {
+			var val any = tagValue
+			if optValueField.Kind() == reflect.Slice {
+				val = strings.Split(tagValue, ",")
+			}
+			if err := setOptValue(&optValueField, val); err != nil {
 				panic(fmt.Sprintf("parsing default tag of option %s: %s", opt.Name, err))
 			}
 		}
@@ -64,26 +151,8 @@ func (c *StartCommandConfig) SetFromEnv() error {
 		optAddr := reflect.ValueOf(c).Elem().FieldByName(opt.Name).Addr()
 		optValueField := optAddr.Elem().FieldByName("Value")
 
-		// Lookup for the env variable (can be move to the separate function)
 		envName := optAddr.MethodByName("GetEnvName").Call([]reflect.Value{})[0].String()
-
-		if optValueField.Kind() == reflect.Slice {
-			envValuesSlice := []string{}
-			sortedEnviron := os.Environ()
-			natsort.Sort(sortedEnviron)
-			for _, envPair := range sortedEnviron {
-				envPairSlice := strings.Split(envPair, "=")
-				eName, eVal := envPairSlice[0], envPairSlice[1]
-				if isMatch, _ := regexp.MatchString(envName+`(_\d+)?$`, eName); isMatch {
-					envValuesSlice = append(envValuesSlice, eVal)
-				}
-			}
-			if len(envValuesSlice) > 0 {
-				if err := setOptValue(&optValueField, envValuesSlice); err != nil {
-					return fmt.Errorf("%s envName - %s: %w", util.GetFuncName(setOptValue), envName, err)
-				}
-			}
-		} else if val, ok := os.LookupEnv(envName); ok {
+		if val, ok := lookupEnv(envName, optValueField.Kind() == reflect.Slice); ok {
 			if err := setOptValue(&optValueField, val); err != nil {
 				return fmt.Errorf("%s envName - %s: %w", util.GetFuncName(setOptValue), envName, err)
 			}
@@ -92,8 +161,171 @@ func (c *StartCommandConfig) SetFromEnv() error {
 	return nil
 }
 
+// IsSetByEnv checks if the option with the flag name is set with the environment variable
+func (c *StartCommandConfig) IsSetByEnv(flagName string) bool {
+	optAddr, ok := c.getOptAddrsByFlagName()[flagName]
+	if !ok {
+		return false
+	}
+	envName := optAddr.MethodByName("GetEnvName").Call([]reflect.Value{})[0].String()
+	_, ok = lookupEnv(envName, optAddr.Elem().FieldByName("Value").Kind() == reflect.Slice)
+	return ok
+}
+
+// SetFromFile sets options from the YAML or JSON config file, where keys are option flag names.
+// Options with higher priority sources (isSet returns true, e.g. for the environment variables or CLI flags) are skipped
+func (c *StartCommandConfig) SetFromFile(path string, isSet func(flagName string) bool) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", util.GetFuncName(os.ReadFile), err)
+	}
+	root := yaml.Node{}
+	if err = yaml.Unmarshal(content, &root); err != nil {
+		return fmt.Errorf("%s: %s: %w", path, util.GetFuncName(yaml.Unmarshal), err)
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: the config file should contain a mapping of options", path)
+	}
+	nodes := root.Content[0].Content
+
+	version := -1
+	for i := 0; i < len(nodes); i += 2 {
+		if nodes[i].Value == "version" {
+			if version, err = strconv.Atoi(nodes[i+1].Value); err != nil || version != ConfigFileVersion {
+				return fmt.Errorf("%s:%d: unsupported config file version '%s', expected %d", path, nodes[i+1].Line, nodes[i+1].Value, ConfigFileVersion)
+			}
+		}
+	}
+	if version == -1 {
+		return fmt.Errorf("%s: the version field is required, the current one is %d", path, ConfigFileVersion)
+	}
+
+	optAddrs := c.getOptAddrsByFlagName()
+	for i := 0; i < len(nodes); i += 2 {
+		keyNode, valueNode := nodes[i], nodes[i+1]
+		if keyNode.Value == "version" {
+			continue
+		}
+		optAddr, ok := optAddrs[keyNode.Value]
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown option '%s'", path, keyNode.Line, keyNode.Value)
+		}
+		if keyNode.Value == c.Config.GetFlagName() {
+			return fmt.Errorf("%s:%d: option '%s' can't be set in the config file", path, keyNode.Line, keyNode.Value)
+		}
+		if isSet(keyNode.Value) {
+			continue
+		}
+		optValueField := optAddr.Elem().FieldByName("Value")
+
+		val, err := getYAMLNodeValue(valueNode, optValueField.Kind() == reflect.Slice)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, valueNode.Line, keyNode.Value, err)
+		}
+		if err = setOptValue(&optValueField, val); err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, valueNode.Line, keyNode.Value, err)
+		}
+		optAddr.Elem().FieldByName("Source").SetString(fmt.Sprintf("%s:%d", path, valueNode.Line))
+	}
+	return nil
+}
+
+// SetFromRoute overrides options with the route ones
+func (c *StartCommandConfig) SetFromRoute(route Route) error {
+	optAddrs := c.getOptAddrsByFlagName()
+	for flagName, value := range route.Options {
+		optAddr, ok := optAddrs[flagName]
+		if !ok {
+			return fmt.Errorf("route %s: unknown option '%s'", route.Name, flagName)
+		}
+		if c.isStartOnlyOption(flagName) || flagName == c.Routes.GetFlagName() || flagName == c.ReverseProxyCacheSize.GetFlagName() ||
+			flagName == c.AdminToken.GetFlagName() {
+			return fmt.Errorf("route %s: option '%s' can't be set in the route", route.Name, flagName)
+		}
+		optValueField := optAddr.Elem().FieldByName("Value")
+		val, err := getJSONValue(value, optValueField.Kind() == reflect.Slice)
+		if err != nil {
+			return fmt.Errorf("route %s: %s: %w", route.Name, flagName, err)
+		}
+		if err = setOptValue(&optValueField, val); err != nil {
+			return fmt.Errorf("route %s: %s: %w", route.Name, flagName, err)
+		}
+	}
+	return nil
+}
+
+// SetFromAdminAPI overrides options with the admin API ones, keys are option flag names.
+// The options applied on the start only (e.g. the listening ports) can't be changed
+func (c *StartCommandConfig) SetFromAdminAPI(options map[string]any) error {
+	optAddrs := c.getOptAddrsByFlagName()
+	for flagName, value := range options {
+		optAddr, ok := optAddrs[flagName]
+		if !ok {
+			return fmt.Errorf("unknown option '%s'", flagName)
+		}
+		if c.isStartOnlyOption(flagName) {
+			return fmt.Errorf("option '%s' can't be changed without restart", flagName)
+		}
+		optValueField := optAddr.Elem().FieldByName("Value")
+		val, err := getJSONValue(value, optValueField.Kind() == reflect.Slice)
+		if err != nil {
+			return fmt.Errorf("%s: %w", flagName, err)
+		}
+		if err = setOptValue(&optValueField, val); err != nil {
+			return fmt.Errorf("%s: %w", flagName, err)
+		}
+		optAddr.Elem().FieldByName("Source").SetString(AdminAPISource)
+	}
+	return nil
+}
+
+// GetValuesByFlagName returns the option values by the flag names, the same as they're set in the config file
+func (c *StartCommandConfig) GetValuesByFlagName() map[string]any {
+	values := map[string]any{}
+	for flagName, optAddr := range c.getOptAddrsByFlagName() {
+		value := optAddr.Elem().FieldByName("Value").Interface()
+		if slice, ok := value.([]string); ok && slice == nil {
+			value = []string{}
+		}
+		values[flagName] = value
+	}
+	return values
+}
+
+// GetMasked returns the copy of the config with the non-empty secret options masked, it's used for the logging
+func (c *StartCommandConfig) GetMasked() *StartCommandConfig {
+	masked := *c
+	e := reflect.ValueOf(masked)
+	for i := 0; i < e.NumField(); i++ {
+		if e.Type().Field(i).Tag.Get("secret") != "true" {
+			continue
+		}
+		optValueField := reflect.ValueOf(&masked).Elem().Field(i).FieldByName("Value")
+		if optValueField.String() != "" {
+			optValueField.SetString(SecretMask)
+		}
+	}
+	return &masked
+}
+
+// isStartOnlyOption returns true if the option is applied on the start only, so it can't be changed in the runtime
+func (c *StartCommandConfig) isStartOnlyOption(flagName string) bool {
+	for _, opt := range []string{
+		c.Config.GetFlagName(), c.LocalPort.GetFlagName(), c.MetricsPort.GetFlagName(), c.AdminPort.GetFlagName(), c.Record.GetFlagName(),
+		c.Replay.GetFlagName(), c.TracingEndpoint.GetFlagName(), c.TracingSampleRatio.GetFlagName(), c.TracingServiceName.GetFlagName(),
+	} {
+		if flagName == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// SetFromHTTPRequestHeaders overrides the options with the request headers ones, it returns the field names of the
+// overridden options to validate them only (see ValidateOptions)
 // TODO add availableInRuntime mapstructure flag and based on this flag throw the error if the user try to change cfg for this field through the http headers
-func (c *StartCommandConfig) SetFromHTTPRequestHeaders(header http.Header, logger *logrus.Logger) error {
+func (c *StartCommandConfig) SetFromHTTPRequestHeaders(header http.Header, logger *logrus.Logger) ([]string, error) {
+	var options []string
 	e := reflect.ValueOf(*c)
 	for i := 0; i < e.NumField(); i++ {
 		opt := e.Type().Field(i)
@@ -102,23 +334,53 @@ func (c *StartCommandConfig) SetFromHTTPRequestHeaders(header http.Header, logge
 
 		headerName := optAddr.MethodByName("GetHeaderName").Call([]reflect.Value{})[0].String()
 		if values := header.Values(headerName); len(values) > 0 {
+			if headerName == c.MockResponse.GetHeaderName() {
+				if err := checkRequestHeaderMockResponse(values[0]); err != nil {
+					return options, fmt.Errorf("%s headerName - %s: %w", util.GetFuncName(checkRequestHeaderMockResponse), headerName, err)
+				}
+			}
+			if strings.HasSuffix(opt.Name, "SED") {
+				if err := checkRequestHeaderSED(opt.Name, values); err != nil {
+					return options, fmt.Errorf("%s headerName - %s: %w", util.GetFuncName(checkRequestHeaderSED), headerName, err)
+				}
+			}
 			if err := setOptValueFromHTTPRequestHeader(&optValueField, values); err != nil {
-				return fmt.Errorf("%s headerName - %s: %w", util.GetFuncName(setOptValueFromHTTPRequestHeader), headerName, err)
+				return options, fmt.Errorf("%s headerName - %s: %w", util.GetFuncName(setOptValueFromHTTPRequestHeader), headerName, err)
 			}
+			options = append(options, opt.Name)
 			if logger != nil {
-				logger.Debugf("%s config value has been changed to `%v' based on %s request header", opt.Name, values, headerName)
+				var loggedValues any = values
+				if opt.Tag.Get("secret") == "true" {
+					loggedValues = SecretMask
+				}
+				logger.Debugf("%s config value has been changed to `%v' based on %s request header", opt.Name, loggedValues, headerName)
 			}
 		}
 	}
-	return nil
+	return options, nil
 }
 
+// Validate checks all the options, the routes (every route config is validated as a whole) and that all the options
+// have been added to the CLI flags
 func (c *StartCommandConfig) Validate() error {
-	if _, err := logrus.ParseLevel(c.LogLevel.Value); err != nil {
-		return fmt.Errorf("%s: %w", util.GetFuncName(logrus.ParseLevel), err)
+	for _, check := range optionChecks {
+		if err := check.check(c); err != nil {
+			return err
+		}
 	}
-	if _, err := url.Parse(c.RemoteURI.Value); err != nil {
-		return fmt.Errorf("%s: %w", util.GetFuncName(url.Parse), err)
+	routes, err := ParseRoutes(c.Routes.Value)
+	if err != nil {
+		return c.Routes.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(ParseRoutes), err))
+	}
+	for _, route := range routes {
+		routeCfg := *c
+		routeCfg.Routes.Value = nil
+		if err = routeCfg.SetFromRoute(route); err != nil {
+			return c.Routes.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(routeCfg.SetFromRoute), err))
+		}
+		if err = routeCfg.Validate(); err != nil {
+			return c.Routes.WrapError(fmt.Errorf("route %s: %w", route.Name, err))
+		}
 	}
 	e := reflect.ValueOf(*c)
 	for i := 0; i < e.NumField(); i++ {
@@ -132,6 +394,321 @@ func (c *StartCommandConfig) Validate() error {
 	return nil
 }
 
+// optionCheck validates the options, it's run on the validation of any of them
+type optionCheck struct {
+	options []string // field names of the options
+	check   func(c *StartCommandConfig) error
+}
+
+// optionChecks are run by Validate in this order, ValidateOptions runs the checks of the changed options only
+var optionChecks = []optionCheck{
+	{[]string{"LogLevel"}, func(c *StartCommandConfig) error {
+		if _, err := logrus.ParseLevel(c.LogLevel.Value); err != nil {
+			return c.LogLevel.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(logrus.ParseLevel), err))
+		}
+		return nil
+	}},
+	{[]string{"RemoteURI"}, func(c *StartCommandConfig) error {
+		if _, err := url.Parse(c.RemoteURI.Value); err != nil {
+			return c.RemoteURI.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(url.Parse), err))
+		}
+		return nil
+	}},
+	{[]string{"MetricsPort"}, func(c *StartCommandConfig) error {
+		if c.MetricsPort.Value < 0 || c.MetricsPort.Value > 65535 {
+			return c.MetricsPort.WrapError(fmt.Errorf("should be between 0 and 65535"))
+		}
+		return nil
+	}},
+	{[]string{"AdminPort"}, func(c *StartCommandConfig) error {
+		if c.AdminPort.Value < 0 || c.AdminPort.Value > 65535 {
+			return c.AdminPort.WrapError(fmt.Errorf("should be between 0 and 65535"))
+		}
+		return nil
+	}},
+	{[]string{"TracingEndpoint"}, func(c *StartCommandConfig) error {
+		if c.TracingEndpoint.Value != "" {
+			if u, err := url.Parse(c.TracingEndpoint.Value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return c.TracingEndpoint.WrapError(fmt.Errorf("%q isn't a valid http(s) URI", c.TracingEndpoint.Value))
+			}
+		}
+		return nil
+	}},
+	{[]string{"TracingSampleRatio"}, func(c *StartCommandConfig) error {
+		if c.TracingSampleRatio.Value < 0 || c.TracingSampleRatio.Value > 1 {
+			return c.TracingSampleRatio.WrapError(fmt.Errorf("should be between 0 and 1"))
+		}
+		return nil
+	}},
+	{[]string{"ConfigWatchInterval"}, func(c *StartCommandConfig) error {
+		if c.ConfigWatchInterval.Value < 0 {
+			return c.ConfigWatchInterval.WrapError(fmt.Errorf("should be greater than or equal to 0"))
+		}
+		return nil
+	}},
+	{[]string{"TraceLogBodyMaxSize"}, func(c *StartCommandConfig) error {
+		if c.TraceLogBodyMaxSize.Value < 0 {
+			return c.TraceLogBodyMaxSize.WrapError(fmt.Errorf("should be greater than or equal to 0"))
+		}
+		return nil
+	}},
+	{[]string{"UpstreamURIs"}, func(c *StartCommandConfig) error {
+		for _, upstream := range c.UpstreamURIs.Value {
+			if _, err := ParseUpstream(upstream); err != nil {
+				return c.UpstreamURIs.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(ParseUpstream), err))
+			}
+		}
+		return nil
+	}},
+	{[]string{"LoadBalancing", "LoadBalancingHashKey"}, func(c *StartCommandConfig) error {
+		switch c.LoadBalancing.Value {
+		case LoadBalancingRoundRobin, LoadBalancingWeighted, LoadBalancingLeastConnections:
+		case LoadBalancingConsistentHash:
+			if c.LoadBalancingHashKey.Value == "" {
+				return c.LoadBalancingHashKey.WrapError(fmt.Errorf("should be set for %s balancing", LoadBalancingConsistentHash))
+			}
+		default:
+			return c.LoadBalancing.WrapError(fmt.Errorf("should be %s, %s, %s or %s",
+				LoadBalancingRoundRobin, LoadBalancingWeighted, LoadBalancingLeastConnections, LoadBalancingConsistentHash))
+		}
+		if c.LoadBalancingHashKey.Value != "" {
+			if _, _, err := ParseHashKey(c.LoadBalancingHashKey.Value); err != nil {
+				return c.LoadBalancingHashKey.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(ParseHashKey), err))
+			}
+		}
+		return nil
+	}},
+	{[]string{"HealthCheckPath"}, func(c *StartCommandConfig) error {
+		if c.HealthCheckPath.Value != "" && !strings.HasPrefix(c.HealthCheckPath.Value, "/") {
+			return c.HealthCheckPath.WrapError(fmt.Errorf("should start with /"))
+		}
+		return nil
+	}},
+	{[]string{"HealthCheckInterval", "HealthCheckTimeout", "EjectionDuration"}, func(c *StartCommandConfig) error {
+		for _, opt := range []Option[int]{c.HealthCheckInterval, c.HealthCheckTimeout, c.EjectionDuration} {
+			if opt.Value < 1 {
+				return opt.WrapError(fmt.Errorf("should be greater than 0"))
+			}
+		}
+		return nil
+	}},
+	{[]string{"CircuitBreakerFailureRatio"}, func(c *StartCommandConfig) error {
+		if c.CircuitBreakerFailureRatio.Value < 0 || c.CircuitBreakerFailureRatio.Value > 1 {
+			return c.CircuitBreakerFailureRatio.WrapError(fmt.Errorf("should be between 0 and 1"))
+		}
+		return nil
+	}},
+	{[]string{"CircuitBreakerMinRequests", "CircuitBreakerWindow", "CircuitBreakerCooldown", "CircuitBreakerTrialRequests"}, func(c *StartCommandConfig) error {
+		for _, opt := range []Option[int]{c.CircuitBreakerMinRequests, c.CircuitBreakerWindow, c.CircuitBreakerCooldown, c.CircuitBreakerTrialRequests} {
+			if opt.Value < 1 {
+				return opt.WrapError(fmt.Errorf("should be greater than 0"))
+			}
+		}
+		return nil
+	}},
+	{[]string{"CircuitBreakerStatus"}, func(c *StartCommandConfig) error {
+		if c.CircuitBreakerStatus.Value < 100 || c.CircuitBreakerStatus.Value > 999 {
+			return c.CircuitBreakerStatus.WrapError(fmt.Errorf("invalid status code %d", c.CircuitBreakerStatus.Value))
+		}
+		return nil
+	}},
+	{[]string{"RetryAttempts"}, func(c *StartCommandConfig) error {
+		if c.RetryAttempts.Value < 0 {
+			return c.RetryAttempts.WrapError(fmt.Errorf("should be greater than or equal to 0"))
+		}
+		return nil
+	}},
+	{[]string{"RetryStatuses"}, func(c *StartCommandConfig) error {
+		if _, err := ParseStatusRanges(c.RetryStatuses.Value); err != nil {
+			return c.RetryStatuses.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(ParseStatusRanges), err))
+		}
+		return nil
+	}},
+	{[]string{"RetryBackoff", "RetryBackoffMax"}, func(c *StartCommandConfig) error {
+		if c.RetryBackoff.Value < 0 {
+			return c.RetryBackoff.WrapError(fmt.Errorf("should be greater than or equal to 0"))
+		}
+		if c.RetryBackoffMax.Value < c.RetryBackoff.Value {
+			return c.RetryBackoffMax.WrapError(fmt.Errorf("should be greater than or equal to %s", c.RetryBackoff.GetFlagName()))
+		}
+		return nil
+	}},
+	{[]string{"EjectionFailures"}, func(c *StartCommandConfig) error {
+		if c.EjectionFailures.Value < 0 {
+			return c.EjectionFailures.WrapError(fmt.Errorf("should be greater than or equal to 0"))
+		}
+		return nil
+	}},
+	{[]string{"FaultDelay", "FaultDelayJitter"}, func(c *StartCommandConfig) error {
+		for _, opt := range []Option[int]{c.FaultDelay, c.FaultDelayJitter} {
+			if opt.Value < 0 {
+				return opt.WrapError(fmt.Errorf("should be greater than or equal to 0"))
+			}
+		}
+		return nil
+	}},
+	{[]string{"FaultErrorPercentage", "FaultResetPercentage", "FaultTruncatePercentage"}, func(c *StartCommandConfig) error {
+		for _, opt := range []Option[float64]{c.FaultErrorPercentage, c.FaultResetPercentage, c.FaultTruncatePercentage} {
+			if opt.Value < 0 || opt.Value > 100 {
+				return opt.WrapError(fmt.Errorf("should be between 0 and 100"))
+			}
+		}
+		return nil
+	}},
+	{[]string{"FaultErrorStatus"}, func(c *StartCommandConfig) error {
+		if c.FaultErrorStatus.Value < 100 || c.FaultErrorStatus.Value > 999 {
+			return c.FaultErrorStatus.WrapError(fmt.Errorf("invalid status code %d", c.FaultErrorStatus.Value))
+		}
+		return nil
+	}},
+	{[]string{"MirrorURIs"}, func(c *StartCommandConfig) error {
+		for _, mirrorURI := range c.MirrorURIs.Value {
+			if u, err := url.Parse(mirrorURI); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return c.MirrorURIs.WrapError(fmt.Errorf("%q isn't a valid http(s) URI", mirrorURI))
+			}
+		}
+		return nil
+	}},
+	{[]string{"MirrorPercentage"}, func(c *StartCommandConfig) error {
+		if c.MirrorPercentage.Value < 0 || c.MirrorPercentage.Value > 100 {
+			return c.MirrorPercentage.WrapError(fmt.Errorf("should be between 0 and 100"))
+		}
+		return nil
+	}},
+	{[]string{"MirrorTimeout"}, func(c *StartCommandConfig) error {
+		if c.MirrorTimeout.Value < 1 {
+			return c.MirrorTimeout.WrapError(fmt.Errorf("should be greater than 0"))
+		}
+		return nil
+	}},
+	{[]string{"MirrorCompare"}, func(c *StartCommandConfig) error {
+		if c.MirrorCompare.Value != "" && c.MirrorCompare.Value != MirrorCompareStatus && c.MirrorCompare.Value != MirrorCompareBody {
+			return c.MirrorCompare.WrapError(fmt.Errorf("should be %s, %s or empty", MirrorCompareStatus, MirrorCompareBody))
+		}
+		return nil
+	}},
+	{[]string{"MockResponse"}, func(c *StartCommandConfig) error {
+		if c.MockResponse.Value != "" {
+			if _, err := ParseMockResponse(c.MockResponse.Value); err != nil {
+				return c.MockResponse.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(ParseMockResponse), err))
+			}
+		}
+		return nil
+	}},
+	{[]string{"TransformJqOutput"}, func(c *StartCommandConfig) error {
+		if err := (util.JQOptions{Output: c.TransformJqOutput.Value}).Validate(); err != nil {
+			return c.TransformJqOutput.WrapError(err)
+		}
+		return nil
+	}},
+	{[]string{"TransformJqResults"}, func(c *StartCommandConfig) error {
+		if err := (util.JQOptions{Results: c.TransformJqResults.Value}).Validate(); err != nil {
+			return c.TransformJqResults.WrapError(err)
+		}
+		return nil
+	}},
+	{[]string{"TransformRequestUrlSED"}, func(c *StartCommandConfig) error {
+		if c.TransformRequestUrlSED.Value != "" {
+			if err := compileSED(c.TransformRequestUrlSED.Value); err != nil {
+				return c.TransformRequestUrlSED.WrapError(err)
+			}
+		}
+		return nil
+	}},
+	{[]string{"AdditionalRequestQueryParams", "SetRequestQueryParams", "RenameRequestQueryParams"}, func(c *StartCommandConfig) error {
+		for _, opt := range []Option[[]string]{c.AdditionalRequestQueryParams, c.SetRequestQueryParams, c.RenameRequestQueryParams} {
+			for _, param := range opt.Value {
+				if _, _, err := util.ParseQueryParam(param); err != nil {
+					return opt.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.ParseQueryParam), err))
+				}
+			}
+		}
+		return nil
+	}},
+	{[]string{
+		"AdditionalRequestHeaders", "SetRequestHeaders", "RenameRequestHeaders",
+		"AdditionalResponseHeaders", "SetResponseHeaders", "RenameResponseHeaders",
+	}, func(c *StartCommandConfig) error {
+		for _, opt := range []Option[[]string]{
+			c.AdditionalRequestHeaders, c.SetRequestHeaders, c.RenameRequestHeaders,
+			c.AdditionalResponseHeaders, c.SetResponseHeaders, c.RenameResponseHeaders,
+		} {
+			for _, header := range opt.Value {
+				if _, _, err := util.ParseHeader(header); err != nil {
+					return opt.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.ParseHeader), err))
+				}
+			}
+		}
+		return nil
+	}},
+	newTransformStagesCheck("TransformRequestQueryJQ", func(c *StartCommandConfig) Option[[]string] { return c.TransformRequestQueryJQ }, false, compileJQ),
+	newTransformStagesCheck("TransformRequestHeadersSED", func(c *StartCommandConfig) Option[[]string] { return c.TransformRequestHeadersSED }, false, compileHeaderSED),
+	newTransformStagesCheck("TransformRequestHeadersJQ", func(c *StartCommandConfig) Option[[]string] { return c.TransformRequestHeadersJQ }, false, compileJQ),
+	newTransformStagesCheck("TransformRequestBodySED", func(c *StartCommandConfig) Option[[]string] { return c.TransformRequestBodySED }, false, compileSED),
+	newTransformStagesCheck("TransformRequestBodyJQ", func(c *StartCommandConfig) Option[[]string] { return c.TransformRequestBodyJQ }, false, compileJQ),
+	newTransformStagesCheck("TransformResponseHeadersSED", func(c *StartCommandConfig) Option[[]string] { return c.TransformResponseHeadersSED }, true, compileHeaderSED),
+	newTransformStagesCheck("TransformResponseHeadersJQ", func(c *StartCommandConfig) Option[[]string] { return c.TransformResponseHeadersJQ }, true, compileJQ),
+	newTransformStagesCheck("TransformResponseBodySED", func(c *StartCommandConfig) Option[[]string] { return c.TransformResponseBodySED }, true, compileSED),
+	newTransformStagesCheck("TransformResponseBodyJQ", func(c *StartCommandConfig) Option[[]string] { return c.TransformResponseBodyJQ }, true, compileJQ),
+	{[]string{"RewriteResponseRules"}, func(c *StartCommandConfig) error {
+		for _, rule := range c.RewriteResponseRules.Value {
+			if _, err := ParseRewriteRule(rule); err != nil {
+				return c.RewriteResponseRules.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(ParseRewriteRule), err))
+			}
+		}
+		return nil
+	}},
+	{[]string{"TransformDiffMaxSize"}, func(c *StartCommandConfig) error {
+		if c.TransformDiffMaxSize.Value < 0 {
+			return c.TransformDiffMaxSize.WrapError(fmt.Errorf("should be greater than or equal to 0"))
+		}
+		return nil
+	}},
+	{[]string{"ReverseProxyCacheSize"}, func(c *StartCommandConfig) error {
+		if c.ReverseProxyCacheSize.Value < 1 {
+			return c.ReverseProxyCacheSize.WrapError(fmt.Errorf("should be greater than 0"))
+		}
+		return nil
+	}},
+	{[]string{"DecodedBodyMaxSize"}, func(c *StartCommandConfig) error {
+		if c.DecodedBodyMaxSize.Value < 0 {
+			return c.DecodedBodyMaxSize.WrapError(fmt.Errorf("should be greater than or equal to 0"))
+		}
+		return nil
+	}},
+}
+
+// newTransformStagesCheck returns the check of the transformation pipeline option
+func newTransformStagesCheck(name string, getOpt func(c *StartCommandConfig) Option[[]string], isResponse bool, compile func(expr string) error) optionCheck {
+	return optionCheck{[]string{name}, func(c *StartCommandConfig) error {
+		opt := getOpt(c)
+		if err := validateTransformStages(opt.Value, isResponse, compile); err != nil {
+			return opt.WrapError(err)
+		}
+		return nil
+	}}
+}
+
+// ValidateOptions runs the checks of the options only (e.g. the ones changed by the request headers), the options are
+// the field names. The routes aren't validated
+func (c *StartCommandConfig) ValidateOptions(options []string) error {
+	isValidated := make(map[string]bool, len(options))
+	for _, option := range options {
+		isValidated[option] = true
+	}
+	for _, check := range optionChecks {
+		for _, option := range check.options {
+			if isValidated[option] {
+				if err := check.check(c); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
 func (c *StartCommandConfig) GetLogLevelLogrus() logrus.Level {
 	logLevel, _ := logrus.ParseLevel(c.LogLevel.Value)
 	return logLevel
@@ -149,6 +726,16 @@ func (c *StartCommandConfig) GetStateHash() string {
 	return fmt.Sprintf("%x", md5.Sum([]byte(fieldsDump)))
 }
 
+func (c *StartCommandConfig) getOptAddrsByFlagName() map[string]reflect.Value {
+	optAddrs := map[string]reflect.Value{}
+	e := reflect.ValueOf(*c)
+	for i := 0; i < e.NumField(); i++ {
+		optAddr := reflect.ValueOf(c).Elem().Field(i).Addr()
+		optAddrs[optAddr.MethodByName("GetFlagName").Call([]reflect.Value{})[0].String()] = optAddr
+	}
+	return optAddrs
+}
+
 // TODO refactor "val any" to generic should accept string and []string
 func setOptValue(optValue *reflect.Value, val any) error {
 	switch optValue.Kind() {
@@ -176,9 +763,181 @@ func setOptValue(optValue *reflect.Value, val any) error {
 	return nil
 }
 
+// lookupEnv returns string for the scalar options and []string for the slice options (collected from ENV_NAME, ENV_NAME_0, ENV_NAME_1, etc.)
+func lookupEnv(envName string, isSlice bool) (any, bool) {
+	if !isSlice {
+		return os.LookupEnv(envName)
+	}
+	envValuesSlice := []string{}
+	sortedEnviron := os.Environ()
+	natsort.Sort(sortedEnviron)
+	for _, envPair := range sortedEnviron {
+		envPairSlice := strings.Split(envPair, "=")
+		eName, eVal := envPairSlice[0], envPairSlice[1]
+		if isMatch, _ := regexp.MatchString(envName+`(_\d+)?$`, eName); isMatch {
+			envValuesSlice = append(envValuesSlice, eVal)
+		}
+	}
+	return envValuesSlice, len(envValuesSlice) > 0
+}
+
+// getYAMLNodeValue returns string for the scalar options and []string for the slice options
+func getYAMLNodeValue(node *yaml.Node, isSlice bool) (any, error) {
+	switch {
+	case node.Kind == yaml.ScalarNode && !isSlice:
+		return node.Value, nil
+	case node.Kind == yaml.MappingNode && !isSlice:
+		return getYAMLNodeJSON(node)
+	case node.Kind == yaml.ScalarNode && isSlice:
+		return []string{node.Value}, nil
+	case node.Kind == yaml.SequenceNode && isSlice:
+		values := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind == yaml.ScalarNode {
+				values = append(values, item.Value)
+				continue
+			}
+			itemJSON, err := getYAMLNodeJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, itemJSON)
+		}
+		return values, nil
+	}
+	if isSlice {
+		return nil, fmt.Errorf("unexpected value type, expected an array or a scalar value")
+	}
+	return nil, fmt.Errorf("unexpected value type, expected a scalar value or an object")
+}
+
+// getYAMLNodeJSON returns the object (for example a route or a mock response) in JSON format, they are stored so
+func getYAMLNodeJSON(node *yaml.Node) (string, error) {
+	var value any
+	if err := node.Decode(&value); err != nil {
+		return "", fmt.Errorf("line %d: %s: %w", node.Line, util.GetFuncName(node.Decode), err)
+	}
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("line %d: %s: %w", node.Line, util.GetFuncName(json.Marshal), err)
+	}
+	return string(valueJSON), nil
+}
+
+// getJSONValue returns string for the scalar options and []string for the slice options
+func getJSONValue(value any, isSlice bool) (any, error) {
+	items, isArray := value.([]any)
+	if isArray && !isSlice {
+		return nil, fmt.Errorf("unexpected value type, expected a scalar value")
+	}
+	if !isArray {
+		scalar, err := getJSONScalarValue(value)
+		if err != nil || !isSlice {
+			return scalar, err
+		}
+		return []string{scalar}, nil
+	}
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		scalar, err := getJSONScalarValue(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, scalar)
+	}
+	return values, nil
+}
+
+func getJSONScalarValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case map[string]any:
+		valueJSON, err := json.Marshal(v)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", util.GetFuncName(json.Marshal), err)
+		}
+		return string(valueJSON), nil
+	}
+	return "", fmt.Errorf("unexpected value type %T", value)
+}
+
 func setOptValueFromHTTPRequestHeader(optValue *reflect.Value, val []string) error {
 	if optValue.Kind() == reflect.Slice {
 		return setOptValue(optValue, val)
 	}
 	return setOptValue(optValue, val[0])
 }
+
+// validateTransformStages parses the pipeline stages and compiles their expressions
+func validateTransformStages(values []string, isResponse bool, compile func(expr string) error) error {
+	for _, value := range values {
+		stage, err := ParseTransformStage(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", util.GetFuncName(ParseTransformStage), err)
+		}
+		if !isResponse && stage.HasStatusCondition() {
+			return fmt.Errorf("status condition is available for the response stages only")
+		}
+		if err = compile(stage.Expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compileSED(sedExpr string) error {
+	if _, err := util.CompileSED(sedExpr); err != nil {
+		return fmt.Errorf("%s: %w", util.GetFuncName(util.CompileSED), err)
+	}
+	return nil
+}
+
+// checkRequestHeaderSED rejects the SED file commands (r and w) in the expressions of the option set by the request
+// header, they can be set by the trusted sources only (config file, env variables, flags and routes)
+func checkRequestHeaderSED(optName string, values []string) error {
+	for _, value := range values {
+		sedExpr := value
+		switch optName {
+		case "TransformRequestHeadersSED", "TransformResponseHeadersSED":
+			var err error
+			if _, sedExpr, err = util.ParseHeader(value); err != nil {
+				return fmt.Errorf("%s: %w", util.GetFuncName(util.ParseHeader), err)
+			}
+		case "TransformRequestBodySED", "TransformResponseBodySED":
+			stage, err := ParseTransformStage(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", util.GetFuncName(ParseTransformStage), err)
+			}
+			sedExpr = stage.Expr
+		}
+		if util.HasSEDFileCommands(sedExpr) {
+			return fmt.Errorf("r and w SED commands can't be set by the request header")
+		}
+	}
+	return nil
+}
+
+func compileHeaderSED(header string) error {
+	_, sedExpr, err := util.ParseHeader(header)
+	if err != nil {
+		return fmt.Errorf("%s: %w", util.GetFuncName(util.ParseHeader), err)
+	}
+	return compileSED(sedExpr)
+}
+
+func compileJQ(jqExpr string) error {
+	if _, err := util.CompileJQ(jqExpr); err != nil {
+		return fmt.Errorf("%s: %w", util.GetFuncName(util.CompileJQ), err)
+	}
+	return nil
+}
+
+// GetJQOptions returns the output options of the JQ expressions
+func (c *StartCommandConfig) GetJQOptions() util.JQOptions {
+	return util.JQOptions{Output: c.TransformJqOutput.Value, Results: c.TransformJqResults.Value}
+}
@@ -1 +1,321 @@
+//go:build unit
+// +build unit
+
 package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStartCommandConfig_SliceDefault(t *testing.T) {
+	cfg := GetStartCommandConfig()
+	assert.Equal(t, []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"}, cfg.RetryMethods.Value)
+	assert.Empty(t, cfg.UpstreamURIs.Value)
+}
+
+func TestStartCommandConfig_SetFromAdminAPI(t *testing.T) {
+	cfg := GetStartCommandConfig()
+	assert.NoError(t, cfg.SetFromAdminAPI(map[string]any{"remote-uri": "http://api", "retry-attempts": float64(3), "retry-methods": []any{"GET", "POST"}}))
+	assert.Equal(t, "http://api", cfg.RemoteURI.Value)
+	assert.Equal(t, 3, cfg.RetryAttempts.Value)
+	assert.Equal(t, []string{"GET", "POST"}, cfg.RetryMethods.Value)
+	assert.Equal(t, AdminAPISource, cfg.RetryAttempts.Source)
+
+	for _, options := range []map[string]any{{"unknown-option": 1}, {"local-port": float64(8080)}, {"tracing-endpoint": "http://collector"}, {"remote-uri": []any{"a", "b"}}} {
+		assert.Error(t, GetStartCommandConfig().SetFromAdminAPI(options), options)
+	}
+}
+
+func TestStartCommandConfig_SetFromHTTPRequestHeaders_SED(t *testing.T) {
+	tests := []struct {
+		msg     string
+		header  http.Header
+		wantErr bool
+	}{
+		{"URL substitution", http.Header{"X-Protty-Transform-Request-Url-Sed": {`s|/v1|/v2|`}}, false},
+		{"URL read file", http.Header{"X-Protty-Transform-Request-Url-Sed": {`r /etc/passwd`}}, true},
+		{"Header write file", http.Header{"X-Protty-Transform-Response-Headers-Sed": {`Location: w /tmp/out`}}, true},
+		{"Body pipeline stage", http.Header{"X-Protty-Transform-Response-Body-Sed": {`s|r|w|g`, `{"expr": "$r /etc/passwd"}`}}, true},
+		{"Body substitution", http.Header{"X-Protty-Transform-Request-Body-Sed": {`s|read|write|g`}}, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			_, err := GetStartCommandConfig().SetFromHTTPRequestHeaders(tt.header, nil)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "r and w SED commands can't be set by the request header")
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestStartCommandConfig_GetMasked(t *testing.T) {
+	cfg := GetStartCommandConfig()
+	cfg.AdminToken.Value, cfg.RemoteURI.Value = "secret", "http://api"
+	masked := cfg.GetMasked()
+	assert.Equal(t, SecretMask, masked.AdminToken.Value)
+	assert.Equal(t, "http://api", masked.RemoteURI.Value)
+	assert.Equal(t, "secret", cfg.AdminToken.Value, "the config isn't changed")
+	assert.NotContains(t, fmt.Sprintf("%+v", masked), "secret")
+	assert.Empty(t, GetStartCommandConfig().GetMasked().AdminToken.Value, "the empty secret isn't masked")
+}
+
+func TestStartCommandConfig_GetValuesByFlagName(t *testing.T) {
+	cfg := GetStartCommandConfig()
+	cfg.RemoteURI.Value = "http://api"
+	values := cfg.GetValuesByFlagName()
+	assert.Equal(t, "http://api", values["remote-uri"])
+	assert.Equal(t, 80, values["local-port"])
+	assert.Equal(t, []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"}, values["retry-methods"])
+	assert.Equal(t, []string{}, values["routes"])
+}
+
+func TestStartCommandConfig_SetFromFile(t *testing.T) {
+	type want struct {
+		err      string
+		logLevel string
+		port     int
+		sed      []string
+		routes   []string
+		mock     string
+	}
+	tests := []struct {
+		name     string
+		content  string
+		env      map[string]string
+		flagsSet []string
+		want     want
+	}{
+		{
+			name:    "YAML file",
+			content: "version: 1\nlog-level: info\nlocal-port: 8080\ntransform-response-body-sed:\n  - s|a|b|g\n  - s|b|c|g\n",
+			want:    want{logLevel: "info", port: 8080, sed: []string{"s|a|b|g", "s|b|c|g"}},
+		},
+		{
+			name:    "JSON file",
+			content: `{"version": 1, "log-level": "warn", "local-port": 8081, "transform-response-body-sed": "s|a|b|g"}`,
+			want:    want{logLevel: "warn", port: 8081, sed: []string{"s|a|b|g"}},
+		},
+		{
+			name:     "Env variables and flags have higher priority",
+			content:  "version: 1\nlog-level: info\nlocal-port: 8080\n",
+			env:      map[string]string{"LOG_LEVEL": "error"},
+			flagsSet: []string{"local-port"},
+			want:     want{logLevel: "error", port: 80},
+		},
+		{
+			name:    "Array items objects are converted to JSON",
+			content: "version: 1\nroutes:\n  - path-prefix: /api\n    options:\n      remote-uri: http://api\n",
+			want:    want{logLevel: "debug", port: 80, routes: []string{`{"options":{"remote-uri":"http://api"},"path-prefix":"/api"}`}},
+		},
+		{
+			name:    "Scalar option object is converted to JSON",
+			content: "version: 1\nmock-response:\n  status: 404\n  body: not found\n",
+			want:    want{logLevel: "debug", port: 80, mock: `{"body":"not found","status":404}`},
+		},
+		{
+			name:    "Version is required",
+			content: "log-level: info\n",
+			want:    want{err: "the version field is required"},
+		},
+		{
+			name:    "Unsupported version",
+			content: "version: 2\n",
+			want:    want{err: ":1: unsupported config file version '2'"},
+		},
+		{
+			name:    "Unknown option with line number",
+			content: "version: 1\nlog-level: info\nunknown-option: value\n",
+			want:    want{err: ":3: unknown option 'unknown-option'"},
+		},
+		{
+			name:    "Invalid value with line number",
+			content: "version: 1\n\nlocal-port: not-a-number\n",
+			want:    want{err: ":3: local-port: strconv.Atoi"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			path := filepath.Join(t.TempDir(), "protty.yaml")
+			assert.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+
+			cfg := GetStartCommandConfig()
+			assert.NoError(t, cfg.SetFromEnv())
+			err := cfg.SetFromFile(path, func(flagName string) bool {
+				if cfg.IsSetByEnv(flagName) {
+					return true
+				}
+				for _, f := range tt.flagsSet {
+					if f == flagName {
+						return true
+					}
+				}
+				return false
+			})
+			if tt.want.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.want.err)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.logLevel, cfg.LogLevel.Value)
+			assert.Equal(t, tt.want.port, cfg.LocalPort.Value)
+			assert.Equal(t, tt.want.sed, cfg.TransformResponseBodySED.Value)
+			assert.Equal(t, tt.want.routes, cfg.Routes.Value)
+			assert.Equal(t, tt.want.mock, cfg.MockResponse.Value)
+		})
+	}
+}
+
+func TestStartCommandConfig_SetFromFile_WithoutEnv(t *testing.T) {
+	t.Setenv("TRANSFORM_RESPONSE_BODY_SED", "s|env|env|")
+	path := filepath.Join(t.TempDir(), "protty.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("version: 1\ntransform-response-body-sed: s|file|file|\n"), 0o600))
+
+	cfg := GetStartCommandConfig()
+	assert.True(t, cfg.IsSetByEnv("transform-response-body-sed"))
+	assert.False(t, cfg.IsSetByEnv("transform-response-body-jq"))
+	assert.False(t, cfg.IsSetByEnv("unknown-option"))
+	assert.NoError(t, cfg.SetFromFile(path, func(string) bool { return false }))
+	assert.Equal(t, []string{"s|file|file|"}, cfg.TransformResponseBodySED.Value, "the file value is applied if the env variables aren't")
+}
+
+func TestStartCommandConfig_Validate_ErrorContainsFileLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "protty.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("version: 1\nlog-level: unknown-level\n"), 0o600))
+
+	cfg := GetStartCommandConfig()
+	assert.NoError(t, cfg.SetFromFile(path, func(string) bool { return false }))
+	err := cfg.Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), path+":2: log-level: logrus.ParseLevel")
+	}
+}
+
+func TestStartCommandConfig_Validate_TransformationError(t *testing.T) {
+	tests := []struct {
+		msg     string
+		set     func(cfg *StartCommandConfig)
+		wantErr string
+	}{
+		{"Request URL SED", func(cfg *StartCommandConfig) { cfg.TransformRequestUrlSED.Value = "s|unclosed" }, "transform-request-url-sed"},
+		{"Request body SED", func(cfg *StartCommandConfig) { cfg.TransformRequestBodySED.Value = []string{"s|a|b|", "x|"} }, "transform-request-body-sed"},
+		{"Response body SED", func(cfg *StartCommandConfig) { cfg.TransformResponseBodySED.Value = []string{"s|(|b|"} }, "transform-response-body-sed"},
+		{"Request body JQ", func(cfg *StartCommandConfig) { cfg.TransformRequestBodyJQ.Value = []string{".["} }, "transform-request-body-jq"},
+		{"Response body JQ", func(cfg *StartCommandConfig) { cfg.TransformResponseBodyJQ.Value = []string{"$undefined"} }, "transform-response-body-jq"},
+		{"Response header SED", func(cfg *StartCommandConfig) { cfg.TransformResponseHeadersSED.Value = []string{"Location: s|a"} }, "transform-response-headers-sed"},
+		{"Request headers JQ", func(cfg *StartCommandConfig) { cfg.TransformRequestHeadersJQ.Value = []string{"del("} }, "transform-request-headers-jq"},
+		{"Stage conditions", func(cfg *StartCommandConfig) {
+			cfg.TransformResponseBodyJQ.Value = []string{`{"expr": ".", "status": "abc"}`}
+		}, "transform-response-body-jq"},
+		{"Status condition for request", func(cfg *StartCommandConfig) {
+			cfg.TransformRequestBodySED.Value = []string{`{"expr": "s|a|b|", "status": "2xx"}`}
+		}, "response stages only"},
+		{"Header format", func(cfg *StartCommandConfig) { cfg.SetRequestHeaders.Value = []string{"X-Without-Value"} }, "set-request-headers"},
+		{"Query parameter format", func(cfg *StartCommandConfig) { cfg.RenameRequestQueryParams.Value = []string{"without-new-name"} }, "rename-request-query-params"},
+		{"Request query JQ", func(cfg *StartCommandConfig) { cfg.TransformRequestQueryJQ.Value = []string{"map("} }, "transform-request-query-jq"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			cfg := GetStartCommandConfig()
+			tt.set(cfg)
+			err := cfg.Validate()
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStartCommandConfig_Validate_OptionError(t *testing.T) {
+	tests := []struct {
+		msg     string
+		set     func(cfg *StartCommandConfig)
+		wantErr string
+	}{
+		{"Metrics port out of range", func(cfg *StartCommandConfig) { cfg.MetricsPort.Value = 70000 }, "metrics-port"},
+		{"Tracing endpoint without scheme", func(cfg *StartCommandConfig) { cfg.TracingEndpoint.Value = "localhost:4318" }, "tracing-endpoint"},
+		{"Tracing sample ratio over 1", func(cfg *StartCommandConfig) { cfg.TracingSampleRatio.Value = 10 }, "tracing-sample-ratio"},
+		{"Upstream URI without scheme", func(cfg *StartCommandConfig) { cfg.UpstreamURIs.Value = []string{"10.0.0.1:8080"} }, "upstream-uris"},
+		{"Zero upstream weight", func(cfg *StartCommandConfig) { cfg.UpstreamURIs.Value = []string{"http://10.0.0.1:8080 0"} }, "upstream-uris"},
+		{"Unknown load balancing", func(cfg *StartCommandConfig) { cfg.LoadBalancing.Value = "random" }, "load-balancing"},
+		{"Consistent hash without key", func(cfg *StartCommandConfig) { cfg.LoadBalancing.Value = LoadBalancingConsistentHash }, "load-balancing-hash-key"},
+		{"Unknown hash key source", func(cfg *StartCommandConfig) { cfg.LoadBalancingHashKey.Value = "query:id" }, "load-balancing-hash-key"},
+		{"Relative health check path", func(cfg *StartCommandConfig) { cfg.HealthCheckPath.Value = "health" }, "health-check-path"},
+		{"Zero health check interval", func(cfg *StartCommandConfig) { cfg.HealthCheckInterval.Value = 0 }, "health-check-interval"},
+		{"Negative ejection failures", func(cfg *StartCommandConfig) { cfg.EjectionFailures.Value = -1 }, "ejection-failures"},
+		{"Circuit breaker failure ratio over 1", func(cfg *StartCommandConfig) { cfg.CircuitBreakerFailureRatio.Value = 50 }, "circuit-breaker-failure-ratio"},
+		{"Zero circuit breaker cooldown", func(cfg *StartCommandConfig) { cfg.CircuitBreakerCooldown.Value = 0 }, "circuit-breaker-cooldown"},
+		{"Invalid circuit breaker status", func(cfg *StartCommandConfig) { cfg.CircuitBreakerStatus.Value = 42 }, "circuit-breaker-status"},
+		{"Negative retry attempts", func(cfg *StartCommandConfig) { cfg.RetryAttempts.Value = -1 }, "retry-attempts"},
+		{"Invalid retry statuses", func(cfg *StartCommandConfig) { cfg.RetryStatuses.Value = "5xx,bad" }, "retry-statuses"},
+		{"Retry backoff max less than backoff", func(cfg *StartCommandConfig) { cfg.RetryBackoffMax.Value = 50 }, "retry-backoff-max"},
+		{"Negative delay", func(cfg *StartCommandConfig) { cfg.FaultDelay.Value = -1 }, "fault-delay"},
+		{"Negative delay jitter", func(cfg *StartCommandConfig) { cfg.FaultDelayJitter.Value = -1 }, "fault-delay-jitter"},
+		{"Error percentage over 100", func(cfg *StartCommandConfig) { cfg.FaultErrorPercentage.Value = 100.5 }, "fault-error-percentage"},
+		{"Negative reset percentage", func(cfg *StartCommandConfig) { cfg.FaultResetPercentage.Value = -1 }, "fault-reset-percentage"},
+		{"Invalid error status", func(cfg *StartCommandConfig) { cfg.FaultErrorStatus.Value = 42 }, "fault-error-status"},
+		{"Mirror URI without scheme", func(cfg *StartCommandConfig) { cfg.MirrorURIs.Value = []string{"shadow:8080"} }, "mirror-uris"},
+		{"Mirror percentage over 100", func(cfg *StartCommandConfig) { cfg.MirrorPercentage.Value = 101 }, "mirror-percentage"},
+		{"Zero mirror timeout", func(cfg *StartCommandConfig) { cfg.MirrorTimeout.Value = 0 }, "mirror-timeout"},
+		{"Unknown mirror comparison", func(cfg *StartCommandConfig) { cfg.MirrorCompare.Value = "headers" }, "mirror-compare"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			cfg := GetStartCommandConfig()
+			tt.set(cfg)
+			err := cfg.Validate()
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.wantErr)
+				optionErr := &OptionError{}
+				if assert.True(t, errors.As(err, &optionErr)) {
+					assert.Equal(t, tt.wantErr, optionErr.Flag)
+				}
+			}
+		})
+	}
+}
+
+func TestStartCommandConfig_ValidateOptions(t *testing.T) {
+	cfg := GetStartCommandConfig()
+	cfg.LogLevel.Value, cfg.RetryBackoffMax.Value = "unknown-level", 10
+	header := http.Header{"X-Protty-Retry-Attempts": {"2"}, "X-Protty-Transform-Response-Body-Jq": {".name"}}
+	options, err := cfg.SetFromHTTPRequestHeaders(header, nil)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"RetryAttempts", "TransformResponseBodyJQ"}, options)
+	assert.NoError(t, cfg.ValidateOptions(options), "the options that aren't changed aren't validated")
+
+	assert.ErrorContains(t, cfg.ValidateOptions([]string{"LogLevel"}), "log-level")
+	assert.ErrorContains(t, cfg.ValidateOptions([]string{"RetryBackoff"}), "retry-backoff-max", "the related options are validated together")
+	cfg.TransformResponseBodyJQ.Value = []string{".name |"}
+	assert.ErrorContains(t, cfg.ValidateOptions(options), "transform-response-body-jq")
+}
+
+func TestOptionChecks(t *testing.T) {
+	fields := reflect.TypeOf(StartCommandConfig{})
+	for _, check := range optionChecks {
+		for _, option := range check.options {
+			_, ok := fields.FieldByName(option)
+			assert.True(t, ok, "%s option doesn't exist", option)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mgerasimchuk/protty/pkg/util"
+)
+
+// transformStagesCacheSize is the max number of cached parsed transform stages, stages are parsed for every exchange
+const transformStagesCacheSize = 1000
+
+var transformStages = util.NewLRU[string, *TransformStage](transformStagesCacheSize, nil)
+
+// TransformStage is a stage of the transform pipeline, it's either the plain expression
+// or the expression with the conditions in JSON format, e.g. {"expr": ".data", "content-type": "application/*json", "status": "2xx"}.
+// The stage is applied only if all the specified conditions are met
+type TransformStage struct {
+	// Expr is the SED or JQ expression of the stage
+	Expr string `json:"expr"`
+	TransformConditions
+}
+
+// TransformConditions are the exchange conditions of the transform stages and the rewrite rules
+type TransformConditions struct {
+	// ContentType matches the media type of the body (without parameters) by the comma separated glob patterns, for example application/*json,text/*
+	ContentType string `json:"content-type"`
+	// Status matches the response status code by the comma separated codes, ranges and classes, for example 200,300-303,4xx
+	Status string `json:"status"`
+	// Path matches the request path by regular expression
+	Path string `json:"path"`
+	// Method matches the request method by regular expression
+	Method string `json:"method"`
+
+	pathRegex   *regexp.Regexp
+	methodRegex *regexp.Regexp
+	statuses    StatusRanges
+}
+
+// TransformMatchContext is the exchange data checked by the transform stage conditions, StatusCode is 0 for requests
+type TransformMatchContext struct {
+	Method      string
+	Path        string
+	ContentType string
+	StatusCode  int
+}
+
+// ParseTransformStage parses the stage, the parsed stages are cached by the value
+func ParseTransformStage(value string) (*TransformStage, error) {
+	if stage, ok := transformStages.Get(value); ok {
+		return stage, nil
+	}
+	stage, err := parseTransformStage(value)
+	if err != nil {
+		return nil, err
+	}
+	transformStages.Add(value, stage)
+	return stage, nil
+}
+
+func parseTransformStage(value string) (*TransformStage, error) {
+	stage := &TransformStage{Expr: value}
+	if !isTransformStageWithConditions(value) {
+		return stage, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewBufferString(value))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(stage); err != nil {
+		return nil, fmt.Errorf("%s: %w", util.GetFuncName(decoder.Decode), err)
+	}
+	if stage.Expr == "" {
+		return nil, fmt.Errorf("expr of the stage with the conditions can't be empty")
+	}
+	if err := stage.compile(); err != nil {
+		return nil, err
+	}
+	return stage, nil
+}
+
+// compile checks the conditions and compiles their regular expressions
+func (c *TransformConditions) compile() error {
+	for _, pattern := range splitList(c.ContentType) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("content-type: %s: %w", util.GetFuncName(path.Match), err)
+		}
+	}
+	var err error
+	if c.statuses, err = ParseStatusRanges(c.Status); err != nil {
+		return fmt.Errorf("status: %w", err)
+	}
+	if c.Path != "" {
+		if c.pathRegex, err = regexp.Compile(c.Path); err != nil {
+			return fmt.Errorf("path: %s: %w", util.GetFuncName(regexp.Compile), err)
+		}
+	}
+	if c.Method != "" {
+		if c.methodRegex, err = regexp.Compile(c.Method); err != nil {
+			return fmt.Errorf("method: %s: %w", util.GetFuncName(regexp.Compile), err)
+		}
+	}
+	return nil
+}
+
+// isTransformStageWithConditions checks if the stage is a JSON object with the expr field,
+// the JQ object construction (e.g. {a: .b}) isn't a valid JSON, so it's treated as the plain expression
+func isTransformStageWithConditions(value string) bool {
+	if !strings.HasPrefix(strings.TrimSpace(value), "{") {
+		return false
+	}
+	object := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return false
+	}
+	_, ok := object["expr"]
+	return ok
+}
+
+// HasStatusCondition returns true if there is the status condition, it's available for the responses only
+func (c *TransformConditions) HasStatusCondition() bool {
+	return len(c.statuses) > 0
+}
+
+// Match returns true if the exchange matches all the conditions
+func (c *TransformConditions) Match(ctx TransformMatchContext) bool {
+	if c.ContentType != "" {
+		mediaType, _, err := mime.ParseMediaType(ctx.ContentType)
+		if err != nil {
+			return false
+		}
+		isMatched := false
+		for _, pattern := range splitList(c.ContentType) {
+			matched, _ := path.Match(strings.ToLower(pattern), mediaType)
+			isMatched = isMatched || matched
+		}
+		if !isMatched {
+			return false
+		}
+	}
+	if len(c.statuses) > 0 && !c.statuses.Contains(ctx.StatusCode) {
+		return false
+	}
+	if c.pathRegex != nil && !c.pathRegex.MatchString(ctx.Path) {
+		return false
+	}
+	if c.methodRegex != nil && !c.methodRegex.MatchString(ctx.Method) {
+		return false
+	}
+	return true
+}
+
+// StatusRanges are the inclusive ranges of the status codes
+type StatusRanges [][2]int
+
+// ParseStatusRanges parses the comma separated status codes, ranges and classes, for example 200,300-303,4xx
+func ParseStatusRanges(value string) (StatusRanges, error) {
+	statuses := StatusRanges{}
+	for _, status := range splitList(value) {
+		statusRange, err := parseStatusRange(status)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, statusRange)
+	}
+	return statuses, nil
+}
+
+// Contains returns true if the status code is in any of the ranges
+func (r StatusRanges) Contains(statusCode int) bool {
+	for _, statusRange := range r {
+		if statusCode >= statusRange[0] && statusCode <= statusRange[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatusRange parses the status code (200), the range (200-299) or the class (2xx) to the inclusive range
+func parseStatusRange(status string) ([2]int, error) {
+	if len(status) == 3 && strings.HasSuffix(strings.ToLower(status), "xx") {
+		class, err := strconv.Atoi(status[:1])
+		if err != nil || class < 1 || class > 5 {
+			return [2]int{}, fmt.Errorf("invalid status class %q", status)
+		}
+		return [2]int{class * 100, class*100 + 99}, nil
+	}
+	bounds := strings.SplitN(status, "-", 2)
+	from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return [2]int{}, fmt.Errorf("invalid status %q", status)
+	}
+	to := from
+	if len(bounds) == 2 {
+		if to, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil || to < from {
+			return [2]int{}, fmt.Errorf("invalid status range %q", status)
+		}
+	}
+	return [2]int{from, to}, nil
+}
+
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
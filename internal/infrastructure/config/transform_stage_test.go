@@ -0,0 +1,70 @@
+//go:build unit
+// +build unit
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTransformStage(t *testing.T) {
+	tests := []struct {
+		msg     string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"Plain SED expression", "s|a|b|", "s|a|b|", false},
+		{"JQ object construction", "{a: .b}", "{a: .b}", false},
+		{"JQ object literal without expr", `{"a": 1}`, `{"a": 1}`, false},
+		{"Stage with conditions", `{"expr": ".data", "status": "2xx"}`, ".data", false},
+		{"Empty expr", `{"expr": "", "status": "2xx"}`, "", true},
+		{"Unknown condition", `{"expr": ".data", "host": "example.com"}`, "", true},
+		{"Invalid status", `{"expr": ".data", "status": "2xy"}`, "", true},
+		{"Invalid status range", `{"expr": ".data", "status": "299-200"}`, "", true},
+		{"Invalid path regex", `{"expr": ".data", "path": "("}`, "", true},
+		{"Invalid content type glob", `{"expr": ".data", "content-type": "["}`, "", true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			stage, err := ParseTransformStage(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, stage.Expr)
+		})
+	}
+}
+
+func TestTransformStage_Match(t *testing.T) {
+	stage, err := ParseTransformStage(`{"expr": ".", "content-type": "application/*json, text/plain", "status": "200, 300-303, 4xx", "path": "^/api/", "method": "GET|POST"}`)
+	assert.NoError(t, err)
+	match := TransformMatchContext{Method: "GET", Path: "/api/users", ContentType: "application/problem+json; charset=utf-8", StatusCode: 200}
+	assert.True(t, stage.Match(match))
+
+	for _, ctx := range []TransformMatchContext{
+		{Method: "GET", Path: "/api/users", ContentType: "TEXT/PLAIN", StatusCode: 302},
+		{Method: "POST", Path: "/api/users", ContentType: "application/json", StatusCode: 404},
+	} {
+		assert.True(t, stage.Match(ctx), "%+v", ctx)
+	}
+	for _, ctx := range []TransformMatchContext{
+		{Method: "GET", Path: "/api/users", ContentType: "text/html", StatusCode: 200},
+		{Method: "GET", Path: "/api/users", ContentType: "", StatusCode: 200},
+		{Method: "GET", Path: "/api/users", ContentType: "application/json", StatusCode: 500},
+		{Method: "GET", Path: "/health", ContentType: "application/json", StatusCode: 200},
+		{Method: "DELETE", Path: "/api/users", ContentType: "application/json", StatusCode: 200},
+	} {
+		assert.False(t, stage.Match(ctx), "%+v", ctx)
+	}
+
+	plain, err := ParseTransformStage(".")
+	assert.NoError(t, err)
+	assert.True(t, plain.Match(TransformMatchContext{}))
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// LoadBalancing option values
+const (
+	LoadBalancingRoundRobin       = "round-robin"
+	LoadBalancingWeighted         = "weighted"
+	LoadBalancingLeastConnections = "least-connections"
+	LoadBalancingConsistentHash   = "consistent-hash"
+)
+
+// LoadBalancingHashKey option sources
+const (
+	HashKeySourceHeader = "header"
+	HashKeySourceCookie = "cookie"
+)
+
+// Upstream is the upstream of the pool
+type Upstream struct {
+	URL *url.URL
+	// Weight is the share of the requests for the weighted balancing and the consistent hashing, 1 by default
+	Weight int
+}
+
+// ParseUpstream parses the upstream in format `URI` or `URI weight`, e.g. `http://10.0.0.1:8080 3`
+func ParseUpstream(value string) (Upstream, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Upstream{}, fmt.Errorf("%q isn't in format URI or URI weight", value)
+	}
+	u, err := url.Parse(fields[0])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Upstream{}, fmt.Errorf("%q isn't a valid http(s) URI", fields[0])
+	}
+	upstream := Upstream{URL: u, Weight: 1}
+	if len(fields) == 2 {
+		if upstream.Weight, err = strconv.Atoi(fields[1]); err != nil || upstream.Weight < 1 {
+			return Upstream{}, fmt.Errorf("%q: weight should be a positive integer", value)
+		}
+	}
+	return upstream, nil
+}
+
+// ParseHashKey splits the consistent hashing key in format `header:Name` or `cookie:Name` to the source and the name
+func ParseHashKey(value string) (string, string, error) {
+	kv := strings.SplitN(value, ":", 2)
+	if len(kv) != 2 || (kv[0] != HashKeySourceHeader && kv[0] != HashKeySourceCookie) || strings.TrimSpace(kv[1]) == "" {
+		return "", "", fmt.Errorf("%q isn't in format %s:Name or %s:Name", value, HashKeySourceHeader, HashKeySourceCookie)
+	}
+	return kv[0], strings.TrimSpace(kv[1]), nil
+}
@@ -0,0 +1,72 @@
+//go:build unit
+// +build unit
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUpstream(t *testing.T) {
+	tests := []struct {
+		msg        string
+		value      string
+		wantURL    string
+		wantWeight int
+		wantErr    bool
+	}{
+		{"Default weight", "http://10.0.0.1:8080", "http://10.0.0.1:8080", 1, false},
+		{"Weight", " https://a.example.com/api  3 ", "https://a.example.com/api", 3, false},
+		{"Zero weight", "http://10.0.0.1:8080 0", "", 0, true},
+		{"Not number weight", "http://10.0.0.1:8080 high", "", 0, true},
+		{"Extra field", "http://10.0.0.1:8080 1 2", "", 0, true},
+		{"Without scheme", "10.0.0.1:8080", "", 0, true},
+		{"Empty", " ", "", 0, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			upstream, err := ParseUpstream(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantURL, upstream.URL.String())
+			assert.Equal(t, tt.wantWeight, upstream.Weight)
+		})
+	}
+}
+
+func TestParseHashKey(t *testing.T) {
+	tests := []struct {
+		msg        string
+		value      string
+		wantSource string
+		wantName   string
+		wantErr    bool
+	}{
+		{"Header", "header:X-User-Id", HashKeySourceHeader, "X-User-Id", false},
+		{"Cookie", "cookie: session", HashKeySourceCookie, "session", false},
+		{"Unknown source", "query:id", "", "", true},
+		{"Without name", "header:", "", "", true},
+		{"Without source", "X-User-Id", "", "", true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			source, name, err := ParseHashKey(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSource, source)
+			assert.Equal(t, tt.wantName, name)
+		})
+	}
+}
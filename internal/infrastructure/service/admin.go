@@ -0,0 +1,175 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/mgerasimchuk/protty/pkg/util"
+)
+
+// adminCache is the reverse proxies cache returned by the admin API
+type adminCache struct {
+	Size           int                 `json:"size"`
+	Capacity       int                 `json:"capacity"`
+	Hits           uint64              `json:"hits"`
+	Misses         uint64              `json:"misses"`
+	Evictions      uint64              `json:"evictions"`
+	ReverseProxies []adminReverseProxy `json:"reverse-proxies"`
+}
+
+// adminReverseProxy is the cached reverse proxy, Key is the hash of the config it's created for
+type adminReverseProxy struct {
+	Key       string          `json:"key"`
+	RemoteURI string          `json:"remote-uri"`
+	Upstreams []adminUpstream `json:"upstreams,omitempty"`
+}
+
+// adminUpstream is the upstream of the reverse proxy pool
+type adminUpstream struct {
+	URL         string `json:"url"`
+	Weight      int    `json:"weight"`
+	IsAvailable bool   `json:"available"`
+	InFlight    int64  `json:"in-flight"`
+}
+
+// getAdminHandler returns the admin API handler, the requests are authorized with the admin token of the current config:
+//   - GET /config returns the effective config (the option values by the flag names)
+//   - PATCH /config changes the options of the JSON object, the config is validated and applied like on the reload
+//   - GET /reverse-proxies returns the reverse proxies cache
+//   - POST /cache/flush flushes the reverse proxies cache and the caches of the parsed options
+//   - POST /reload reloads the config from its sources (the patched options are reset)
+func (s *ReverseProxyService) getAdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			s.handleAdminGetConfig(w, r)
+		case http.MethodPatch:
+			s.handleAdminPatchConfig(w, r)
+		default:
+			s.writeAdminMethodNotAllowed(w, http.MethodGet, http.MethodPatch)
+		}
+	})
+	mux.HandleFunc("/reverse-proxies", s.allowAdminMethod(http.MethodGet, s.handleAdminGetReverseProxies))
+	mux.HandleFunc("/cache/flush", s.allowAdminMethod(http.MethodPost, s.handleAdminFlushCache))
+	mux.HandleFunc("/reload", s.allowAdminMethod(http.MethodPost, s.handleAdminReload))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdminAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="protty"`)
+			s.writeAdminError(w, http.StatusUnauthorized, fmt.Errorf("invalid admin token"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// isAdminAuthorized compares the bearer token of the request with the admin token in the constant time
+func (s *ReverseProxyService) isAdminAuthorized(r *http.Request) bool {
+	token := s.state.Load().cfg.AdminToken.Value
+	if token == "" {
+		return true
+	}
+	requestToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(requestToken), []byte(token)) == 1
+}
+
+func (s *ReverseProxyService) handleAdminGetConfig(w http.ResponseWriter, _ *http.Request) {
+	s.writeAdminJSON(w, http.StatusOK, s.state.Load().cfg.GetMasked().GetValuesByFlagName())
+}
+
+func (s *ReverseProxyService) handleAdminPatchConfig(w http.ResponseWriter, r *http.Request) {
+	options, decoder := map[string]any{}, json.NewDecoder(r.Body)
+	if err := decoder.Decode(&options); err != nil {
+		s.writeAdminError(w, http.StatusBadRequest, fmt.Errorf("%s: %w", util.GetFuncName(decoder.Decode), err))
+		return
+	}
+
+	// the lock serializes the changes with the reloads
+	s.mu.Lock()
+	cfg := *s.state.Load().cfg
+	err := cfg.SetFromAdminAPI(options)
+	if err == nil {
+		err = s.applyConfig(&cfg)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		s.writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.logger.Infof("Config has been changed with the admin API: %+v", cfg.GetMasked())
+	s.handleAdminGetConfig(w, r)
+}
+
+func (s *ReverseProxyService) handleAdminGetReverseProxies(w http.ResponseWriter, _ *http.Request) {
+	state := s.state.Load()
+	stats := s.getReverseProxyCacheStats()
+	cache := adminCache{
+		Capacity: state.cfg.ReverseProxyCacheSize.Value, Hits: stats.Hits, Misses: stats.Misses, Evictions: stats.Evictions,
+		ReverseProxies: []adminReverseProxy{},
+	}
+	now := time.Now()
+	state.reverseProxies.Range(func(key string, reverseProxy *cachedReverseProxy) {
+		item := adminReverseProxy{Key: key, RemoteURI: reverseProxy.remoteURI}
+		if reverseProxy.pool != nil {
+			for _, u := range reverseProxy.pool.upstreams {
+				item.Upstreams = append(item.Upstreams, adminUpstream{URL: u.URL.String(), Weight: u.Weight, IsAvailable: u.isAvailable(now), InFlight: u.inFlight.Load()})
+			}
+		}
+		cache.ReverseProxies = append(cache.ReverseProxies, item)
+	})
+	cache.Size = len(cache.ReverseProxies)
+	s.writeAdminJSON(w, http.StatusOK, cache)
+}
+
+func (s *ReverseProxyService) handleAdminFlushCache(w http.ResponseWriter, _ *http.Request) {
+	state := s.state.Load()
+	flushed := state.reverseProxies.Len()
+	state.purge()
+	config.PurgeCaches()
+	s.logger.Infof("Caches have been flushed with the admin API, %d reverse proxies have been removed", flushed)
+	s.writeAdminJSON(w, http.StatusOK, map[string]int{"flushed-reverse-proxies": flushed})
+}
+
+func (s *ReverseProxyService) handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.Reload(); err != nil {
+		s.writeAdminError(w, http.StatusBadRequest, fmt.Errorf("%s: %w", util.GetFuncName(s.Reload), err))
+		return
+	}
+	s.handleAdminGetConfig(w, r)
+}
+
+// allowAdminMethod responds with 405 status to the requests with other methods
+func (s *ReverseProxyService) allowAdminMethod(method string, handle http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			s.writeAdminMethodNotAllowed(w, method)
+			return
+		}
+		handle(w, r)
+	}
+}
+
+func (s *ReverseProxyService) writeAdminMethodNotAllowed(w http.ResponseWriter, allowedMethods ...string) {
+	w.Header().Set("Allow", strings.Join(allowedMethods, ", "))
+	s.writeAdminError(w, http.StatusMethodNotAllowed, fmt.Errorf("method isn't allowed, allowed methods: %s", strings.Join(allowedMethods, ", ")))
+}
+
+func (s *ReverseProxyService) writeAdminError(w http.ResponseWriter, statusCode int, err error) {
+	s.writeAdminJSON(w, statusCode, map[string]string{"error": err.Error()})
+}
+
+func (s *ReverseProxyService) writeAdminJSON(w http.ResponseWriter, statusCode int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(encoder.Encode), err)
+	}
+}
@@ -0,0 +1,119 @@
+//go:build unit
+// +build unit
+
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseProxyService_getAdminHandler(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("upstream"))
+	}))
+	defer upstream.Close()
+
+	cfg := config.GetStartCommandConfig()
+	markAsAddedToCLI(cfg)
+	cfg.RemoteURI.Value, cfg.AdminToken.Value = upstream.URL, "secret"
+	nextCfg := *cfg
+	s := newTestReverseProxyService(t, cfg, func() (*config.StartCommandConfig, error) {
+		cfg := nextCfg
+		return &cfg, nil
+	})
+	admin := httptest.NewServer(s.getAdminHandler())
+	defer admin.Close()
+
+	sendAdminRequest := func(method, path, token, body string) (int, map[string]any) {
+		req, err := http.NewRequest(method, admin.URL+path, strings.NewReader(body))
+		assert.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return 0, nil
+		}
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		values := map[string]any{}
+		assert.NoError(t, json.Unmarshal(respBody, &values), string(respBody))
+		return resp.StatusCode, values
+	}
+	sendProxyRequest := func() string {
+		res := httptest.NewRecorder()
+		s.handleRequestAndRedirect(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		return res.Body.String()
+	}
+
+	t.Run("Token is required", func(t *testing.T) {
+		status, _ := sendAdminRequest(http.MethodGet, "/config", "", "")
+		assert.Equal(t, http.StatusUnauthorized, status)
+		status, _ = sendAdminRequest(http.MethodGet, "/config", "wrong", "")
+		assert.Equal(t, http.StatusUnauthorized, status)
+	})
+
+	t.Run("Get config", func(t *testing.T) {
+		status, values := sendAdminRequest(http.MethodGet, "/config", "secret", "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, upstream.URL, values["remote-uri"])
+		assert.Equal(t, config.SecretMask, values["admin-token"])
+	})
+
+	t.Run("Patch config", func(t *testing.T) {
+		status, values := sendAdminRequest(http.MethodPatch, "/config", "secret", `{"transform-response-body-sed": ["s|upstream|patched|"], "retry-attempts": 2}`)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, []any{"s|upstream|patched|"}, values["transform-response-body-sed"])
+		assert.Equal(t, float64(2), values["retry-attempts"])
+		assert.Equal(t, "patched", sendProxyRequest())
+	})
+
+	t.Run("Invalid patch isn't applied", func(t *testing.T) {
+		for body, wantErr := range map[string]string{
+			`{"retry-attempts": -1}`:  "admin API: retry-attempts",
+			`{"local-port": 8080}`:    "can't be changed without restart",
+			`{"unknown": 1}`:          "unknown option",
+			`["retry-attempts"]`:      "Decode",
+			`{"retry-attempts": "a"}`: "Atoi",
+		} {
+			status, values := sendAdminRequest(http.MethodPatch, "/config", "secret", body)
+			assert.Equal(t, http.StatusBadRequest, status, body)
+			assert.Contains(t, values["error"], wantErr, body)
+		}
+		assert.Equal(t, 2, s.state.Load().cfg.RetryAttempts.Value)
+	})
+
+	t.Run("List reverse proxies", func(t *testing.T) {
+		status, values := sendAdminRequest(http.MethodGet, "/reverse-proxies", "secret", "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, float64(1), values["size"])
+		if reverseProxies, ok := values["reverse-proxies"].([]any); assert.True(t, ok) && assert.Len(t, reverseProxies, 1) {
+			assert.Equal(t, upstream.URL, reverseProxies[0].(map[string]any)["remote-uri"])
+		}
+	})
+
+	t.Run("Flush cache", func(t *testing.T) {
+		status, values := sendAdminRequest(http.MethodPost, "/cache/flush", "secret", "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, float64(1), values["flushed-reverse-proxies"])
+		assert.Equal(t, 0, s.state.Load().reverseProxies.Len())
+
+		status, _ = sendAdminRequest(http.MethodGet, "/cache/flush", "secret", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, status)
+	})
+
+	t.Run("Reload resets the patched options", func(t *testing.T) {
+		status, values := sendAdminRequest(http.MethodPost, "/reload", "secret", "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, float64(0), values["retry-attempts"])
+		assert.Equal(t, "upstream", sendProxyRequest())
+	})
+}
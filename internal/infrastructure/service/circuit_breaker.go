@@ -0,0 +1,195 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/mgerasimchuk/protty/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+// circuitBreakersCacheSize limits the number of the kept circuit breakers, the least recently used ones are dropped
+// (the remote URI can be set by the request header, so the number of the upstreams isn't limited by the config)
+const circuitBreakersCacheSize = 1024
+
+// Circuit breaker states
+const (
+	circuitBreakerClosed   = "closed"
+	circuitBreakerOpen     = "open"
+	circuitBreakerHalfOpen = "half-open"
+)
+
+// circuitBreakerStats are the circuit breakers counters for the whole proxy lifetime
+type circuitBreakerStats struct {
+	opened     atomic.Int64 // transitions to the open state
+	halfOpened atomic.Int64 // transitions to the half-open state
+	closed     atomic.Int64 // transitions from the half-open to the closed state
+	rejected   atomic.Int64 // fast-failed requests
+}
+
+// circuitBreaker stops sending the requests to the upstream if the failure ratio of the requests in the window
+// is too high (closed -> open), sends the trial requests after the cooldown (open -> half-open) and closes
+// if all of them succeed (half-open -> closed) or opens again on any failure. The breaker is shared by all the reverse
+// proxies of the upstream, so the thresholds are taken from the config of the proxy calling it. It's safe for concurrent use
+type circuitBreaker struct {
+	upstream string
+	stats    *circuitBreakerStats
+	logger   *logrus.Logger
+
+	mu          sync.Mutex
+	state       string
+	generation  uint64 // is changed on every transition, so the results of the requests allowed in the previous state are ignored
+	windowStart time.Time
+	requests    int // in the closed state window or the half-open trial requests
+	failures    int
+	succeeded   int // half-open trial requests
+	openedAt    time.Time
+}
+
+func newCircuitBreaker(upstream string, stats *circuitBreakerStats, logger *logrus.Logger) *circuitBreaker {
+	return &circuitBreaker{upstream: upstream, stats: stats, logger: logger, state: circuitBreakerClosed, windowStart: time.Now()}
+}
+
+// allow returns true and the generation for the result reporting if the request can be sent to the upstream
+func (b *circuitBreaker) allow(cfg *config.StartCommandConfig, now time.Time) (bool, uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == circuitBreakerOpen {
+		if now.Sub(b.openedAt) < time.Duration(cfg.CircuitBreakerCooldown.Value)*time.Second {
+			return false, 0
+		}
+		b.setState(cfg, circuitBreakerHalfOpen, now)
+	}
+	if b.state == circuitBreakerHalfOpen {
+		if b.requests >= cfg.CircuitBreakerTrialRequests.Value {
+			return false, 0
+		}
+		b.requests++
+	}
+	return true, b.generation
+}
+
+// report counts the result of the request allowed with the generation
+func (b *circuitBreaker) report(cfg *config.StartCommandConfig, generation uint64, isFailed bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+	switch b.state {
+	case circuitBreakerClosed:
+		if now.Sub(b.windowStart) >= time.Duration(cfg.CircuitBreakerWindow.Value)*time.Second {
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+		b.requests++
+		if isFailed {
+			b.failures++
+		}
+		if b.requests >= cfg.CircuitBreakerMinRequests.Value && float64(b.failures)/float64(b.requests) >= cfg.CircuitBreakerFailureRatio.Value {
+			b.setState(cfg, circuitBreakerOpen, now)
+		}
+	case circuitBreakerHalfOpen:
+		if isFailed {
+			b.setState(cfg, circuitBreakerOpen, now)
+			return
+		}
+		if b.succeeded++; b.succeeded >= cfg.CircuitBreakerTrialRequests.Value {
+			b.setState(cfg, circuitBreakerClosed, now)
+		}
+	}
+}
+
+// cancel releases the half-open trial request allowed with the generation without counting its result
+func (b *circuitBreaker) cancel(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation == b.generation && b.state == circuitBreakerHalfOpen {
+		b.requests--
+	}
+}
+
+// setState makes the transition, should be called under the lock
+func (b *circuitBreaker) setState(cfg *config.StartCommandConfig, state string, now time.Time) {
+	from := b.state
+	b.state, b.generation = state, b.generation+1
+	b.windowStart, b.requests, b.failures, b.succeeded = now, 0, 0, 0
+	switch state {
+	case circuitBreakerOpen:
+		b.openedAt = now
+		b.stats.opened.Add(1)
+		b.logger.Warnf("Circuit breaker of %s upstream has been changed from %s to %s state for %ds", b.upstream, from, state, cfg.CircuitBreakerCooldown.Value)
+	case circuitBreakerHalfOpen:
+		b.stats.halfOpened.Add(1)
+		b.logger.Infof("Circuit breaker of %s upstream has been changed from %s to %s state", b.upstream, from, state)
+	case circuitBreakerClosed:
+		b.stats.closed.Add(1)
+		b.logger.Infof("Circuit breaker of %s upstream has been changed from %s to %s state", b.upstream, from, state)
+	}
+}
+
+// circuitBreakerTransport guards every upstream with its circuit breaker, it's inside the upstream pool transport,
+// so the breaker of the picked upstream is used and the fast-fail responses are counted by the passive ejection.
+// The breakers are owned by the service, so they're shared by the routes and the request header variants and survive
+// the reverse proxies eviction, the config reload and the admin API changes
+type circuitBreakerTransport struct {
+	next     http.RoundTripper
+	cfg      config.StartCommandConfig
+	breakers *util.LRU[string, *circuitBreaker] // by the upstream scheme and host
+	stats    *circuitBreakerStats
+	logger   *logrus.Logger
+}
+
+func newCircuitBreakerTransport(next http.RoundTripper, cfg config.StartCommandConfig, breakers *util.LRU[string, *circuitBreaker], stats *circuitBreakerStats, logger *logrus.Logger) *circuitBreakerTransport {
+	return &circuitBreakerTransport{next: next, cfg: cfg, breakers: breakers, stats: stats, logger: logger}
+}
+
+func (t *circuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	breaker := t.getBreaker(req.URL.Scheme + "://" + req.URL.Host)
+	isAllowed, generation := breaker.allow(&t.cfg, time.Now())
+	if !isAllowed {
+		t.stats.rejected.Add(1)
+		t.logger.Debugf("%s %s request has been fast-failed by the open circuit breaker of %s upstream", req.Method, req.URL.Path, breaker.upstream)
+		// the retry would be fast-failed too, so it isn't retried
+		getExchange(req.Context()).isFastFailed = true
+		return t.getFastFailResponse(req), nil
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		if req.Context().Err() != nil { // the client cancellation isn't the upstream failure
+			breaker.cancel(generation)
+		} else {
+			breaker.report(&t.cfg, generation, true, time.Now())
+		}
+		return nil, err
+	}
+	breaker.report(&t.cfg, generation, resp.StatusCode >= 500, time.Now())
+	return resp, nil
+}
+
+func (t *circuitBreakerTransport) getBreaker(upstream string) *circuitBreaker {
+	breaker, _ := t.breakers.GetOrAdd(upstream, func() *circuitBreaker {
+		return newCircuitBreaker(upstream, t.stats, t.logger)
+	})
+	return breaker
+}
+
+func (t *circuitBreakerTransport) getFastFailResponse(req *http.Request) *http.Response {
+	body := []byte(t.cfg.CircuitBreakerBody.Value)
+	return &http.Response{
+		Status:        strconv.Itoa(t.cfg.CircuitBreakerStatus.Value) + " " + http.StatusText(t.cfg.CircuitBreakerStatus.Value),
+		StatusCode:    t.cfg.CircuitBreakerStatus.Value,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}, "Content-Length": {strconv.Itoa(len(body))}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
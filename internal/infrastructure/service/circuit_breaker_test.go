@@ -0,0 +1,203 @@
+//go:build unit
+// +build unit
+
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCircuitBreaker is the breaker with the config of the calling reverse proxy
+type testCircuitBreaker struct {
+	*circuitBreaker
+	cfg *config.StartCommandConfig
+}
+
+func (b testCircuitBreaker) allow(now time.Time) (bool, uint64) {
+	return b.circuitBreaker.allow(b.cfg, now)
+}
+
+func (b testCircuitBreaker) report(generation uint64, isFailed bool, now time.Time) {
+	b.circuitBreaker.report(b.cfg, generation, isFailed, now)
+}
+
+func newTestCircuitBreaker(set func(cfg *config.StartCommandConfig)) (testCircuitBreaker, *circuitBreakerStats) {
+	cfg := config.GetStartCommandConfig()
+	cfg.CircuitBreakerFailureRatio.Value, cfg.CircuitBreakerMinRequests.Value = 0.5, 4
+	cfg.CircuitBreakerWindow.Value, cfg.CircuitBreakerCooldown.Value, cfg.CircuitBreakerTrialRequests.Value = 10, 30, 2
+	set(cfg)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	stats := &circuitBreakerStats{}
+	return testCircuitBreaker{circuitBreaker: newCircuitBreaker("http://upstream", stats, logger), cfg: cfg}, stats
+}
+
+// sendTestRequest reports the request result if it's allowed and returns false otherwise
+func sendTestRequest(b testCircuitBreaker, isFailed bool, now time.Time) bool {
+	isAllowed, generation := b.allow(now)
+	if isAllowed {
+		b.report(generation, isFailed, now)
+	}
+	return isAllowed
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+
+	t.Run("Opens on the failure ratio", func(t *testing.T) {
+		b, stats := newTestCircuitBreaker(func(cfg *config.StartCommandConfig) {})
+		for _, isFailed := range []bool{true, true, false} {
+			assert.True(t, sendTestRequest(b, isFailed, now))
+		}
+		assert.Equal(t, circuitBreakerClosed, b.state, "there are less than min requests")
+		assert.True(t, sendTestRequest(b, false, now))
+		assert.Equal(t, circuitBreakerOpen, b.state)
+		assert.False(t, sendTestRequest(b, false, now.Add(29*time.Second)))
+		assert.Equal(t, int64(1), stats.opened.Load())
+	})
+
+	t.Run("Window is reset", func(t *testing.T) {
+		b, _ := newTestCircuitBreaker(func(cfg *config.StartCommandConfig) {})
+		for _, isFailed := range []bool{true, true, false} {
+			sendTestRequest(b, isFailed, now)
+		}
+		for _, isFailed := range []bool{true, false, false, false} {
+			sendTestRequest(b, isFailed, now.Add(11*time.Second))
+		}
+		assert.Equal(t, circuitBreakerClosed, b.state)
+	})
+
+	t.Run("Closes after the successful trial requests", func(t *testing.T) {
+		b, stats := newTestCircuitBreaker(func(cfg *config.StartCommandConfig) { cfg.CircuitBreakerMinRequests.Value = 1 })
+		sendTestRequest(b, true, now)
+		cooled := now.Add(30 * time.Second)
+
+		firstAllowed, firstGeneration := b.allow(cooled)
+		secondAllowed, secondGeneration := b.allow(cooled)
+		thirdAllowed, _ := b.allow(cooled)
+		assert.Equal(t, []bool{true, true, false}, []bool{firstAllowed, secondAllowed, thirdAllowed}, "only trial requests are allowed")
+		assert.Equal(t, circuitBreakerHalfOpen, b.state)
+
+		b.report(firstGeneration, false, cooled)
+		b.report(secondGeneration, false, cooled)
+		assert.Equal(t, circuitBreakerClosed, b.state)
+		assert.Equal(t, []int64{1, 1, 1, 0}, []int64{stats.opened.Load(), stats.halfOpened.Load(), stats.closed.Load(), stats.rejected.Load()})
+	})
+
+	t.Run("Opens again on the trial request failure", func(t *testing.T) {
+		b, stats := newTestCircuitBreaker(func(cfg *config.StartCommandConfig) { cfg.CircuitBreakerMinRequests.Value = 1 })
+		sendTestRequest(b, true, now)
+		cooled := now.Add(30 * time.Second)
+		_, staleGeneration := b.allow(cooled)
+		assert.True(t, sendTestRequest(b, true, cooled))
+		assert.Equal(t, circuitBreakerOpen, b.state)
+
+		b.report(staleGeneration, false, cooled) // the result of the request allowed in the previous state is ignored
+		assert.Equal(t, circuitBreakerOpen, b.state)
+		assert.False(t, sendTestRequest(b, false, cooled.Add(29*time.Second)))
+		assert.Equal(t, int64(2), stats.opened.Load())
+	})
+
+	t.Run("Cancelled trial request is released", func(t *testing.T) {
+		b, _ := newTestCircuitBreaker(func(cfg *config.StartCommandConfig) {
+			cfg.CircuitBreakerMinRequests.Value, cfg.CircuitBreakerTrialRequests.Value = 1, 1
+		})
+		sendTestRequest(b, true, now)
+		cooled := now.Add(30 * time.Second)
+		_, generation := b.allow(cooled)
+		b.cancel(generation)
+		assert.True(t, sendTestRequest(b, false, cooled))
+		assert.Equal(t, circuitBreakerClosed, b.state)
+	})
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_CircuitBreaker(t *testing.T) {
+	var upstreamRequests, isFailing atomic.Int64
+	isFailing.Store(1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		if isFailing.Load() == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := config.GetStartCommandConfig()
+	cfg.RemoteURI.Value, cfg.CircuitBreakerFailureRatio.Value, cfg.CircuitBreakerMinRequests.Value = upstream.URL, 1, 2
+	cfg.CircuitBreakerStatus.Value, cfg.CircuitBreakerBody.Value = http.StatusTooManyRequests, "try later"
+	s := newTestReverseProxyService(t, cfg, nil)
+
+	send := func() *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		s.handleRequestAndRedirect(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		return res
+	}
+	assert.Equal(t, http.StatusInternalServerError, send().Code)
+	assert.Equal(t, http.StatusInternalServerError, send().Code)
+
+	res := send()
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "try later", res.Body.String())
+	assert.Equal(t, int64(2), upstreamRequests.Load(), "the request is fast-failed without sending to the upstream")
+	assert.Equal(t, int64(1), s.circuitBreakerStats.opened.Load())
+	assert.Equal(t, int64(1), s.circuitBreakerStats.rejected.Load())
+
+	// the cooldown is over
+	breaker, _ := s.circuitBreakers.Get(upstream.URL)
+	breaker.mu.Lock()
+	breaker.openedAt = breaker.openedAt.Add(-time.Duration(cfg.CircuitBreakerCooldown.Value) * time.Second)
+	breaker.mu.Unlock()
+	isFailing.Store(0)
+	assert.Equal(t, http.StatusOK, send().Code)
+	assert.Equal(t, circuitBreakerClosed, breaker.state)
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_CircuitBreakerIsShared(t *testing.T) {
+	var upstreamRequests atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	cfg := config.GetStartCommandConfig()
+	markAsAddedToCLI(cfg)
+	cfg.RemoteURI.Value, cfg.CircuitBreakerFailureRatio.Value, cfg.CircuitBreakerMinRequests.Value = upstream.URL, 1, 2
+	cfg.RetryAttempts.Value, cfg.RetryStatuses.Value, cfg.RetryBackoff.Value = 3, "5xx", 0
+	cfg.Routes.Value = []string{`{"name": "api", "path-prefix": "/api", "options": {"set-response-headers": ["X-Route: api"]}}`}
+	nextCfg := *cfg
+	s := newTestReverseProxyService(t, cfg, func() (*config.StartCommandConfig, error) {
+		cfg := nextCfg
+		return &cfg, nil
+	})
+
+	send := func(path string, header http.Header) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		res := httptest.NewRecorder()
+		s.handleRequestAndRedirect(res, req)
+		return res.Code
+	}
+	// the breaker is opened by the failed attempts of the retried request
+	assert.Equal(t, http.StatusServiceUnavailable, send("/", nil))
+	assert.Equal(t, int64(2), upstreamRequests.Load(), "the fast-failed attempt isn't retried")
+
+	assert.Equal(t, http.StatusServiceUnavailable, send("/api/users", nil), "the route shares the breaker")
+	assert.Equal(t, http.StatusServiceUnavailable, send("/", http.Header{"X-Protty-Retry-Attempts": {"1"}}), "the request header variant shares the breaker")
+	assert.NoError(t, s.Reload())
+	assert.Equal(t, http.StatusServiceUnavailable, send("/", nil), "the breaker survives the reload")
+	assert.Equal(t, int64(2), upstreamRequests.Load())
+	assert.Equal(t, int64(1), s.circuitBreakerStats.opened.Load())
+	assert.Equal(t, int64(4), s.circuitBreakerStats.rejected.Load())
+}
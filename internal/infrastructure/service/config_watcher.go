@@ -0,0 +1,59 @@
+package service
+
+import (
+	"crypto/md5"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mgerasimchuk/protty/pkg/util"
+)
+
+// watchConfig reloads the config on SIGHUP signal and on the config file changes (checked every interval, zero disables the checking)
+func (s *ReverseProxyService) watchConfig(path string, interval time.Duration, stop <-chan struct{}) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	var tick <-chan time.Time
+	if path != "" && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	lastFileHash := getFileHash(path)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sighup:
+			s.logger.Infof("SIGHUP signal has been received, reloading the config")
+			lastFileHash = getFileHash(path)
+		case <-tick:
+			fileHash := getFileHash(path)
+			if fileHash == lastFileHash {
+				continue
+			}
+			lastFileHash = fileHash
+			s.logger.Infof("%s config file has been changed, reloading the config", path)
+		}
+		if err := s.Reload(); err != nil {
+			s.logger.Errorf("%s: %s. Keeping the current config", util.GetFuncName(s.Reload), err)
+		}
+	}
+}
+
+// getFileHash returns empty string if the file can't be read
+func getFileHash(path string) string {
+	if path == "" {
+		return ""
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", md5.Sum(content))
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/mgerasimchuk/protty/pkg/util"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type exchangeContextKey struct{}
+
+// exchange collects the data of the request/response pair passing through the proxy, it's stored in the request context.
+// It's filled in the request handling goroutine only, so it doesn't need any synchronization
+type exchange struct {
+	startedAt   time.Time
+	cfg         *config.StartCommandConfig // effective config of the request (with routes and request headers overrides)
+	route       string                     // name of the matched route
+	request     *http.Request              // request received from the client
+	requestBody []byte
+
+	upstreamURL          string
+	isUpstreamOverridden bool          // the remote URI or the upstream URIs are set by the request headers
+	upstreamRequest      *http.Request // request sent to the upstream, its body is upstreamBody
+	upstreamBody         []byte
+	upstreamResponse     *http.Response // response received from the upstream before the transformations, its body is upstreamResponseBody
+	upstreamResponseBody []byte
+	upstreamDuration     time.Duration // time from sending the request to the upstream till receiving the response headers of the last attempt
+	upstreamAttempts     int           // number of the requests sent to the upstream (more than 1 if the request is retried)
+	isFastFailed         bool          // the last attempt has been fast-failed by the open circuit breaker, it isn't retried
+
+	isThrottled       bool
+	throttleStartedAt time.Time
+	throttleWait      time.Duration // time waiting for the throttling rate limit (of all the attempts)
+
+	isRecorded bool            // the whole response body is kept for the recording
+	mirror     *mirrorExchange // is set if the mirrored responses are compared with the primary one
+	response   *exchangeResponseWriter
+}
+
+func newExchange(res http.ResponseWriter, req *http.Request) *exchange {
+	ex := &exchange{startedAt: time.Now(), request: req}
+	ex.response = &exchangeResponseWriter{ResponseWriter: res, exchange: ex}
+	return ex
+}
+
+func withExchange(ctx context.Context, ex *exchange) context.Context {
+	return context.WithValue(ctx, exchangeContextKey{}, ex)
+}
+
+// getExchange returns the exchange from the context or the stub one if it's absent, so callers don't need nil checks
+func getExchange(ctx context.Context) *exchange {
+	if ex, ok := ctx.Value(exchangeContextKey{}).(*exchange); ok {
+		return ex
+	}
+	return &exchange{response: &exchangeResponseWriter{}}
+}
+
+// exchangeResponseWriter counts the response size and keeps the status code and the beginning of the body
+// (or the whole one if the exchange is recorded)
+type exchangeResponseWriter struct {
+	http.ResponseWriter
+	exchange   *exchange
+	statusCode int
+	size       int
+	body       []byte
+}
+
+func (w *exchangeResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *exchangeResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	if w.exchange.isRecorded {
+		w.body = append(w.body, b[:n]...)
+	} else if w.exchange.cfg != nil {
+		if bodyMaxSize := w.exchange.cfg.TraceLogBodyMaxSize.Value; len(w.body) < bodyMaxSize {
+			w.body = append(w.body, b[:util.Min(n, bodyMaxSize-len(w.body))]...)
+		}
+	}
+	return n, err
+}
+
+// Flush is needed for the streaming responses, httputil.ReverseProxy flushes the response with it
+func (w *exchangeResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack is needed for the protocol switching (e.g. WebSocket), httputil.ReverseProxy hijacks the connection with it
+func (w *exchangeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.statusCode == 0 {
+		w.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the original writer for http.ResponseController
+func (w *exchangeResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// upstreamTimingTransport measures the upstream latency, it wraps the transport sending requests to the upstream
+// (inside the throttling transport, so the throttling wait time isn't counted). Every attempt has its own span,
+// which is propagated to the upstream with the traceparent header
+type upstreamTimingTransport struct {
+	next   http.RoundTripper
+	tracer trace.Tracer
+}
+
+func (t *upstreamTimingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ex := getExchange(req.Context())
+	ex.upstreamURL, ex.upstreamRequest = req.URL.String(), req
+	ex.upstreamAttempts++
+
+	ctx, span := t.tracer.Start(req.Context(), spanUpstream, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.HTTPMethod(req.Method),
+		semconv.HTTPURL(req.URL.String()),
+	))
+	defer span.End()
+	tracedReq := req.WithContext(ctx)
+	tracedReq.Header = req.Header.Clone() // the round tripper must not modify the request
+	tracePropagator.Inject(ctx, propagation.HeaderCarrier(tracedReq.Header))
+
+	startedAt := time.Now()
+	resp, err := t.next.RoundTrip(tracedReq)
+	ex.upstreamDuration = time.Since(startedAt)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	resp.Request = req // so the response transformation span isn't the child of the upstream one
+	return resp, nil
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/sirupsen/logrus"
+)
+
+// errFaultConnectionReset is returned by the response body with the injected connection reset,
+// httputil.ReverseProxy aborts the client connection on the body copying error
+var errFaultConnectionReset = errors.New("connection reset by the fault injection")
+
+// faultInjector makes the fault injection decisions for the requests with the same config, it's created with
+// the reverse proxy for the config, so the faults sequence is reproducible for the same seed.
+// It's safe for concurrent use
+type faultInjector struct {
+	cfg config.StartCommandConfig
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func newFaultInjector(cfg config.StartCommandConfig) *faultInjector {
+	seed := int64(cfg.FaultSeed.Value)
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &faultInjector{cfg: cfg, rnd: rand.New(rand.NewSource(seed))}
+}
+
+// isEnabled returns true if any fault is configured
+func (f *faultInjector) isEnabled() bool {
+	return f.cfg.FaultDelay.Value > 0 || f.cfg.FaultDelayJitter.Value > 0 || f.cfg.FaultErrorPercentage.Value > 0 ||
+		f.cfg.FaultResetPercentage.Value > 0 || f.cfg.FaultTruncatePercentage.Value > 0
+}
+
+// getDelay returns the fixed latency with the random jitter
+func (f *faultInjector) getDelay() time.Duration {
+	delay := f.cfg.FaultDelay.Value
+	if f.cfg.FaultDelayJitter.Value > 0 {
+		f.mu.Lock()
+		delay += f.rnd.Intn(f.cfg.FaultDelayJitter.Value + 1)
+		f.mu.Unlock()
+	}
+	return time.Duration(delay) * time.Millisecond
+}
+
+// isHit returns true with the percentage (0-100) probability
+func (f *faultInjector) isHit(percentage float64) bool {
+	if percentage <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rnd.Float64()*100 < percentage
+}
+
+// getErrorResponse returns the fault response for the request
+func (f *faultInjector) getErrorResponse(req *http.Request) *http.Response {
+	body := []byte(f.cfg.FaultErrorBody.Value)
+	return &http.Response{
+		Status:        strconv.Itoa(f.cfg.FaultErrorStatus.Value) + " " + http.StatusText(f.cfg.FaultErrorStatus.Value),
+		StatusCode:    f.cfg.FaultErrorStatus.Value,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}, "Content-Length": {strconv.Itoa(len(body))}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// injectBodyFault replaces the response body with the half of it followed by the connection reset or the end of the
+// body (the Content-Length header is kept, so the client gets the truncated response). It returns the injected fault name
+func (f *faultInjector) injectBodyFault(resp *http.Response) (string, error) {
+	isReset, isTruncated := f.isHit(f.cfg.FaultResetPercentage.Value), f.isHit(f.cfg.FaultTruncatePercentage.Value)
+	if !isReset && !isTruncated {
+		return "", nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if err = resp.Body.Close(); err != nil {
+		return "", err
+	}
+	if isReset {
+		resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body[:len(body)/2]), &errorReader{err: errFaultConnectionReset}))
+		return "connection reset", nil
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body[:len(body)/2]))
+	return "truncated response", nil
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// faultTransport adds the latency and answers the requests with the fault responses without sending them further,
+// it's the outermost transport, so the fault responses don't consume the throttling limit
+type faultTransport struct {
+	next     http.RoundTripper
+	injector *faultInjector
+	logger   *logrus.Logger
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if delay := t.injector.getDelay(); delay > 0 {
+		t.logger.Debugf("%s latency has been injected for %s %s request", delay, req.Method, req.URL.Path)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+	if t.injector.isHit(t.injector.cfg.FaultErrorPercentage.Value) {
+		t.logger.Debugf("%d fault response has been injected for %s %s request", t.injector.cfg.FaultErrorStatus.Value, req.Method, req.URL.Path)
+		return t.injector.getErrorResponse(req), nil
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,113 @@
+//go:build unit
+// +build unit
+
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultInjector_isHit_SeedIsReproducible(t *testing.T) {
+	cfg := config.GetStartCommandConfig()
+	cfg.FaultSeed.Value = 42
+	first, second := newFaultInjector(*cfg), newFaultInjector(*cfg)
+
+	hits := 0
+	for i := 0; i < 1000; i++ {
+		isHit := first.isHit(30)
+		assert.Equal(t, isHit, second.isHit(30))
+		if isHit {
+			hits++
+		}
+	}
+	assert.InDelta(t, 300, hits, 60)
+	assert.False(t, first.isHit(0))
+	assert.True(t, first.isHit(100))
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_Fault(t *testing.T) {
+	var upstreamRequests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstreamRequests, 1)
+		_, _ = w.Write([]byte(strings.Repeat("0123456789", 10)))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		msg             string
+		set             func(cfg *config.StartCommandConfig)
+		header          http.Header
+		wantStatus      int
+		wantBody        string
+		wantReadErr     bool
+		wantUpstream    bool
+		wantMinDuration time.Duration
+	}{
+		{"Without faults", func(cfg *config.StartCommandConfig) {}, nil, http.StatusOK, strings.Repeat("0123456789", 10), false, true, 0},
+		{
+			"Error response",
+			func(cfg *config.StartCommandConfig) {
+				cfg.FaultErrorPercentage.Value, cfg.FaultErrorStatus.Value, cfg.FaultErrorBody.Value = 100, http.StatusTooManyRequests, "slow down"
+			},
+			nil, http.StatusTooManyRequests, "slow down", false, false, 0,
+		},
+		{
+			"Error response by the request header",
+			func(cfg *config.StartCommandConfig) {},
+			http.Header{"X-Protty-Fault-Error-Percentage": {"100"}}, http.StatusServiceUnavailable, "", false, false, 0,
+		},
+		{
+			"Delay",
+			func(cfg *config.StartCommandConfig) { cfg.FaultDelay.Value, cfg.FaultDelayJitter.Value = 50, 10 },
+			nil, http.StatusOK, strings.Repeat("0123456789", 10), false, true, 50 * time.Millisecond,
+		},
+		{
+			"Truncated response",
+			func(cfg *config.StartCommandConfig) { cfg.FaultTruncatePercentage.Value = 100 },
+			nil, http.StatusOK, strings.Repeat("0123456789", 5), true, true, 0,
+		},
+		{
+			"Connection reset",
+			func(cfg *config.StartCommandConfig) { cfg.FaultResetPercentage.Value = 100 },
+			nil, http.StatusOK, strings.Repeat("0123456789", 5), true, true, 0,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			cfg := config.GetStartCommandConfig()
+			markAsAddedToCLI(cfg)
+			cfg.RemoteURI.Value = upstream.URL
+			tt.set(cfg)
+			s := newTestReverseProxyService(t, cfg, nil)
+			proxy := httptest.NewServer(http.HandlerFunc(s.handleRequestAndRedirect))
+			defer proxy.Close()
+
+			req, err := http.NewRequest(http.MethodGet, proxy.URL+"/", nil)
+			assert.NoError(t, err)
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			upstreamRequestsBefore, startedAt := atomic.LoadInt32(&upstreamRequests), time.Now()
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+
+			assert.Equal(t, tt.wantStatus, res.StatusCode)
+			assert.Equal(t, tt.wantBody, string(body))
+			assert.Equal(t, tt.wantReadErr, err != nil, "read error: %v", err)
+			assert.Equal(t, tt.wantUpstream, atomic.LoadInt32(&upstreamRequests) > upstreamRequestsBefore)
+			assert.GreaterOrEqual(t, time.Since(startedAt), tt.wantMinDuration)
+		})
+	}
+}
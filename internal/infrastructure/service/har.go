@@ -0,0 +1,175 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mgerasimchuk/protty/pkg/util"
+)
+
+// newHARWriter creates the HAR file for the exchanges recording
+func newHARWriter(path string) (*util.HARWriter, error) {
+	creator := util.HARCreator{Name: "protty", Version: "(devel)"}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		creator.Version = info.Main.Version
+	}
+	return util.NewHARWriter(path, creator)
+}
+
+// recordExchange writes the exchange to the HAR file, the upstream request and response are added if the request
+// has been sent to the upstream
+func (s *ReverseProxyService) recordExchange(ex *exchange) {
+	if s.recorder == nil {
+		return
+	}
+	req, maxSize := ex.request, 0
+	if ex.cfg != nil {
+		maxSize = ex.cfg.DecodedBodyMaxSize.Value
+	}
+	clientURL := *req.URL
+	clientURL.Scheme, clientURL.Host = "http", req.Host
+	if req.TLS != nil {
+		clientURL.Scheme = "https"
+	}
+	entry := util.HAREntry{
+		StartedDateTime: ex.startedAt,
+		Time:            getMilliseconds(time.Since(ex.startedAt)),
+		Request:         util.NewHARRequest(req.Method, &clientURL, req.Header, ex.requestBody, maxSize),
+		Response:        util.NewHARResponse(ex.response.statusCode, ex.response.Header(), ex.response.body, maxSize),
+		Timings:         util.HARTimings{Wait: getMilliseconds(ex.upstreamDuration)},
+	}
+	if ex.upstreamRequest != nil {
+		upstreamRequest := util.NewHARRequest(ex.upstreamRequest.Method, ex.upstreamRequest.URL, ex.upstreamRequest.Header, ex.upstreamBody, maxSize)
+		entry.UpstreamRequest = &upstreamRequest
+	}
+	if ex.upstreamResponse != nil {
+		upstreamResponse := util.NewHARResponse(ex.upstreamResponse.StatusCode, ex.upstreamResponse.Header, ex.upstreamResponseBody, maxSize)
+		entry.UpstreamResponse = &upstreamResponse
+	}
+	if err := s.recorder.Write(entry); err != nil {
+		s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(s.recorder.Write), err)
+	}
+}
+
+func getMilliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// harReplayTransport answers the upstream requests with the recorded responses instead of sending them. The requests
+// are matched by method, path, query and body with the recorded upstream requests (or the client ones if the HAR file
+// isn't recorded by protty). The responses of the same requests are replayed in the recorded order, the last one is
+// repeated. It's safe for concurrent use
+type harReplayTransport struct {
+	entries            []util.HAREntry
+	decodedBodyMaxSize int // of the matched request bodies
+	mu                 sync.Mutex
+	served             map[string]int // number of the served responses by the request key
+}
+
+func newHARReplayTransport(path string, decodedBodyMaxSize int) (*harReplayTransport, error) {
+	har, err := util.ReadHAR(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", util.GetFuncName(util.ReadHAR), err)
+	}
+	for i, entry := range har.Log.Entries {
+		if _, err = getReplayRequest(entry).GetBody(); err != nil {
+			return nil, fmt.Errorf("entry %d: request: %w", i, err)
+		}
+		if _, err = getReplayResponse(entry).GetEncodedBody(); err != nil {
+			return nil, fmt.Errorf("entry %d: response: %w", i, err)
+		}
+	}
+	return &harReplayTransport{entries: har.Log.Entries, decodedBodyMaxSize: decodedBodyMaxSize, served: map[string]int{}}, nil
+}
+
+func (t *harReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := []byte{}
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, fmt.Errorf("%s: %w", util.GetFuncName(io.ReadAll), err)
+		}
+		_ = req.Body.Close()
+	}
+
+	// the recorded bodies are decoded, so the request one is compared decoded too
+	if decodedBody, err := util.DecodeBody(req.Header.Get("Content-Encoding"), body, t.decodedBodyMaxSize); err == nil {
+		body = decodedBody
+	}
+	matched := []util.HARResponse{}
+	for _, entry := range t.entries {
+		if isReplayRequestMatched(getReplayRequest(entry), req, body) {
+			matched = append(matched, getReplayResponse(entry))
+		}
+	}
+	if len(matched) == 0 {
+		return newReplayResponse(req, http.StatusBadGateway, http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+			[]byte(fmt.Sprintf("protty: there is no recorded response for %s %s", req.Method, req.URL.RequestURI()))), nil
+	}
+
+	key := req.Method + " " + req.URL.Path + "?" + req.URL.Query().Encode() + "\n" + string(body)
+	t.mu.Lock()
+	i := t.served[key]
+	t.served[key]++
+	t.mu.Unlock()
+	response := matched[util.Min(i, len(matched)-1)]
+
+	responseBody, _ := response.GetEncodedBody() // the bodies are checked on the loading
+	header := response.GetHeader()
+	header.Del("Content-Length")
+	return newReplayResponse(req, response.Status, header, responseBody), nil
+}
+
+func getReplayRequest(entry util.HAREntry) util.HARRequest {
+	if entry.UpstreamRequest != nil {
+		return *entry.UpstreamRequest
+	}
+	return entry.Request
+}
+
+func getReplayResponse(entry util.HAREntry) util.HARResponse {
+	if entry.UpstreamResponse != nil {
+		return *entry.UpstreamResponse
+	}
+	return entry.Response
+}
+
+func isReplayRequestMatched(recorded util.HARRequest, req *http.Request, body []byte) bool {
+	if recorded.Method != req.Method {
+		return false
+	}
+	recordedURL, err := url.Parse(recorded.URL)
+	if err != nil || recordedURL.Path != req.URL.Path {
+		return false
+	}
+	if query := req.URL.Query(); len(query) > 0 || len(recordedURL.Query()) > 0 {
+		if !reflect.DeepEqual(recordedURL.Query(), query) {
+			return false
+		}
+	}
+	recordedBody, _ := recorded.GetBody()
+	return bytes.Equal(recordedBody, body)
+}
+
+func newReplayResponse(req *http.Request, statusCode int, header http.Header, body []byte) *http.Response {
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
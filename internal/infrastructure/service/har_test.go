@@ -0,0 +1,100 @@
+//go:build unit
+// +build unit
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/mgerasimchuk/protty/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseProxyService_handleRequestAndRedirect_RecordAndReplay(t *testing.T) {
+	var counter int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := util.EncodeBody("gzip", []byte(r.URL.RawQuery+" old response "+strings.Repeat("#", int(atomic.AddInt32(&counter, 1)))))
+		assert.NoError(t, err)
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(body)
+	}))
+	harPath := filepath.Join(t.TempDir(), "traffic.har")
+
+	cfg := config.GetStartCommandConfig()
+	markAsAddedToCLI(cfg)
+	cfg.RemoteURI.Value = upstream.URL
+	cfg.TransformRequestUrlSED.Value = "s|/source|/upstream|"
+	cfg.TransformResponseBodySED.Value = []string{"s|old|new|"}
+
+	type exchange struct {
+		method, target, body string
+		wantStatus           int
+		wantBody             string
+	}
+	send := func(s *ReverseProxyService, ex exchange) {
+		req := httptest.NewRequest(ex.method, ex.target, strings.NewReader(ex.body))
+		req.Header.Set("Accept-Encoding", "gzip")
+		res := httptest.NewRecorder()
+		s.handleRequestAndRedirect(res, req)
+		assert.Equal(t, ex.wantStatus, res.Code)
+		body, err := util.DecodeBody(res.Header().Get("Content-Encoding"), res.Body.Bytes(), 0)
+		assert.NoError(t, err)
+		assert.Equal(t, ex.wantBody, string(body))
+	}
+	recorded := []exchange{
+		{http.MethodGet, "/source?a=1&b=2", "", http.StatusOK, "a=1&b=2 new response #"},
+		{http.MethodGet, "/source?a=1&b=2", "", http.StatusOK, "a=1&b=2 new response ##"},
+		{http.MethodPost, "/source", "body", http.StatusOK, " new response ###"},
+	}
+
+	// Recording
+	s := newTestReverseProxyService(t, cfg, nil)
+	var err error
+	s.recorder, err = newHARWriter(harPath)
+	assert.NoError(t, err)
+	for _, ex := range recorded {
+		send(s, ex)
+	}
+	assert.NoError(t, s.recorder.Close())
+	upstream.Close()
+
+	har, err := util.ReadHAR(harPath)
+	assert.NoError(t, err)
+	if assert.Len(t, har.Log.Entries, 3) {
+		entry := har.Log.Entries[2]
+		assert.Equal(t, "http://example.com/source", entry.Request.URL)
+		assert.Equal(t, upstream.URL+"/upstream", entry.UpstreamRequest.URL)
+		assert.Equal(t, "body", entry.UpstreamRequest.PostData.Text)
+		assert.Equal(t, "gzip", entry.Response.GetHeader().Get("Content-Encoding"))
+		assert.Equal(t, "gzip", entry.UpstreamResponse.GetHeader().Get("Content-Encoding"))
+		assert.Equal(t, " new response ###", entry.Response.Content.Text, "the bodies are kept decoded")
+		assert.Equal(t, " old response ###", entry.UpstreamResponse.Content.Text)
+		assert.Less(t, entry.UpstreamResponse.Content.Size, entry.UpstreamResponse.BodySize, "the body size is the sent one")
+	}
+
+	// Replaying without the upstream, the recorded upstream responses are transformed again
+	s = newTestReverseProxyService(t, cfg, nil)
+	s.replay, err = newHARReplayTransport(harPath, 0)
+	assert.NoError(t, err)
+	for _, ex := range []exchange{
+		recorded[0],
+		recorded[1],
+		{http.MethodGet, "/source?b=2&a=1", "", http.StatusOK, "a=1&b=2 new response ##"}, // the last recorded response is repeated
+		recorded[2],
+		{http.MethodPost, "/source", "another body", http.StatusBadGateway, "protty: there is no recorded response for POST /upstream"},
+		{http.MethodGet, "/source?a=1", "", http.StatusBadGateway, "protty: there is no recorded response for GET /upstream?a=1"},
+	} {
+		send(s, ex)
+	}
+}
+
+func TestNewHARReplayTransport_Error(t *testing.T) {
+	_, err := newHARReplayTransport(filepath.Join(t.TempDir(), "absent.har"), 0)
+	assert.Error(t, err)
+}
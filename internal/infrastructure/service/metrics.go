@@ -0,0 +1,215 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// metricsNamespace is the prefix of the metric names
+const metricsNamespace = "protty"
+
+// Label values bounding the cardinality of the metrics with the client controlled values
+const (
+	methodLabelOther      = "other"    // any non-standard method
+	upstreamLabelOverride = "override" // any upstream set by the request headers or the transformations
+)
+
+// metrics are the Prometheus metrics of the proxy, the exchange metrics are labelled by the matched route name
+// (empty if there is no matched route) and the configured upstream scheme and host (empty if the request is answered
+// by protty, e.g. with the mock or the fault response, upstreamLabelOverride if the upstream isn't configured one).
+// The nil metrics (the metrics are disabled) ignore the observations
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests          *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	upstreamDuration  *prometheus.HistogramVec
+	responseSize      *prometheus.HistogramVec
+	throttleWait      *prometheus.HistogramVec
+	upstreamRetries   *prometheus.CounterVec
+	transformFailures *prometheus.CounterVec
+}
+
+func newMetrics(s *ReverseProxyService) *metrics {
+	m := &metrics{registry: prometheus.NewRegistry()}
+	exchangeLabels := []string{"route", "upstream"}
+	m.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "requests_total", Help: "Number of the answered requests",
+	}, []string{"route", "upstream", "method", "status"})
+	m.requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace, Name: "request_duration_seconds", Help: "Duration of the requests handling", Buckets: prometheus.DefBuckets,
+	}, exchangeLabels)
+	m.upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace, Name: "upstream_duration_seconds", Help: "Duration from sending the request to the upstream till receiving the response headers",
+		Buckets: prometheus.DefBuckets,
+	}, exchangeLabels)
+	m.responseSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace, Name: "response_size_bytes", Help: "Size of the response bodies sent to the clients",
+		Buckets: prometheus.ExponentialBuckets(100, 10, 7),
+	}, exchangeLabels)
+	m.throttleWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace, Name: "throttle_wait_seconds", Help: "Time the requests wait for the throttling rate limit",
+		Buckets: prometheus.DefBuckets,
+	}, exchangeLabels)
+	m.upstreamRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "upstream_retries_total", Help: "Number of the retried upstream requests",
+	}, exchangeLabels)
+	m.transformFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Name: "transform_failures_total", Help: "Number of the failed transformations by the option of the stage",
+	}, []string{"route", "stage"})
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.requestDuration, m.upstreamDuration, m.responseSize, m.throttleWait, m.upstreamRetries, m.transformFailures,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace, Name: "reverse_proxy_cache_size", Help: "Number of the cached reverse proxies",
+		}, func() float64 { return float64(s.state.Load().reverseProxies.Len()) }),
+		newCounterFunc("reverse_proxy_cache_hits_total", "Number of the requests served by the cached reverse proxies", func() int64 {
+			return int64(s.getReverseProxyCacheStats().Hits)
+		}),
+		newCounterFunc("reverse_proxy_cache_misses_total", "Number of the requests creating the reverse proxy", func() int64 {
+			return int64(s.getReverseProxyCacheStats().Misses)
+		}),
+		newCounterFunc("reverse_proxy_cache_evictions_total", "Number of the reverse proxies evicted from the full cache", func() int64 {
+			return int64(s.getReverseProxyCacheStats().Evictions)
+		}),
+		newCounterFunc("mirror_requests_total", "Number of the requests sent to the shadow upstreams", s.mirrorStats.requests.Load),
+		newCounterFunc("mirror_failures_total", "Number of the failed mirrored requests", s.mirrorStats.failures.Load),
+		newCounterFunc("mirror_divergences_total", "Number of the shadow responses diverged from the primary ones", s.mirrorStats.divergences.Load),
+		newCounterFunc("mirror_dropped_total", "Number of the mirrored requests dropped over the in-flight limit", s.mirrorStats.dropped.Load),
+		newCounterFunc("circuit_breaker_rejected_total", "Number of the requests fast-failed by the open circuit breakers", s.circuitBreakerStats.rejected.Load),
+	)
+	for state, counter := range map[string]func() int64{
+		circuitBreakerOpen:     s.circuitBreakerStats.opened.Load,
+		circuitBreakerHalfOpen: s.circuitBreakerStats.halfOpened.Load,
+		circuitBreakerClosed:   s.circuitBreakerStats.closed.Load,
+	} {
+		m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace, Name: "circuit_breaker_transitions_total", Help: "Number of the circuit breakers transitions to the state",
+			ConstLabels: prometheus.Labels{"state": state},
+		}, toFloat64(counter)))
+	}
+	return m
+}
+
+func newCounterFunc(name, help string, counter func() int64) prometheus.CounterFunc {
+	return prometheus.NewCounterFunc(prometheus.CounterOpts{Namespace: metricsNamespace, Name: name, Help: help}, toFloat64(counter))
+}
+
+func toFloat64(counter func() int64) func() float64 {
+	return func() float64 { return float64(counter()) }
+}
+
+// getHandler returns the metrics endpoint handler
+func (m *metrics) getHandler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// observeExchange records the metrics of the completed exchange
+func (m *metrics) observeExchange(ex *exchange) {
+	if m == nil {
+		return
+	}
+	route, upstream := ex.route, getUpstreamLabel(ex)
+	statusCode := ex.response.statusCode
+	if statusCode == 0 { // the response is aborted before the headers
+		statusCode = http.StatusBadGateway
+	}
+	m.requests.WithLabelValues(route, upstream, getMethodLabel(ex.request.Method), strconv.Itoa(statusCode)).Inc()
+	m.requestDuration.WithLabelValues(route, upstream).Observe(time.Since(ex.startedAt).Seconds())
+	m.responseSize.WithLabelValues(route, upstream).Observe(float64(ex.response.size))
+	if ex.upstreamAttempts > 0 {
+		m.upstreamDuration.WithLabelValues(route, upstream).Observe(ex.upstreamDuration.Seconds())
+	}
+	if ex.upstreamAttempts > 1 {
+		m.upstreamRetries.WithLabelValues(route, upstream).Add(float64(ex.upstreamAttempts - 1))
+	}
+	if ex.isThrottled {
+		m.throttleWait.WithLabelValues(route, upstream).Observe(ex.throttleWait.Seconds())
+	}
+}
+
+// countTransformFailure counts the transformation error by the option of the failed stage
+func (m *metrics) countTransformFailure(ex *exchange, err error) {
+	if m == nil {
+		return
+	}
+	stage := "unknown"
+	optionErr := &config.OptionError{}
+	if errors.As(err, &optionErr) {
+		stage = optionErr.Flag
+	}
+	m.transformFailures.WithLabelValues(ex.route, stage).Inc()
+}
+
+func getMethodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return methodLabelOther
+}
+
+// getUpstreamLabel returns the scheme and host of the upstream only if it's the configured one (the remote URI or
+// the upstream URIs of the config or the matched route), so the clients can't blow up the labels cardinality
+func getUpstreamLabel(ex *exchange) string {
+	if ex.upstreamURL == "" {
+		return ""
+	}
+	if ex.isUpstreamOverridden || ex.cfg == nil {
+		return upstreamLabelOverride
+	}
+	label := getSchemeAndHost(ex.upstreamURL)
+	configured := []string{getSchemeAndHost(ex.cfg.RemoteURI.Value)}
+	for _, value := range ex.cfg.UpstreamURIs.Value {
+		if u, err := config.ParseUpstream(value); err == nil {
+			configured = append(configured, u.URL.Scheme+"://"+u.URL.Host)
+		}
+	}
+	for _, configuredLabel := range configured {
+		if label != "" && label == configuredLabel {
+			return label
+		}
+	}
+	return upstreamLabelOverride
+}
+
+func getSchemeAndHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || rawURL == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// throttleWaitTransport measures the throttling wait time: the outer one wraps the throttling transport and marks
+// the start, the inner one is called by the throttling transport after the wait, adds the wait time to the exchange
+// and records the wait span
+type throttleWaitTransport struct {
+	next    http.RoundTripper
+	isOuter bool
+	tracer  trace.Tracer // of the inner one
+}
+
+func (t *throttleWaitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ex := getExchange(req.Context())
+	if t.isOuter {
+		ex.isThrottled, ex.throttleStartedAt = true, time.Now()
+	} else {
+		ex.throttleWait += time.Since(ex.throttleStartedAt)
+		_, span := t.tracer.Start(req.Context(), spanThrottleWait, trace.WithTimestamp(ex.throttleStartedAt))
+		span.End()
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,88 @@
+//go:build unit
+// +build unit
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetUpstreamLabel(t *testing.T) {
+	cfg := config.GetStartCommandConfig()
+	cfg.RemoteURI.Value, cfg.UpstreamURIs.Value = "http://10.0.0.1:8080/v1", []string{"http://10.0.0.2:8080 3"}
+
+	assert.Equal(t, "http://10.0.0.1:8080", getUpstreamLabel(&exchange{cfg: cfg, upstreamURL: "http://10.0.0.1:8080/v1/users?id=42"}))
+	assert.Equal(t, "http://10.0.0.2:8080", getUpstreamLabel(&exchange{cfg: cfg, upstreamURL: "http://10.0.0.2:8080/users"}))
+	assert.Equal(t, "", getUpstreamLabel(&exchange{cfg: cfg}))
+	assert.Equal(t, upstreamLabelOverride, getUpstreamLabel(&exchange{cfg: cfg, upstreamURL: "http://10.0.0.3:8080/users"}), "the upstream isn't configured")
+	assert.Equal(t, upstreamLabelOverride, getUpstreamLabel(&exchange{cfg: cfg, upstreamURL: "http://[::1"}))
+	assert.Equal(t, upstreamLabelOverride, getUpstreamLabel(&exchange{cfg: cfg, upstreamURL: "http://10.0.0.1:8080/v1", isUpstreamOverridden: true}))
+	assert.Equal(t, upstreamLabelOverride, getUpstreamLabel(&exchange{upstreamURL: "http://10.0.0.1:8080/v1"}))
+}
+
+func TestGetMethodLabel(t *testing.T) {
+	assert.Equal(t, http.MethodPatch, getMethodLabel(http.MethodPatch))
+	assert.Equal(t, methodLabelOther, getMethodLabel("PROPFIND"))
+	assert.Equal(t, methodLabelOther, getMethodLabel("get"))
+}
+
+func TestMetrics_countTransformFailure_Nil(t *testing.T) {
+	var m *metrics
+	assert.NotPanics(t, func() {
+		m.countTransformFailure(&exchange{}, fmt.Errorf("failure"))
+		m.observeExchange(&exchange{})
+	})
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_Metrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("not json"))
+	}))
+	defer upstream.Close()
+
+	cfg := config.GetStartCommandConfig()
+	markAsAddedToCLI(cfg)
+	cfg.RemoteURI.Value, cfg.ThrottleRateLimit.Value = upstream.URL, 20
+	cfg.Routes.Value = []string{`{"name": "api", "path-prefix": "/api", "options": {"transform-response-body-jq": [".name"]}}`}
+	s := newTestReverseProxyService(t, cfg, nil)
+	s.metrics = newMetrics(s)
+
+	for i := 0; i < 2; i++ {
+		s.handleRequestAndRedirect(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/users", nil))
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Protty-Mock-Response", `{"status": 404}`)
+	s.handleRequestAndRedirect(httptest.NewRecorder(), req)
+	req = httptest.NewRequest("RANDOM1", "/", nil)
+	req.Header.Set("X-Protty-Remote-Uri", upstream.URL+"/random2")
+	s.handleRequestAndRedirect(httptest.NewRecorder(), req)
+
+	res := httptest.NewRecorder()
+	s.metrics.getHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+	for _, want := range []string{
+		fmt.Sprintf(`protty_requests_total{method="GET",route="api",status="200",upstream="%s"} 2`, upstream.URL),
+		`protty_requests_total{method="GET",route="",status="404",upstream=""} 1`,
+		`protty_requests_total{method="other",route="",status="200",upstream="override"} 1`,
+		fmt.Sprintf(`protty_request_duration_seconds_count{route="api",upstream="%s"} 2`, upstream.URL),
+		fmt.Sprintf(`protty_upstream_duration_seconds_count{route="api",upstream="%s"} 2`, upstream.URL),
+		fmt.Sprintf(`protty_response_size_bytes_sum{route="api",upstream="%s"} 16`, upstream.URL),
+		fmt.Sprintf(`protty_throttle_wait_seconds_count{route="api",upstream="%s"} 2`, upstream.URL),
+		`protty_transform_failures_total{route="api",stage="transform-response-body-jq"} 2`,
+		`protty_reverse_proxy_cache_size 2`,
+		`protty_reverse_proxy_cache_hits_total 1`,
+		`protty_reverse_proxy_cache_misses_total 2`,
+		`protty_reverse_proxy_cache_evictions_total 0`,
+		`protty_circuit_breaker_transitions_total{state="open"} 0`,
+		`go_goroutines`,
+	} {
+		assert.Contains(t, res.Body.String(), want)
+	}
+	assert.NotContains(t, res.Body.String(), "RANDOM1")
+}
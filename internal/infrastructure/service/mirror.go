@@ -0,0 +1,185 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/mgerasimchuk/protty/pkg/util"
+)
+
+// mirrorMaxInFlight is the max number of the in-flight mirrored requests, the requests over the limit are dropped,
+// so slow shadow upstreams don't pile up the goroutines
+const mirrorMaxInFlight = 1000
+
+// mirrorStats are the mirroring counters for the whole proxy lifetime
+type mirrorStats struct {
+	requests    atomic.Int64 // sent to the shadow upstreams
+	failures    atomic.Int64 // failed to send or to receive the response
+	divergences atomic.Int64 // responses diverged from the primary ones
+	dropped     atomic.Int64 // dropped over the in-flight limit
+}
+
+// mirrorExchange passes the primary response to the mirrored requests comparing their responses with it,
+// the primary fields are read only after the done channel is closed
+type mirrorExchange struct {
+	once                sync.Once
+	done                chan struct{}
+	primaryStatusCode   int // 0 if there is no primary response (e.g. the upstream isn't available)
+	primaryEncoding     string
+	primaryResponseBody []byte
+	decodedBodyMaxSize  int // of the compared bodies
+}
+
+func newMirrorExchange(decodedBodyMaxSize int) *mirrorExchange {
+	return &mirrorExchange{done: make(chan struct{}), decodedBodyMaxSize: decodedBodyMaxSize}
+}
+
+// setPrimaryResponse keeps the primary upstream response and releases the waiting mirrored requests
+func (m *mirrorExchange) setPrimaryResponse(statusCode int, contentEncoding string, body []byte) {
+	if m == nil {
+		return
+	}
+	m.once.Do(func() {
+		m.primaryStatusCode, m.primaryEncoding, m.primaryResponseBody = statusCode, contentEncoding, body
+		close(m.done)
+	})
+}
+
+// finish releases the waiting mirrored requests if there is no primary response
+func (m *mirrorExchange) finish() {
+	if m == nil {
+		return
+	}
+	m.once.Do(func() { close(m.done) })
+}
+
+// mirrorRequest asynchronously sends the copies of the request to the mirror URIs, the responses are discarded
+// or compared with the primary one. The request body is passed separately, cos the request one is being sent
+// to the primary upstream
+func (s *ReverseProxyService) mirrorRequest(cfg config.StartCommandConfig, req *http.Request, body []byte) {
+	if len(cfg.MirrorURIs.Value) == 0 || rand.Float64()*100 >= cfg.MirrorPercentage.Value {
+		return
+	}
+	var mirror *mirrorExchange
+	if cfg.MirrorCompare.Value != "" {
+		mirror = newMirrorExchange(cfg.DecodedBodyMaxSize.Value)
+		getExchange(req.Context()).mirror = mirror
+	}
+
+	for _, mirrorURI := range cfg.MirrorURIs.Value {
+		mirrorReq, cancel, err := getMirrorRequest(mirrorURI, req, body, time.Duration(cfg.MirrorTimeout.Value)*time.Millisecond)
+		if err != nil {
+			s.mirrorStats.failures.Add(1)
+			s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(getMirrorRequest), err)
+			continue
+		}
+		select {
+		case s.mirrorSemaphore <- struct{}{}:
+		default:
+			cancel()
+			s.mirrorStats.dropped.Add(1)
+			s.logger.Warnf("%s %s request mirroring to %s has been dropped, there are %d in-flight mirrored requests", req.Method, req.URL.Path, mirrorURI, mirrorMaxInFlight)
+			continue
+		}
+		go func(mirrorReq *http.Request, cancel context.CancelFunc) {
+			defer func() { <-s.mirrorSemaphore }()
+			defer cancel()
+			s.sendMirrorRequest(cfg.MirrorCompare.Value, mirrorReq, mirror, s.getDiffMaxSize(cfg))
+		}(mirrorReq, cancel)
+	}
+}
+
+func (s *ReverseProxyService) sendMirrorRequest(compare string, req *http.Request, mirror *mirrorExchange, diffMaxSize int) {
+	s.mirrorStats.requests.Add(1)
+	resp, err := s.mirrorClient.Do(req)
+	if err != nil {
+		s.mirrorStats.failures.Add(1)
+		s.logger.Warnf("%s %s request mirroring to %s has been failed: %s", req.Method, req.URL.Path, req.URL.Host, err)
+		return
+	}
+	defer resp.Body.Close()
+	if mirror == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.mirrorStats.failures.Add(1)
+		s.logger.Warnf("%s %s request mirroring to %s has been failed: %s", req.Method, req.URL.Path, req.URL.Host, err)
+		return
+	}
+
+	select {
+	case <-mirror.done:
+	case <-req.Context().Done():
+		s.logger.Warnf("%s %s request mirroring to %s: the primary response hasn't been received for the comparison", req.Method, req.URL.Path, req.URL.Host)
+		return
+	}
+	if mirror.primaryStatusCode == 0 {
+		return
+	}
+	if divergence := getMirrorDivergence(compare, mirror, resp, body, diffMaxSize); divergence != "" {
+		s.mirrorStats.divergences.Add(1)
+		s.logger.Warnf("%s %s request mirrored to %s has the diverged response: %s", req.Method, req.URL.Path, req.URL.Host, divergence)
+	}
+}
+
+// getMirrorDivergence returns the description of the difference between the primary and the mirrored responses
+// (with the bodies diff if diffMaxSize isn't 0) or empty string if they are equal
+func getMirrorDivergence(compare string, mirror *mirrorExchange, resp *http.Response, body []byte, diffMaxSize int) string {
+	if mirror.primaryStatusCode != resp.StatusCode {
+		return fmt.Sprintf("status code %d != %d", mirror.primaryStatusCode, resp.StatusCode)
+	}
+	if compare != config.MirrorCompareBody {
+		return ""
+	}
+	primaryBody, err := util.DecodeBody(mirror.primaryEncoding, mirror.primaryResponseBody, mirror.decodedBodyMaxSize)
+	if err != nil {
+		return "primary body can't be decoded: " + err.Error()
+	}
+	if body, err = util.DecodeBody(resp.Header.Get("Content-Encoding"), body, mirror.decodedBodyMaxSize); err != nil {
+		return "body can't be decoded: " + err.Error()
+	}
+	if bytes.Equal(primaryBody, body) {
+		return ""
+	}
+	message := fmt.Sprintf("body differs, length %d != %d", len(primaryBody), len(body))
+	if diffMaxSize > 0 {
+		changes := util.UnifiedDiff(primaryBody, body)
+		if len(changes) > diffMaxSize {
+			changes = changes[:diffMaxSize] + "\n... (truncated)"
+		}
+		message += "\n" + changes
+	}
+	return message
+}
+
+// getMirrorRequest copies the request with the mirror URI scheme and host (the path of the URI is the prefix),
+// the copy has its own context with the timeout, cos the mirrored request outlives the client one
+func getMirrorRequest(mirrorURI string, req *http.Request, body []byte, timeout time.Duration) (*http.Request, context.CancelFunc, error) {
+	target, err := url.Parse(mirrorURI)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", util.GetFuncName(url.Parse), err)
+	}
+	u := *req.URL
+	u.Scheme, u.Host = target.Scheme, target.Host
+	u.Path, u.RawPath = joinURLPath(target, req.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	mirrorReq, err := http.NewRequestWithContext(ctx, req.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("%s: %w", util.GetFuncName(http.NewRequestWithContext), err)
+	}
+	mirrorReq.Header = req.Header.Clone()
+	return mirrorReq, cancel, nil
+}
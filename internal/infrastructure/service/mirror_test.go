@@ -0,0 +1,119 @@
+//go:build unit
+// +build unit
+
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseProxyService_handleRequestAndRedirect_Mirror(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("primary"))
+	}))
+	defer upstream.Close()
+
+	type mirroredRequest struct {
+		method, uri, header, body string
+	}
+	mirrored := make(chan mirroredRequest, 10)
+	shadowDelay := 300 * time.Millisecond
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		time.Sleep(shadowDelay)
+		mirrored <- mirroredRequest{r.Method, r.URL.RequestURI(), r.Header.Get("X-Added"), string(body)}
+		if r.URL.Query().Get("diverge") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = w.Write([]byte("primary"))
+	}))
+	defer shadow.Close()
+
+	tests := []struct {
+		msg             string
+		set             func(cfg *config.StartCommandConfig)
+		target          string
+		wantMirrored    []mirroredRequest
+		wantDivergences int64
+	}{
+		{
+			"Transformed request is mirrored to every URI",
+			func(cfg *config.StartCommandConfig) {
+				cfg.MirrorURIs.Value = []string{shadow.URL, shadow.URL + "/v2/"}
+			},
+			"/a%2Fb?q=1",
+			[]mirroredRequest{
+				{http.MethodPost, "/a%2Fb?q=1", "1", "new"},
+				{http.MethodPost, "/v2/a%2Fb?q=1", "1", "new"},
+			},
+			0,
+		},
+		{
+			"Sampled out",
+			func(cfg *config.StartCommandConfig) {
+				cfg.MirrorURIs.Value, cfg.MirrorPercentage.Value = []string{shadow.URL}, 0
+			},
+			"/path",
+			nil,
+			0,
+		},
+		{
+			"Equal responses",
+			func(cfg *config.StartCommandConfig) {
+				cfg.MirrorURIs.Value, cfg.MirrorCompare.Value = []string{shadow.URL}, config.MirrorCompareBody
+			},
+			"/path",
+			[]mirroredRequest{{http.MethodPost, "/path", "1", "new"}},
+			0,
+		},
+		{
+			"Diverged response",
+			func(cfg *config.StartCommandConfig) {
+				cfg.MirrorURIs.Value, cfg.MirrorCompare.Value = []string{shadow.URL}, config.MirrorCompareStatus
+			},
+			"/path?diverge=1",
+			[]mirroredRequest{{http.MethodPost, "/path?diverge=1", "1", "new"}},
+			1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			cfg := config.GetStartCommandConfig()
+			markAsAddedToCLI(cfg)
+			cfg.RemoteURI.Value = upstream.URL
+			cfg.AdditionalRequestHeaders.Value = []string{"X-Added: 1"}
+			cfg.TransformRequestBodySED.Value = []string{"s|old|new|"}
+			tt.set(cfg)
+			s := newTestReverseProxyService(t, cfg, nil)
+			res := httptest.NewRecorder()
+
+			startedAt := time.Now()
+			s.handleRequestAndRedirect(res, httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader("old")))
+
+			assert.Less(t, time.Since(startedAt), shadowDelay, "the primary response shouldn't wait for the mirrored ones")
+			assert.Equal(t, "primary", res.Body.String())
+			got := []mirroredRequest{}
+			for range tt.wantMirrored {
+				select {
+				case r := <-mirrored:
+					got = append(got, r)
+				case <-time.After(5 * time.Second):
+					t.Fatal("the request hasn't been mirrored")
+				}
+			}
+			assert.ElementsMatch(t, tt.wantMirrored, got)
+			assert.Eventually(t, func() bool { return len(s.mirrorSemaphore) == 0 }, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, int64(len(tt.wantMirrored)), s.mirrorStats.requests.Load())
+			assert.Equal(t, tt.wantDivergences, s.mirrorStats.divergences.Load())
+			assert.Zero(t, s.mirrorStats.failures.Load())
+		})
+	}
+}
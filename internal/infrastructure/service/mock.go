@@ -0,0 +1,64 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/mgerasimchuk/protty/pkg/util"
+)
+
+// serveMockResponse answers the request with the mock response without sending it to the remote resource
+func (s *ReverseProxyService) serveMockResponse(cfg config.StartCommandConfig, res http.ResponseWriter, req *http.Request) {
+	mock, err := config.ParseMockResponse(cfg.MockResponse.Value)
+	if err != nil {
+		s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(config.ParseMockResponse), err)
+		http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	requestBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(io.ReadAll), err)
+		http.Error(res, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	getExchange(req.Context()).requestBody = requestBody
+
+	data := config.MockTemplateData{Method: req.Method, Path: req.URL.Path, Query: req.URL.Query(), Headers: req.Header, RawBody: string(requestBody)}
+	if err = json.Unmarshal(requestBody, &data.Body); err != nil {
+		data.Body = nil
+	}
+	body, err := mock.RenderBody(data)
+	if err != nil {
+		s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(mock.RenderBody), err)
+		http.Error(res, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if mock.Delay > 0 {
+		timer := time.NewTimer(time.Duration(mock.Delay) * time.Millisecond)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return
+		}
+	}
+
+	for name, value := range mock.Headers {
+		res.Header().Set(name, value)
+	}
+	if res.Header().Get("Content-Type") == "" {
+		res.Header().Set("Content-Type", http.DetectContentType(body))
+	}
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.WriteHeader(mock.Status)
+	if _, err = res.Write(body); err != nil {
+		s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(res.Write), err)
+	}
+	s.logger.Debugf("%s %s request has been answered by the mock response", req.Method, req.URL.Path)
+}
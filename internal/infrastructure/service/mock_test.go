@@ -0,0 +1,60 @@
+//go:build unit
+// +build unit
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseProxyService_handleRequestAndRedirect_Mock(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("upstream"))
+	}))
+	defer upstream.Close()
+
+	cfg := config.GetStartCommandConfig()
+	markAsAddedToCLI(cfg)
+	cfg.RemoteURI.Value = upstream.URL
+	cfg.Routes.Value = []string{
+		`{"path-prefix": "/users", "methods": ["POST"], "options": {"mock-response": {"status": 201, "headers": {"Content-Type": "application/json"}, "body": "{\"name\": {{ json .Body.name }}, \"id\": \"{{ .Query.Get \"id\" }}\"}", "template": true}}}`,
+	}
+	s := newTestReverseProxyService(t, cfg, nil)
+
+	tests := []struct {
+		msg             string
+		method          string
+		target          string
+		header          http.Header
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{"Mocked route", http.MethodPost, "/users?id=42", nil, http.StatusCreated, "application/json", `{"name": "John", "id": "42"}`},
+		{"Not mocked route falls through to the upstream", http.MethodGet, "/users?id=42", nil, http.StatusOK, "text/plain; charset=utf-8", "upstream"},
+		{"Mock by the request header", http.MethodGet, "/", http.Header{"X-Protty-Mock-Response": {`{"status": 404, "body": "not found"}`}}, http.StatusNotFound, "text/plain; charset=utf-8", "not found"},
+		{"Body file can't be set by the request header", http.MethodGet, "/", http.Header{"X-Protty-Mock-Response": {`{"body-file": "/etc/passwd"}`}}, http.StatusOK, "text/plain; charset=utf-8", "upstream"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(`{"name": "John"}`))
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			res := httptest.NewRecorder()
+
+			s.handleRequestAndRedirect(res, req)
+
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantContentType, res.Header().Get("Content-Type"))
+			assert.Equal(t, tt.wantBody, res.Body.String())
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/sirupsen/logrus"
+)
+
+// retryDiscardedBodyMaxSize is the max size in bytes of the discarded response body of the retried attempt read to
+// reuse the connection, the connection of the longer body is dropped
+const retryDiscardedBodyMaxSize = 4 << 10
+
+// retryTransport retries the failed upstream requests with the exponential backoff, it's outside the throttling
+// and the upstream pool transports, so every retry consumes the throttling limit and can be sent to another upstream.
+// The request body is replayed with req.GetBody, the requests without it aren't retried. The responses fast-failed by
+// the open circuit breaker aren't retried too
+type retryTransport struct {
+	next     http.RoundTripper
+	cfg      config.StartCommandConfig
+	statuses config.StatusRanges
+	logger   *logrus.Logger
+}
+
+func newRetryTransport(next http.RoundTripper, cfg config.StartCommandConfig, logger *logrus.Logger) *retryTransport {
+	statuses, _ := config.ParseStatusRanges(cfg.RetryStatuses.Value) // the statuses are checked by the config validation
+	return &retryTransport{next: next, cfg: cfg, statuses: statuses, logger: logger}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.isRetryable(req) {
+		return t.next.RoundTrip(req)
+	}
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 {
+			attemptReq = req.Clone(req.Context())
+			if req.Body != nil && req.Body != http.NoBody {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attemptReq.Body = body
+			}
+		}
+		resp, err := t.next.RoundTrip(attemptReq)
+		if attempt > t.cfg.RetryAttempts.Value || req.Context().Err() != nil || getExchange(req.Context()).isFastFailed {
+			return resp, err
+		}
+
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else if t.statuses.Contains(resp.StatusCode) {
+			reason = resp.Status
+		} else {
+			return resp, nil
+		}
+		delay, ok := t.getDelay(attempt, resp)
+		if !ok {
+			t.logger.Debugf("%s %s request isn't retried, Retry-After %q of %s response is longer than %s", req.Method, req.URL.Path, resp.Header.Get("Retry-After"), resp.Status, t.cfg.RetryBackoffMax.GetFlagName())
+			return resp, nil
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, retryDiscardedBodyMaxSize))
+			_ = resp.Body.Close()
+		}
+		t.logger.Debugf("%s %s request is retried in %s (retry %d of %d): %s", req.Method, req.URL.Path, delay, attempt, t.cfg.RetryAttempts.Value, reason)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// isRetryable returns true if the retries are enabled, the method is retried (or the request has the Idempotency-Key
+// header) and the request body can be replayed
+func (t *retryTransport) isRetryable(req *http.Request) bool {
+	if t.cfg.RetryAttempts.Value == 0 || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return false
+	}
+	if req.Header.Get("Idempotency-Key") != "" {
+		return true
+	}
+	for _, method := range t.cfg.RetryMethods.Value {
+		if strings.EqualFold(strings.TrimSpace(method), req.Method) {
+			return true
+		}
+	}
+	return false
+}
+
+// getDelay returns the delay before the retry: the Retry-After header one or the exponential backoff with the full
+// jitter. It returns false if the Retry-After delay is longer than the max one
+func (t *retryTransport) getDelay(attempt int, resp *http.Response) (time.Duration, bool) {
+	maxDelay := time.Duration(t.cfg.RetryBackoffMax.Value) * time.Millisecond
+	if resp != nil {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return retryAfter, retryAfter <= maxDelay
+		}
+	}
+	backoff := time.Duration(t.cfg.RetryBackoff.Value) * time.Millisecond
+	for i := 1; i < attempt && backoff < maxDelay; i++ {
+		backoff *= 2
+	}
+	if backoff > maxDelay {
+		backoff = maxDelay
+	}
+	if backoff <= 0 {
+		return 0, true
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1)), true
+}
+
+// parseRetryAfter parses the Retry-After header value in seconds or HTTP date format
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
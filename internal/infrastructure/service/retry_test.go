@@ -0,0 +1,239 @@
+//go:build unit
+// +build unit
+
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		msg       string
+		value     string
+		wantDelay time.Duration
+		wantOK    bool
+	}{
+		{"Seconds", "3", 3 * time.Second, true},
+		{"Date", "Mon, 01 Jan 2024 00:00:05 GMT", 5 * time.Second, true},
+		{"Past date", "Sun, 31 Dec 2023 23:00:00 GMT", 0, true},
+		{"Empty", "", 0, false},
+		{"Invalid", "soon", 0, false},
+		{"Negative seconds", "-1", 0, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			delay, ok := parseRetryAfter(tt.value, now)
+			assert.Equal(t, tt.wantDelay, delay)
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
+
+func TestRetryTransport_getDelay(t *testing.T) {
+	cfg := config.GetStartCommandConfig()
+	cfg.RetryBackoff.Value, cfg.RetryBackoffMax.Value = 100, 300
+	transport := newRetryTransport(nil, *cfg, nil)
+
+	for attempt, wantMax := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 300 * time.Millisecond, 10: 300 * time.Millisecond} {
+		for i := 0; i < 100; i++ {
+			delay, ok := transport.getDelay(attempt, nil)
+			assert.True(t, ok)
+			assert.GreaterOrEqual(t, delay, time.Duration(0))
+			assert.LessOrEqual(t, delay, wantMax)
+		}
+	}
+
+	delay, ok := transport.getDelay(1, &http.Response{Header: http.Header{"Retry-After": {"0"}}})
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), delay)
+	_, ok = transport.getDelay(1, &http.Response{Header: http.Header{"Retry-After": {"1"}}})
+	assert.False(t, ok, "Retry-After is longer than the max delay")
+}
+
+func TestRetryTransport_RoundTrip_DiscardedBodyIsLimited(t *testing.T) {
+	cfg := config.GetStartCommandConfig()
+	cfg.RetryAttempts.Value, cfg.RetryBackoff.Value = 1, 0
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	discardedBody := &endlessBody{}
+	attempts := 0
+	transport := newRetryTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		if attempts == 1 {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable", Body: discardedBody}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}), *cfg, logger)
+
+	resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.LessOrEqual(t, discardedBody.read, retryDiscardedBodyMaxSize)
+	assert.True(t, discardedBody.isClosed)
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// endlessBody counts the read bytes of the never ending body
+type endlessBody struct {
+	read     int
+	isClosed bool
+}
+
+func (b *endlessBody) Read(p []byte) (int, error) {
+	b.read += len(p)
+	return len(p), nil
+}
+
+func (b *endlessBody) Close() error {
+	b.isClosed = true
+	return nil
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_Retry(t *testing.T) {
+	type upstreamRequest struct {
+		method, body string
+	}
+	mu := sync.Mutex{}
+	var requests []upstreamRequest
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, upstreamRequest{r.Method, string(body)})
+		attempt := len(requests)
+		mu.Unlock()
+		if retryAfter := r.URL.Query().Get("retry-after"); retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		if attempt <= 2 { // the first attempts fail
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("unavailable"))
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		msg          string
+		set          func(cfg *config.StartCommandConfig)
+		method       string
+		target       string
+		header       http.Header
+		wantStatus   int
+		wantRequests []upstreamRequest
+	}{
+		{
+			"Idempotent method is retried",
+			func(cfg *config.StartCommandConfig) {},
+			http.MethodPut, "/", nil,
+			http.StatusOK,
+			[]upstreamRequest{{http.MethodPut, "new"}, {http.MethodPut, "new"}, {http.MethodPut, "new"}},
+		},
+		{
+			"Non-idempotent method isn't retried",
+			func(cfg *config.StartCommandConfig) {},
+			http.MethodPost, "/", nil,
+			http.StatusServiceUnavailable,
+			[]upstreamRequest{{http.MethodPost, "new"}},
+		},
+		{
+			"Request with Idempotency-Key header is retried regardless of the method",
+			func(cfg *config.StartCommandConfig) {},
+			http.MethodPost, "/", http.Header{"Idempotency-Key": {"42"}},
+			http.StatusOK,
+			[]upstreamRequest{{http.MethodPost, "new"}, {http.MethodPost, "new"}, {http.MethodPost, "new"}},
+		},
+		{
+			"Retries are exhausted",
+			func(cfg *config.StartCommandConfig) { cfg.RetryAttempts.Value = 1 },
+			http.MethodPut, "/", nil,
+			http.StatusServiceUnavailable,
+			[]upstreamRequest{{http.MethodPut, "new"}, {http.MethodPut, "new"}},
+		},
+		{
+			"Status isn't retried",
+			func(cfg *config.StartCommandConfig) { cfg.RetryStatuses.Value = "502,504" },
+			http.MethodPut, "/", nil,
+			http.StatusServiceUnavailable,
+			[]upstreamRequest{{http.MethodPut, "new"}},
+		},
+		{
+			"Retry-After is respected",
+			func(cfg *config.StartCommandConfig) {},
+			http.MethodPut, "/?retry-after=0", nil,
+			http.StatusOK,
+			[]upstreamRequest{{http.MethodPut, "new"}, {http.MethodPut, "new"}, {http.MethodPut, "new"}},
+		},
+		{
+			"Too long Retry-After isn't retried",
+			func(cfg *config.StartCommandConfig) {},
+			http.MethodPut, "/?retry-after=120", nil,
+			http.StatusServiceUnavailable,
+			[]upstreamRequest{{http.MethodPut, "new"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			mu.Lock()
+			requests = nil
+			mu.Unlock()
+
+			cfg := config.GetStartCommandConfig()
+			cfg.RemoteURI.Value, cfg.RetryAttempts.Value, cfg.RetryBackoff.Value = upstream.URL, 3, 1
+			cfg.TransformRequestBodySED.Value = []string{"s/old/new/"}
+			tt.set(cfg)
+			s := newTestReverseProxyService(t, cfg, nil)
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader("old"))
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			res := httptest.NewRecorder()
+			s.handleRequestAndRedirect(res, req)
+
+			assert.Equal(t, tt.wantStatus, res.Code)
+			mu.Lock()
+			assert.Equal(t, tt.wantRequests, requests)
+			mu.Unlock()
+		})
+	}
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_RetryToAnotherUpstream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	cfg := config.GetStartCommandConfig()
+	cfg.UpstreamURIs.Value, cfg.RetryAttempts.Value, cfg.RetryBackoff.Value = []string{down.URL, upstream.URL}, 1, 1
+	s := newTestReverseProxyService(t, cfg, nil)
+
+	for i := 0; i < 4; i++ {
+		res := httptest.NewRecorder()
+		s.handleRequestAndRedirect(res, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "ok", res.Body.String())
+	}
+}
@@ -5,81 +5,405 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"math"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/graze/go-throttled"
 	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
 	"github.com/mgerasimchuk/protty/pkg/util"
 	"github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/time/rate"
 )
 
+// ConfigLoader builds the config from its sources (config file, env variables, CLI flags), used for the config reload
+type ConfigLoader func() (*config.StartCommandConfig, error)
+
+// ReverseProxyService is safe for concurrent use: the config state is swapped atomically,
+// the reverse proxies cache is guarded by its own lock and mu guards the server lifecycle fields
 type ReverseProxyService struct {
-	srv            *http.Server
-	reverseProxies map[string]*httputil.ReverseProxy
+	mu           sync.Mutex
+	srv          *http.Server
+	loadConfig   ConfigLoader
+	state        atomic.Pointer[proxyState]
+	transformSvc *TransformService
+	logger       *logrus.Logger
+
+	// statsMu guards the state replacement with the reverse proxies cache stats of the replaced states, they're added to
+	// the current state ones, so the stats aren't reset on the config reload
+	statsMu                   sync.Mutex
+	replacedReverseProxyStats util.LRUStats
+
+	// recorder and replay are set on the start before serving the requests and don't change on the config reload
+	recorder *util.HARWriter
+	replay   *harReplayTransport
+
+	mirrorClient    *http.Client
+	mirrorSemaphore chan struct{}
+	mirrorStats     mirrorStats
+
+	circuitBreakers     *util.LRU[string, *circuitBreaker] // by the upstream scheme and host, they're kept on the config reload
+	circuitBreakerStats circuitBreakerStats
+
+	// metrics and metricsSrv are set on the start if the metrics are enabled
+	metrics    *metrics
+	metricsSrv *http.Server
+	adminSrv   *http.Server // is set on the start if the admin API is enabled
+
+	// tracer is the noop one if the tracing is disabled, it's set on the start before serving the requests
+	tracer         trace.Tracer
+	tracerProvider *sdktrace.TracerProvider // is set on the start if the tracing is enabled
+}
+
+// proxyState is the config snapshot with the derived data, it's replaced as a whole on the config reload,
+// so in-flight requests keep working with the state they've started with
+type proxyState struct {
 	cfg            *config.StartCommandConfig
-	logger         *logrus.Logger
+	routes         []config.Route
+	routeConfigs   map[*config.Route]*config.StartCommandConfig // by the routes items, they're made once for the state
+	reverseProxies *util.LRU[string, *cachedReverseProxy]
+
+	upstreamPoolsMu sync.Mutex
+	upstreamPools   map[string]*upstreamPool // by getUpstreamPoolKey, the pools of the cached reverse proxies
+}
+
+// purge removes the reverse proxies and stops the upstream pools health checks, the in-flight requests keep using them
+func (state *proxyState) purge() {
+	state.reverseProxies.Purge()
+}
+
+// releaseUpstreamPool is called on the eviction of the reverse proxy using the pool, the pool is removed and its health
+// checks are stopped when the last reverse proxy using it is evicted
+func (state *proxyState) releaseUpstreamPool(pool *upstreamPool) {
+	if pool == nil {
+		return
+	}
+	state.upstreamPoolsMu.Lock()
+	pool.refs--
+	isReleased := pool.refs == 0
+	if isReleased {
+		delete(state.upstreamPools, pool.key)
+	}
+	state.upstreamPoolsMu.Unlock()
+	if isReleased {
+		pool.stop()
+	}
 }
 
-func NewReverseProxyService(logger *logrus.Logger) *ReverseProxyService {
-	s := &ReverseProxyService{logger: logger, reverseProxies: map[string]*httputil.ReverseProxy{}}
+// cachedReverseProxy is the reverse proxy for the config with its upstream pool (nil if there are no upstream URIs)
+type cachedReverseProxy struct {
+	*httputil.ReverseProxy
+	remoteURI string
+	pool      *upstreamPool
+}
+
+func NewReverseProxyService(transformSvc *TransformService, logger *logrus.Logger) *ReverseProxyService {
+	s := &ReverseProxyService{transformSvc: transformSvc, logger: logger}
+	s.mirrorClient = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	s.mirrorSemaphore = make(chan struct{}, mirrorMaxInFlight)
+	s.circuitBreakers = util.NewLRU[string, *circuitBreaker](circuitBreakersCacheSize, nil)
+	s.tracer = trace.NewNoopTracerProvider().Tracer(tracerName)
 	return s
 }
 
-func (s *ReverseProxyService) Start(cfg *config.StartCommandConfig) error {
-	s.cfg = cfg
+// Start starts the proxy, loadConfig is used for the config reloading (can be nil if the reloading isn't needed)
+func (s *ReverseProxyService) Start(cfg *config.StartCommandConfig, loadConfig ConfigLoader) error {
+	state, err := s.newProxyState(cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", util.GetFuncName(s.newProxyState), err)
+	}
+	s.state.Store(state)
+	s.logger.SetLevel(cfg.GetLogLevelLogrus())
 
-	s.logger.Infof("Start listen proxy on :%d port with config: %+v", s.cfg.LocalPort.Value, s.cfg)
+	if cfg.Replay.Value != "" {
+		if s.replay, err = newHARReplayTransport(cfg.Replay.Value, cfg.DecodedBodyMaxSize.Value); err != nil {
+			return cfg.Replay.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(newHARReplayTransport), err))
+		}
+	}
+	if cfg.Record.Value != "" {
+		// the recorder is closed on the stop after the in-flight requests are drained
+		if s.recorder, err = newHARWriter(cfg.Record.Value); err != nil {
+			return cfg.Record.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(newHARWriter), err))
+		}
+	}
+
+	if cfg.MetricsPort.Value != 0 {
+		s.metrics = newMetrics(s)
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", s.metrics.getHandler())
+		metricsSrv := s.serveInBackground("metrics", cfg.MetricsPort.Value, metricsMux)
+		s.mu.Lock()
+		s.metricsSrv = metricsSrv
+		s.mu.Unlock()
+	}
+	if cfg.AdminPort.Value != 0 {
+		adminSrv := s.serveInBackground("admin API", cfg.AdminPort.Value, s.getAdminHandler())
+		s.mu.Lock()
+		s.adminSrv = adminSrv
+		s.mu.Unlock()
+	}
+
+	if cfg.TracingEndpoint.Value != "" {
+		exporter, err := newOTLPExporter(*cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", util.GetFuncName(newOTLPExporter), err)
+		}
+		// the buffered spans are exported on the stop after the in-flight requests are drained
+		s.tracerProvider = newTracerProvider(*cfg, exporter)
+		s.tracer = s.tracerProvider.Tracer(tracerName)
+	}
+
+	s.logger.Infof("Start listen proxy on :%d port with config: %+v", cfg.LocalPort.Value, cfg.GetMasked())
+
+	if loadConfig != nil {
+		stopWatch := make(chan struct{})
+		defer close(stopWatch)
+		go s.watchConfig(cfg.Config.Value, time.Duration(cfg.ConfigWatchInterval.Value)*time.Second, stopWatch)
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleRequestAndRedirect)
-	s.srv = &http.Server{
-		Addr:    fmt.Sprintf(":%d", s.cfg.LocalPort.Value),
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.LocalPort.Value),
 		Handler: mux,
 	}
-	return s.srv.ListenAndServe()
+
+	s.mu.Lock()
+	s.srv, s.loadConfig = srv, loadConfig
+	s.mu.Unlock()
+
+	return srv.ListenAndServe()
 }
 
 func (s *ReverseProxyService) Stop(ctx context.Context) error {
 	s.logger.Infof("Stoping proxy")
-	return s.srv.Shutdown(ctx)
+	s.mu.Lock()
+	srv, metricsSrv, adminSrv := s.srv, s.metricsSrv, s.adminSrv
+	s.mu.Unlock()
+	for _, auxSrv := range []*http.Server{metricsSrv, adminSrv} {
+		if auxSrv == nil {
+			continue
+		}
+		if err := auxSrv.Shutdown(ctx); err != nil {
+			s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(auxSrv.Shutdown), err)
+		}
+	}
+	if srv == nil {
+		return nil
+	}
+	err := srv.Shutdown(ctx)
+	// The resources used by the handlers are released after the in-flight requests are drained
+	s.state.Load().purge()
+	if s.recorder != nil {
+		if err := s.recorder.Close(); err != nil {
+			s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(s.recorder.Close), err)
+		}
+	}
+	if s.tracerProvider != nil {
+		if err := s.tracerProvider.Shutdown(context.Background()); err != nil {
+			s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(s.tracerProvider.Shutdown), err)
+		}
+	}
+	return err
+}
+
+// serveInBackground starts the server of the handler (e.g. the metrics one) on the port, it's shut down on the stop
+func (s *ReverseProxyService) serveInBackground(name string, port int, handler http.Handler) *http.Server {
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: handler}
+	go func() {
+		s.logger.Infof("Start listen %s on :%d port", name, port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(srv.ListenAndServe), err)
+		}
+	}()
+	return srv
+}
+
+// Reload loads the config with the ConfigLoader passed to Start and replaces the current one if it's valid
+func (s *ReverseProxyService) Reload() error {
+	// the lock also serializes concurrent reloads (e.g. the file watcher and SIGHUP)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.loadConfig == nil {
+		return fmt.Errorf("config reloading isn't available")
+	}
+	cfg, err := s.loadConfig()
+	if err != nil {
+		return fmt.Errorf("%s: %w", util.GetFuncName(s.loadConfig), err)
+	}
+	if err = s.applyConfig(cfg); err != nil {
+		return err
+	}
+	s.logger.Infof("Config has been reloaded: %+v", cfg.GetMasked())
+	return nil
+}
+
+// applyConfig replaces the current config if it's valid, should be called under the lock
+func (s *ReverseProxyService) applyConfig(cfg *config.StartCommandConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", util.GetFuncName(cfg.Validate), err)
+	}
+	state, err := s.newProxyState(cfg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", util.GetFuncName(s.newProxyState), err)
+	}
+
+	oldState := s.swapState(state)
+	oldState.purge()
+	if oldState.cfg.LocalPort.Value != cfg.LocalPort.Value {
+		s.logger.Warnf("%s option can't be changed without restart, still listening on :%d port", cfg.LocalPort.Name, oldState.cfg.LocalPort.Value)
+	}
+	if oldState.cfg.MetricsPort.Value != cfg.MetricsPort.Value || oldState.cfg.AdminPort.Value != cfg.AdminPort.Value {
+		s.logger.Warnf("%s and %s options can't be changed without restart", cfg.MetricsPort.Name, cfg.AdminPort.Name)
+	}
+	if oldState.cfg.TracingEndpoint.Value != cfg.TracingEndpoint.Value || oldState.cfg.TracingSampleRatio.Value != cfg.TracingSampleRatio.Value ||
+		oldState.cfg.TracingServiceName.Value != cfg.TracingServiceName.Value {
+		s.logger.Warnf("%s, %s and %s options can't be changed without restart", cfg.TracingEndpoint.Name, cfg.TracingSampleRatio.Name, cfg.TracingServiceName.Name)
+	}
+	if oldState.cfg.Record.Value != cfg.Record.Value || oldState.cfg.Replay.Value != cfg.Replay.Value {
+		s.logger.Warnf("%s and %s options can't be changed without restart", cfg.Record.Name, cfg.Replay.Name)
+	}
+	s.logger.SetLevel(cfg.GetLogLevelLogrus())
+	return nil
+}
+
+// swapState replaces the current state and keeps its reverse proxies cache stats, it returns the replaced state
+func (s *ReverseProxyService) swapState(state *proxyState) *proxyState {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	oldState := s.state.Swap(state)
+	stats := oldState.reverseProxies.Stats()
+	s.replacedReverseProxyStats.Hits += stats.Hits
+	s.replacedReverseProxyStats.Misses += stats.Misses
+	s.replacedReverseProxyStats.Evictions += stats.Evictions
+	return oldState
+}
+
+// getReverseProxyCacheStats returns the reverse proxies cache stats for the whole proxy lifetime
+func (s *ReverseProxyService) getReverseProxyCacheStats() util.LRUStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	stats := s.state.Load().reverseProxies.Stats()
+	return util.LRUStats{
+		Hits:      s.replacedReverseProxyStats.Hits + stats.Hits,
+		Misses:    s.replacedReverseProxyStats.Misses + stats.Misses,
+		Evictions: s.replacedReverseProxyStats.Evictions + stats.Evictions,
+	}
+}
+
+func (s *ReverseProxyService) newProxyState(cfg *config.StartCommandConfig) (*proxyState, error) {
+	routes, err := config.ParseRoutes(cfg.Routes.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", util.GetFuncName(config.ParseRoutes), err)
+	}
+	state := &proxyState{cfg: cfg, routes: routes, routeConfigs: make(map[*config.Route]*config.StartCommandConfig, len(routes))}
+	for i := range state.routes {
+		routeCfg := *cfg
+		if err = routeCfg.SetFromRoute(state.routes[i]); err != nil {
+			return nil, fmt.Errorf("%s: route %s: %w", util.GetFuncName(routeCfg.SetFromRoute), state.routes[i].Name, err)
+		}
+		state.routeConfigs[&state.routes[i]] = &routeCfg
+	}
+	state.upstreamPools = map[string]*upstreamPool{}
+	state.reverseProxies = util.NewLRU(cfg.ReverseProxyCacheSize.Value, func(key string, reverseProxy *cachedReverseProxy) {
+		s.logger.Debugf("Reverse proxy %s has been evicted from the cache", key)
+		state.releaseUpstreamPool(reverseProxy.pool)
+	})
+	return state, nil
 }
 
 func (s *ReverseProxyService) handleRequestAndRedirect(res http.ResponseWriter, req *http.Request) {
-	s.serveReverseProxy(res, req)
-	s.logRequestPayload(req)
+	ex := newExchange(res, req)
+	ex.isRecorded = s.recorder != nil
+	ctx, span := s.startRequestSpan(req)
+	req = req.WithContext(withExchange(ctx, ex))
+	// the exchange is logged even if the response is aborted by the http.ErrAbortHandler panic (e.g. the injected connection reset)
+	defer func() {
+		ex.mirror.finish()
+		s.logExchange(ex)
+		s.recordExchange(ex)
+		s.metrics.observeExchange(ex)
+		endRequestSpan(span, ex)
+	}()
+	s.serveReverseProxy(s.state.Load(), ex.response, req)
 }
 
-func (s *ReverseProxyService) logRequestPayload(req *http.Request) {
-	s.logger.WithField("method", req.Method).WithField("path", req.URL.Path).Infof("Request have been sent to %s", req.URL.Host)
-	// TODO add tracing log with body and other params like headers
+func (s *ReverseProxyService) logExchange(ex *exchange) {
+	req := ex.request
+	if ex.upstreamURL != "" {
+		s.logger.WithField("method", req.Method).WithField("path", req.URL.Path).Infof("Request have been sent to %s", ex.upstreamURL)
+	} else { // e.g. the mock or the injected fault response
+		s.logger.WithField("method", req.Method).WithField("path", req.URL.Path).Infof("Request have been answered by protty")
+	}
+
+	if !s.logger.IsLevelEnabled(logrus.TraceLevel) {
+		return
+	}
+	entry := s.logger.WithFields(logrus.Fields{
+		"method":            req.Method,
+		"url":               req.URL.String(),
+		"upstream_url":      ex.upstreamURL,
+		"status":            ex.response.statusCode,
+		"duration":          time.Since(ex.startedAt).String(),
+		"upstream_duration": ex.upstreamDuration.String(),
+		"upstream_attempts": ex.upstreamAttempts,
+		"request_size":      len(ex.requestBody),
+		"upstream_size":     len(ex.upstreamBody),
+		"response_size":     ex.response.size,
+		"request_headers":   req.Header,
+		"response_headers":  ex.response.Header(),
+	})
+	if ex.cfg != nil && ex.cfg.TraceLogBodyMaxSize.Value > 0 {
+		entry = entry.WithFields(logrus.Fields{
+			"request_body":  string(ex.requestBody[:util.Min(len(ex.requestBody), ex.cfg.TraceLogBodyMaxSize.Value)]),
+			"response_body": string(ex.response.body[:util.Min(len(ex.response.body), ex.cfg.TraceLogBodyMaxSize.Value)]),
+		})
+	}
+	entry.Tracef("Exchange %s %s has been completed", req.Method, req.URL.Path)
 }
 
 // Serve a reverse proxy for a given url
-func (s *ReverseProxyService) serveReverseProxy(res http.ResponseWriter, req *http.Request) {
-	cfg := s.getOverrideConfig(req)
-	reverseProxy := s.getReverseProxyByParams(*cfg)
+func (s *ReverseProxyService) serveReverseProxy(state *proxyState, res http.ResponseWriter, req *http.Request) {
+	cfg := s.getOverrideConfig(state, req)
+	getExchange(req.Context()).cfg = cfg
+	if cfg.MockResponse.Value != "" {
+		s.serveMockResponse(*cfg, res, req)
+		return
+	}
+	reverseProxy := s.getReverseProxyByParams(state, *cfg)
 	modifiedReq := s.getModifiedRequest(*cfg, req)
 
 	reverseProxy.ServeHTTP(res, modifiedReq)
 }
 
 func (s *ReverseProxyService) getModifiedRequest(cfg config.StartCommandConfig, req *http.Request) *http.Request {
-	modifiedReq, err := http.NewRequest(req.Method, req.RequestURI, req.Body)
+	ctx, span := s.tracer.Start(req.Context(), spanTransformRequest)
+	defer span.End()
+
+	modifiedReq, err := http.NewRequestWithContext(req.Context(), req.Method, req.RequestURI, req.Body)
 	if err != nil {
-		s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(http.NewRequest), err)
+		s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(http.NewRequestWithContext), err)
 		return req
 	}
 
 	for headerKey, headerValues := range req.Header {
 		headerKey = strings.ToLower(headerKey)
-		if headerKey == "accept-encoding" || // Skipping encoding to keep availability for changing response (for example in case of using gzip, we would not be able to make a replacing in response body)
-			strings.HasPrefix(headerKey, "x-protty-") { // Skipping x-protty-* headers cos they need only for protty
+		if strings.HasPrefix(headerKey, "x-protty-") { // Skipping x-protty-* headers cos they need only for protty
+			continue
+		}
+		if headerKey == "accept-encoding" {
+			// Keeping only the encodings protty can decode to keep availability for changing response
+			if acceptEncoding := util.FilterAcceptEncoding(strings.Join(headerValues, ", ")); acceptEncoding != "" {
+				modifiedReq.Header.Set(headerKey, acceptEncoding)
+			}
 			continue
 		}
 		for _, headerValue := range headerValues {
@@ -96,26 +420,46 @@ func (s *ReverseProxyService) getModifiedRequest(cfg config.StartCommandConfig,
 			sourceURLRaw := modifiedReq.URL.String()
 			if modifiedURL, err := url.Parse(strings.Trim(string(modifiedURLRaw), "\n")); err == nil { // TODO remove trim (currently it is a hotfix, cos the util.SED added \n at the end unexpectedly)
 				modifiedReq.URL = modifiedURL
-				s.logger.Debugf("ModifyRequestURL: %s", getChangesLogMessage([]byte(sourceURLRaw), modifiedURLRaw, cfg.TransformRequestUrlSED.Value, cfg.TransformRequestUrlSED))
+				s.logger.Debugf("ModifyRequestURL: %s", getChangesLogMessage([]byte(sourceURLRaw), modifiedURLRaw, cfg.TransformRequestUrlSED.Value, cfg.TransformRequestUrlSED, s.getDiffMaxSize(cfg), util.UnifiedDiff))
 			} else {
 				s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(url.Parse), err)
+				s.reportTransformFailure(ctx, cfg.TransformRequestUrlSED.WrapError(err))
 				return req
 			}
 		} else {
 			s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(util.SED), err)
+			s.reportTransformFailure(ctx, cfg.TransformRequestUrlSED.WrapError(err))
 		}
 	}
 
-	// Add request headers
-	for _, header := range cfg.AdditionalRequestHeaders.Value {
-		kv := strings.SplitN(header, ": ", 2)
-		if len(kv) != 2 {
-			s.logger.Errorf("%s: %s: %s - %+v", util.GetCurrentFuncName(), util.GetFuncName(strings.SplitN), "returns not 2 values", kv)
-			continue
+	match := config.TransformMatchContext{Method: req.Method, Path: req.URL.Path, ContentType: req.Header.Get("Content-Type")}
+
+	// Transform request query, the query is rebuilt only if there are transformations to keep the original encoding
+	if len(cfg.AdditionalRequestQueryParams.Value)+len(cfg.RemoveRequestQueryParams.Value)+len(cfg.SetRequestQueryParams.Value)+
+		len(cfg.RenameRequestQueryParams.Value)+len(cfg.TransformRequestQueryJQ.Value) > 0 {
+		modifiedQuery, changes, err := s.transformSvc.TransformRequestQuery(cfg, match, modifiedReq.URL.Query(), s.getDiffMaxSize(cfg))
+		for _, change := range changes {
+			s.logger.Debugf("ModifyRequestQuery: %s", change)
+		}
+		if err != nil {
+			s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(s.transformSvc.TransformRequestQuery), err)
+			s.reportTransformFailure(ctx, err)
+		} else {
+			modifiedReq.URL.RawQuery = modifiedQuery.Encode()
 		}
-		modifiedReq.Header.Add(kv[0], kv[1])
 	}
 
+	// Transform request headers
+	modifiedHeader, changes, err := s.transformSvc.TransformRequestHeaders(cfg, match, modifiedReq.Header, s.getDiffMaxSize(cfg))
+	for _, change := range changes {
+		s.logger.Debugf("ModifyRequestHeaders: %s", change)
+	}
+	if err != nil {
+		s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(s.transformSvc.TransformRequestHeaders), err)
+		s.reportTransformFailure(ctx, err)
+	}
+	modifiedReq.Header = modifiedHeader
+
 	sourceRequestBody, err := io.ReadAll(modifiedReq.Body)
 	if err != nil {
 		s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(io.ReadAll), err)
@@ -125,56 +469,128 @@ func (s *ReverseProxyService) getModifiedRequest(cfg config.StartCommandConfig,
 		s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(modifiedReq.Body.Close), err)
 		return req
 	}
-
-	modifiedRequestBody := sourceRequestBody
-
-	// Transform request body with SED
-	for _, sedExpr := range cfg.TransformRequestBodySED.Value {
-		modifiedRequestBody, sourceRequestBody, err = util.SED(sedExpr, modifiedRequestBody)
-		if err != nil {
-			s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(util.SED), err)
-			return req
+	getExchange(req.Context()).requestBody = sourceRequestBody
+
+	// The request body is encoded back with the same encoding, cos the client has chosen the one the upstream accepts
+	contentEncoding := modifiedReq.Header.Get("Content-Encoding")
+	modifiedRequestBody, contentEncoding := s.transformEncodedBody(cfg, sourceRequestBody, contentEncoding, contentEncoding, func(body []byte) []byte {
+		modifiedBody, changes, err := s.transformSvc.TransformRequestBody(cfg, match, body, s.getDiffMaxSize(cfg))
+		for _, change := range changes {
+			s.logger.Debugf("ModifyRequestBody: %s", change)
 		}
-		s.logger.Debugf("ModifyRequestBody: %s", getChangesLogMessage(sourceRequestBody, modifiedRequestBody, sedExpr, cfg.TransformRequestBodySED))
-	}
-
-	// Transform request body with JQ
-	for _, jqExpr := range cfg.TransformRequestBodyJQ.Value {
-		modifiedRequestBody, sourceRequestBody, err = util.JQ(jqExpr, modifiedRequestBody)
 		if err != nil {
-			s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(util.JQ), err)
-			return req
+			s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(s.transformSvc.TransformRequestBody), err)
+			s.reportTransformFailure(ctx, err)
 		}
-		s.logger.Debugf("ModifyRequestBody: %s", getChangesLogMessage(sourceRequestBody, modifiedRequestBody, jqExpr, cfg.TransformRequestBodySED))
-	}
+		return modifiedBody
+	}, len(cfg.TransformRequestBodySED.Value)+len(cfg.TransformRequestBodyJQ.Value) > 0)
+	setContentEncoding(modifiedReq.Header, contentEncoding)
 
 	modifiedReq.Body = io.NopCloser(bytes.NewBuffer(modifiedRequestBody))
 	modifiedReq.ContentLength = int64(len(modifiedRequestBody))
+	// The buffered body is replayed on the retries
+	modifiedReq.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(modifiedRequestBody)), nil }
+	getExchange(req.Context()).upstreamBody = modifiedRequestBody
+	s.mirrorRequest(cfg, modifiedReq, modifiedRequestBody)
 
 	return modifiedReq
 }
 
-func (s *ReverseProxyService) getReverseProxyByParams(cfg config.StartCommandConfig) *httputil.ReverseProxy {
-	var reverseProxy *httputil.ReverseProxy
-	var ok bool
-
-	// TODO memory leak - cos for every uniq combination, additional reverseProxy is creating (possible solution: make the LRU cache *with fixed size)
-	if reverseProxy, ok = s.reverseProxies[cfg.GetStateHash()]; !ok {
+func (s *ReverseProxyService) getReverseProxyByParams(state *proxyState, cfg config.StartCommandConfig) *httputil.ReverseProxy {
+	// The creation is atomic, so concurrent requests with the same config share the same reverse proxy (and its throttling limiter)
+	cached, _ := state.reverseProxies.GetOrAdd(cfg.GetStateHash(), func() *cachedReverseProxy {
 		remoteURL, _ := url.Parse(cfg.RemoteURI.Value)
-		reverseProxy = httputil.NewSingleHostReverseProxy(remoteURL)
+		pool := s.getUpstreamPool(state, cfg)
+		if pool != nil {
+			// the pool transport sets the upstream URL, so the director only keeps the request path
+			remoteURL = &url.URL{Scheme: pool.upstreams[0].URL.Scheme, Host: pool.upstreams[0].URL.Host}
+		}
+		reverseProxy := httputil.NewSingleHostReverseProxy(remoteURL)
+		var upstreamTransport http.RoundTripper = http.DefaultTransport
+		if s.replay != nil {
+			upstreamTransport = s.replay
+		}
+		reverseProxy.Transport = &upstreamTimingTransport{next: upstreamTransport, tracer: s.tracer}
+		if cfg.CircuitBreakerFailureRatio.Value > 0 {
+			reverseProxy.Transport = newCircuitBreakerTransport(reverseProxy.Transport, cfg, s.circuitBreakers, &s.circuitBreakerStats, s.logger)
+		}
+		if pool != nil {
+			reverseProxy.Transport = &upstreamPoolTransport{next: reverseProxy.Transport, pool: pool}
+		}
 		if cfg.ThrottleRateLimit.Value != 0 {
 			// Another way to throttle requests on the handler side: https://github.com/go-chi/chi/blob/878319e482623b6e9c5787147e5b481f8879c49e/_examples/limits/main.go#L75
-			reverseProxy.Transport = throttled.NewTransport(http.DefaultTransport, rate.NewLimiter(rate.Limit(cfg.ThrottleRateLimit.Value), 1))
+			reverseProxy.Transport = &throttleWaitTransport{next: reverseProxy.Transport, tracer: s.tracer}
+			reverseProxy.Transport = throttled.NewTransport(reverseProxy.Transport, rate.NewLimiter(rate.Limit(cfg.ThrottleRateLimit.Value), 1))
+			reverseProxy.Transport = &throttleWaitTransport{next: reverseProxy.Transport, isOuter: true}
+		}
+		if cfg.RetryAttempts.Value > 0 {
+			reverseProxy.Transport = newRetryTransport(reverseProxy.Transport, cfg, s.logger)
 		}
 		reverseProxy.ModifyResponse = s.getModifyResponseFunc(cfg)
-		s.reverseProxies[cfg.GetStateHash()] = reverseProxy
-	}
+		if injector := newFaultInjector(cfg); injector.isEnabled() {
+			reverseProxy.Transport = &faultTransport{next: reverseProxy.Transport, injector: injector, logger: s.logger}
+			modifyResponse := reverseProxy.ModifyResponse
+			reverseProxy.ModifyResponse = func(resp *http.Response) error {
+				if err := modifyResponse(resp); err != nil {
+					return err
+				}
+				fault, err := injector.injectBodyFault(resp)
+				if err != nil {
+					s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(injector.injectBodyFault), err)
+				} else if fault != "" {
+					s.logger.Debugf("%s fault has been injected for %s %s request", fault, resp.Request.Method, resp.Request.URL.Path)
+				}
+				return nil
+			}
+			if cfg.FaultResetPercentage.Value > 0 {
+				// Flushing every write to send the beginning of the body before the connection reset
+				reverseProxy.FlushInterval = -1
+			}
+		}
+		return &cachedReverseProxy{ReverseProxy: reverseProxy, remoteURI: cfg.RemoteURI.Value, pool: pool}
+	})
+
+	return cached.ReverseProxy
+}
 
-	return reverseProxy
+// getUpstreamPool returns the pool of the config state for the upstream options of the config for the new cached reverse
+// proxy, the pool is kept until all the reverse proxies using it are evicted (see releaseUpstreamPool). It's nil if there
+// are no upstream URIs or the pool can't be created
+func (s *ReverseProxyService) getUpstreamPool(state *proxyState, cfg config.StartCommandConfig) *upstreamPool {
+	if len(cfg.UpstreamURIs.Value) == 0 {
+		return nil
+	}
+	key := getUpstreamPoolKey(cfg)
+	state.upstreamPoolsMu.Lock()
+	defer state.upstreamPoolsMu.Unlock()
+	pool, ok := state.upstreamPools[key]
+	if !ok {
+		var err error
+		if pool, err = newUpstreamPool(cfg, s.logger); err != nil {
+			s.logger.Errorf("%s: %s: %s. Reverting to %s", util.GetCurrentFuncName(), util.GetFuncName(newUpstreamPool), err, cfg.RemoteURI.Name)
+			return nil
+		}
+		pool.key = key
+		state.upstreamPools[key] = pool
+	}
+	pool.refs++
+	return pool
 }
 
 func (s *ReverseProxyService) getModifyResponseFunc(cfg config.StartCommandConfig) func(resp *http.Response) error {
 	return func(resp *http.Response) error {
+		ctx := context.Background()
+		if resp.Request != nil {
+			ctx = resp.Request.Context()
+		}
+		ctx, span := s.tracer.Start(ctx, spanTransformResponse)
+		defer span.End()
+
+		// The body of the protocol switching response is the upgraded connection, it's passed as is
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			return nil
+		}
+
 		sourceResponseBody, err := io.ReadAll(resp.Body)
 		if err != nil {
 			s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(io.ReadAll), err)
@@ -184,70 +600,177 @@ func (s *ReverseProxyService) getModifyResponseFunc(cfg config.StartCommandConfi
 			s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(resp.Body.Close), err)
 			return nil
 		}
+		if resp.Request != nil {
+			ex := getExchange(resp.Request.Context())
+			ex.upstreamResponse, ex.upstreamResponseBody = &http.Response{StatusCode: resp.StatusCode, Header: resp.Header.Clone()}, sourceResponseBody
+			ex.mirror.setPrimaryResponse(resp.StatusCode, resp.Header.Get("Content-Encoding"), sourceResponseBody)
+		}
 
-		modifiedResponseBody := sourceResponseBody
+		clientReq := resp.Request
+		if clientReq != nil && getExchange(clientReq.Context()).request != nil {
+			clientReq = getExchange(clientReq.Context()).request
+		}
+		match, clientAcceptEncoding := config.TransformMatchContext{ContentType: resp.Header.Get("Content-Type"), StatusCode: resp.StatusCode}, ""
+		if clientReq != nil {
+			match.Method, match.Path, clientAcceptEncoding = clientReq.Method, clientReq.URL.Path, clientReq.Header.Get("Accept-Encoding")
+		}
 
-		// Transform response body with SED
-		for _, sedExpr := range cfg.TransformResponseBodySED.Value {
-			modifiedResponseBody, sourceResponseBody, err = util.SED(sedExpr, modifiedResponseBody)
-			if err != nil {
-				s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(util.SED), err)
-				return nil
-			}
-			s.logger.Debugf("ModifyResponseBody: %s", getChangesLogMessage(sourceResponseBody, modifiedResponseBody, sedExpr, cfg.TransformResponseBodySED))
+		// The response body is encoded with the encoding preferred by the client
+		sourceEncoding := resp.Header.Get("Content-Encoding")
+		targetEncoding := util.SelectContentEncoding(clientAcceptEncoding, sourceEncoding)
+		hasRewriteRules := len(cfg.RewriteResponseRules.Value) > 0
+		hasBodyTransforms := len(cfg.TransformResponseBodySED.Value)+len(cfg.TransformResponseBodyJQ.Value) > 0
+		body, isDecoded := sourceResponseBody, false
+		if hasRewriteRules || hasBodyTransforms {
+			body, isDecoded = s.decodeBody(cfg, sourceResponseBody, sourceEncoding)
 		}
 
-		// Transform response body with SED
-		for _, jqExpr := range cfg.TransformResponseBodyJQ.Value {
-			modifiedResponseBody, sourceResponseBody, err = util.JQ(jqExpr, modifiedResponseBody)
+		// The status code and headers are rewritten even if the body can't be decoded, the rules matching the body don't
+		// match it then
+		if hasRewriteRules {
+			rewriteBody := body
+			if !isDecoded {
+				rewriteBody = nil
+			}
+			statusCode, header, rewrittenBody, changes, err := s.transformSvc.RewriteResponse(cfg, match, resp.StatusCode, resp.Header, rewriteBody)
+			for _, change := range changes {
+				s.logger.Debugf("RewriteResponse: %s", change)
+			}
 			if err != nil {
-				s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(util.JQ), err)
-				return nil
+				s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(s.transformSvc.RewriteResponse), err)
+				s.reportTransformFailure(ctx, err)
+			}
+			resp.StatusCode, resp.Status, resp.Header = statusCode, fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)), header
+			match.StatusCode, match.ContentType = statusCode, header.Get("Content-Type")
+			if isDecoded {
+				body = rewrittenBody
+			} else if rewrittenBody != nil {
+				// The body which can't be decoded has been replaced by the rule, the new body isn't encoded
+				body, isDecoded, sourceEncoding = rewrittenBody, true, ""
 			}
-			s.logger.Debugf("ModifyResponseBody: %s", getChangesLogMessage(sourceResponseBody, modifiedResponseBody, jqExpr, cfg.TransformResponseBodyJQ))
 		}
 
-		buf := bytes.NewBufferString("")
-		buf.Write(modifiedResponseBody)
-		resp.Body = io.NopCloser(buf)
+		modifiedResponseBody, contentEncoding := sourceResponseBody, sourceEncoding
+		if isDecoded {
+			if hasBodyTransforms {
+				modifiedBody, changes, err := s.transformSvc.TransformResponseBody(cfg, match, body, s.getDiffMaxSize(cfg))
+				for _, change := range changes {
+					s.logger.Debugf("ModifyResponseBody: %s", change)
+				}
+				if err != nil {
+					s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(s.transformSvc.TransformResponseBody), err)
+					s.reportTransformFailure(ctx, err)
+				}
+				body = modifiedBody
+			}
+			modifiedResponseBody, contentEncoding = s.encodeBody(body, sourceEncoding, targetEncoding)
+		}
+		setContentEncoding(resp.Header, contentEncoding)
 
 		resp.Body = io.NopCloser(bytes.NewBuffer(modifiedResponseBody))
 		resp.Header["Content-Length"] = []string{strconv.Itoa(len(modifiedResponseBody))}
 		resp.ContentLength = int64(len(modifiedResponseBody))
 
-		// Add response headers
-		for _, header := range cfg.AdditionalResponseHeaders.Value {
-			kv := strings.SplitN(header, ": ", 2)
-			if len(kv) != 2 {
-				s.logger.Errorf("%s: %s: %s - %+v", util.GetCurrentFuncName(), util.GetFuncName(strings.SplitN), "returns not 2 values", kv)
-				continue
-			}
-			resp.Header.Add(kv[0], kv[1])
+		// Transform response headers
+		modifiedHeader, changes, err := s.transformSvc.TransformResponseHeaders(cfg, match, resp.Header, s.getDiffMaxSize(cfg))
+		for _, change := range changes {
+			s.logger.Debugf("ModifyResponseHeaders: %s", change)
+		}
+		if err != nil {
+			s.logger.Errorf("%s: %s: %s", util.GetCurrentFuncName(), util.GetFuncName(s.transformSvc.TransformResponseHeaders), err)
+			s.reportTransformFailure(ctx, err)
 		}
+		resp.Header = modifiedHeader
 
 		return nil
 	}
 }
 
-func (s *ReverseProxyService) getOverrideConfig(req *http.Request) *config.StartCommandConfig {
-	cfg := *s.cfg
-	if err := cfg.SetFromHTTPRequestHeaders(req.Header, s.logger); err != nil {
-		s.logger.Errorf("%s: %s. Reverting to original config", util.GetFuncName(cfg.SetFromHTTPRequestHeaders), err)
-		cfg = *s.cfg
+// transformEncodedBody decodes the body with the source encoding, transforms it and encodes it with the target encoding.
+// The body is returned as is without transforming if there are no transforms or it can't be decoded.
+// It returns the body and its encoding
+func (s *ReverseProxyService) transformEncodedBody(cfg config.StartCommandConfig, body []byte, sourceEncoding, targetEncoding string, transform func(body []byte) []byte, hasTransforms bool) ([]byte, string) {
+	if !hasTransforms {
+		return body, sourceEncoding
 	}
-	if err := cfg.Validate(); err != nil {
-		s.logger.Errorf("%s: %s. Reverting to original config", util.GetFuncName(cfg.Validate), err)
-		cfg = *s.cfg
+	decodedBody, ok := s.decodeBody(cfg, body, sourceEncoding)
+	if !ok {
+		return body, sourceEncoding
 	}
-	return &cfg
+	return s.encodeBody(transform(decodedBody), sourceEncoding, targetEncoding)
+}
+
+// decodeBody decodes the body with the encoding, it returns false if the encoding isn't supported or the body can't be
+// decoded (e.g. the decoded body exceeds the max size)
+func (s *ReverseProxyService) decodeBody(cfg config.StartCommandConfig, body []byte, encoding string) ([]byte, bool) {
+	if len(body) == 0 || encoding == "" {
+		return body, true
+	}
+	if !util.IsSupportedContentEncoding(encoding) {
+		s.logger.Warnf("%s: %s content encoding isn't supported, the body is passed without transforming", util.GetCurrentFuncName(), encoding)
+		return body, false
+	}
+	decodedBody, err := util.DecodeBody(encoding, body, cfg.DecodedBodyMaxSize.Value)
+	if err != nil {
+		s.logger.Errorf("%s: %s: %s. The body is passed without transforming", util.GetCurrentFuncName(), util.GetFuncName(util.DecodeBody), err)
+		return body, false
+	}
+	return decodedBody, true
+}
+
+// encodeBody encodes the decoded body with the target encoding if the source body has been encoded, it returns the body
+// and its encoding
+func (s *ReverseProxyService) encodeBody(body []byte, sourceEncoding, targetEncoding string) ([]byte, string) {
+	if len(body) == 0 || sourceEncoding == "" {
+		return body, sourceEncoding
+	}
+	encodedBody, err := util.EncodeBody(targetEncoding, body)
+	if err != nil {
+		s.logger.Errorf("%s: %s: %s. The body is passed without encoding", util.GetCurrentFuncName(), util.GetFuncName(util.EncodeBody), err)
+		return body, ""
+	}
+	return encodedBody, targetEncoding
+}
+
+func setContentEncoding(header http.Header, contentEncoding string) {
+	if contentEncoding == "" {
+		header.Del("Content-Encoding")
+		return
+	}
+	header.Set("Content-Encoding", contentEncoding)
 }
 
-func getChangesLogMessage[T config.OptionValueType](source, modified []byte, expr string, o config.Option[T]) string {
-	if string(source) == string(modified) {
-		return fmt.Sprintf("the '%s' %s expression didn't change the data", expr, o.Name)
+func (s *ReverseProxyService) getOverrideConfig(state *proxyState, req *http.Request) *config.StartCommandConfig {
+	// The config and the route configs of the state have been validated, so only the request headers options are validated
+	routeCfg := state.cfg
+	if route := config.FindRoute(state.routes, req); route != nil {
+		routeCfg = state.routeConfigs[route]
+		s.logger.Debugf("%s route has been matched for %s %s request", route.Name, req.Method, req.URL.Path)
+		getExchange(req.Context()).route = route.Name
+	}
+
+	cfg := *routeCfg
+	options, err := cfg.SetFromHTTPRequestHeaders(req.Header, s.logger)
+	if err != nil {
+		s.logger.Errorf("%s: %s. Reverting to route config", util.GetFuncName(cfg.SetFromHTTPRequestHeaders), err)
+		cfg = *routeCfg
+	} else if err = cfg.ValidateOptions(options); err != nil {
+		s.logger.Errorf("%s: %s. Reverting to route config", util.GetFuncName(cfg.ValidateOptions), err)
+		cfg = *routeCfg
 	} else {
-		// TODO add diff to log
-		return fmt.Sprintf("the '%s' %s expression changed the data. Length difference: %d",
-			expr, o.Name, int(math.Abs(float64(len(source)-len(modified)))))
+		for _, option := range options {
+			if option == "RemoteURI" || option == "UpstreamURIs" {
+				getExchange(req.Context()).isUpstreamOverridden = true
+			}
+		}
+	}
+	return &cfg
+}
+
+// getDiffMaxSize returns 0 (the diff is disabled) if the debug logs are disabled to avoid the diff calculation
+func (s *ReverseProxyService) getDiffMaxSize(cfg config.StartCommandConfig) int {
+	if !s.logger.IsLevelEnabled(logrus.DebugLevel) {
+		return 0
 	}
+	return cfg.TransformDiffMaxSize.Value
 }
@@ -1 +1,555 @@
+//go:build unit
+// +build unit
+
 package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/mgerasimchuk/protty/pkg/util"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestReverseProxyService(t *testing.T, cfg *config.StartCommandConfig, loadConfig ConfigLoader) *ReverseProxyService {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	s := NewReverseProxyService(NewTransformService(), logger)
+	state, err := s.newProxyState(cfg)
+	assert.NoError(t, err)
+	s.state.Store(state)
+	s.loadConfig = loadConfig
+	return s
+}
+
+func TestReverseProxyService_Reload(t *testing.T) {
+	nextCfg := config.GetStartCommandConfig()
+	var nextErr error
+	s := newTestReverseProxyService(t, config.GetStartCommandConfig(), func() (*config.StartCommandConfig, error) {
+		cfg := *nextCfg
+		return &cfg, nextErr
+	})
+	markAsAddedToCLI(nextCfg)
+	initialState := s.state.Load()
+	s.getReverseProxyByParams(initialState, *initialState.cfg)
+
+	nextCfg.RemoteURI.Value = "http://changed"
+	assert.NoError(t, s.Reload())
+	assert.NotSame(t, initialState, s.state.Load())
+	assert.Equal(t, "http://changed", s.state.Load().cfg.RemoteURI.Value)
+	s.getReverseProxyByParams(s.state.Load(), *s.state.Load().cfg)
+	assert.Equal(t, util.LRUStats{Misses: 2}, s.getReverseProxyCacheStats(), "the cache stats aren't reset on the reload")
+
+	reloadedState := s.state.Load()
+	nextCfg.LogLevel.Value = "invalid"
+	assert.Error(t, s.Reload())
+	assert.Same(t, reloadedState, s.state.Load())
+
+	nextErr = errors.New("loading error")
+	assert.Error(t, s.Reload())
+	assert.Same(t, reloadedState, s.state.Load())
+}
+
+func TestReverseProxyService_Stop(t *testing.T) {
+	isReceived, release := make(chan struct{}), make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(isReceived)
+		<-release
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	exportedTraces := atomic.Int32{} // after the release, so the request span is ended
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isClosed(release) {
+			exportedTraces.Add(1)
+		}
+	}))
+	defer collector.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	cfg := config.GetStartCommandConfig()
+	cfg.RemoteURI.Value, cfg.LocalPort.Value = upstream.URL, listener.Addr().(*net.TCPAddr).Port
+	cfg.Record.Value, cfg.TracingEndpoint.Value = filepath.Join(t.TempDir(), "record.har"), collector.URL
+	s := newTestReverseProxyService(t, cfg, nil)
+	startErr := make(chan error, 1)
+	go func() { startErr <- s.Start(cfg, nil) }()
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	responseBody := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + addr + "/")
+		if !assert.NoError(t, err) {
+			responseBody <- ""
+			return
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		responseBody <- string(body)
+	}()
+	<-isReceived
+	stopErr := make(chan error, 1)
+	go func() { stopErr <- s.Stop(context.Background()) }()
+	time.Sleep(100 * time.Millisecond) // the stop is waiting for the in-flight request
+	assert.Equal(t, 1, s.state.Load().reverseProxies.Len(), "the state isn't purged while the request is in-flight")
+	close(release)
+
+	assert.Equal(t, "ok", <-responseBody)
+	assert.NoError(t, <-stopErr)
+	assert.ErrorIs(t, <-startErr, http.ErrServerClosed)
+	assert.Equal(t, 0, s.state.Load().reverseProxies.Len())
+	har, err := util.ReadHAR(cfg.Record.Value)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(har.Log.Entries), "the in-flight request is recorded before the recorder is closed")
+	assert.Equal(t, int32(1), exportedTraces.Load(), "the span of the in-flight request is exported before the tracer provider is shut down")
+}
+
+func TestReverseProxyService_watchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "protty.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("version: 1\nremote-uri: http://initial\n"), 0o600))
+	loadConfig := func() (*config.StartCommandConfig, error) {
+		cfg := config.GetStartCommandConfig()
+		markAsAddedToCLI(cfg)
+		return cfg, cfg.SetFromFile(path, func(string) bool { return false })
+	}
+	cfg, err := loadConfig()
+	assert.NoError(t, err)
+	s := newTestReverseProxyService(t, cfg, loadConfig)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.watchConfig(path, 10*time.Millisecond, stop)
+	time.Sleep(50 * time.Millisecond) // waiting for the watcher reads the initial file state
+
+	assert.NoError(t, os.WriteFile(path, []byte("version: 1\nremote-uri: http://changed\n"), 0o600))
+	assert.Eventually(t, func() bool { return s.state.Load().cfg.RemoteURI.Value == "http://changed" }, time.Second, 10*time.Millisecond)
+}
+
+// markAsAddedToCLI marks all the options as added to the CLI to pass the config validation
+func markAsAddedToCLI(cfg *config.StartCommandConfig) {
+	e := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < e.NumField(); i++ {
+		e.Field(i).Addr().MethodByName("MarkAsAddedToCLI").Call(nil)
+	}
+}
+
+func TestReverseProxyService_getReverseProxyByParams_CacheIsBounded(t *testing.T) {
+	cfg := config.GetStartCommandConfig()
+	cfg.ReverseProxyCacheSize.Value = 2
+	s := newTestReverseProxyService(t, cfg, nil)
+	state := s.state.Load()
+
+	for _, remoteURI := range []string{"http://first", "http://second", "http://third", "http://third"} {
+		overrideCfg := *cfg
+		overrideCfg.RemoteURI.Value = remoteURI
+		s.getReverseProxyByParams(state, overrideCfg)
+	}
+	assert.Equal(t, 2, state.reverseProxies.Len())
+	assert.Equal(t, util.LRUStats{Hits: 1, Misses: 3, Evictions: 1}, state.reverseProxies.Stats())
+}
+
+func TestReverseProxyService_getOverrideConfig(t *testing.T) {
+	cfg := config.GetStartCommandConfig()
+	cfg.Routes.Value = []string{`{"name": "api", "path-prefix": "/api", "options": {"retry-attempts": 2}}`}
+	s := newTestReverseProxyService(t, cfg, nil)
+	state := s.state.Load()
+
+	tests := []struct {
+		msg               string
+		path              string
+		header            http.Header
+		wantRetryAttempts int
+		wantLoadBalancing string
+	}{
+		{"Base config", "/", nil, 0, config.LoadBalancingRoundRobin},
+		{"Route config", "/api/users", nil, 2, config.LoadBalancingRoundRobin},
+		{"Request header", "/api/users", http.Header{"X-Protty-Retry-Attempts": {"3"}}, 3, config.LoadBalancingRoundRobin},
+		{"Invalid request header", "/api/users", http.Header{"X-Protty-Retry-Attempts": {"-1"}}, 2, config.LoadBalancingRoundRobin},
+		{"Related option is validated", "/", http.Header{"X-Protty-Load-Balancing": {config.LoadBalancingConsistentHash}}, 0, config.LoadBalancingRoundRobin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header = tt.header
+			overrideCfg := s.getOverrideConfig(state, req)
+			assert.Equal(t, tt.wantRetryAttempts, overrideCfg.RetryAttempts.Value)
+			assert.Equal(t, tt.wantLoadBalancing, overrideCfg.LoadBalancing.Value)
+		})
+	}
+	assert.Equal(t, 0, state.cfg.RetryAttempts.Value, "the state config isn't changed")
+	for _, routeCfg := range state.routeConfigs {
+		assert.Equal(t, 2, routeCfg.RetryAttempts.Value, "the route config isn't changed")
+	}
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_Concurrency(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("response from " + r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	cfg := config.GetStartCommandConfig()
+	markAsAddedToCLI(cfg)
+	cfg.RemoteURI.Value = upstream.URL
+	cfg.ReverseProxyCacheSize.Value = 3 // smaller than the number of the header combinations to check evictions as well
+	s := newTestReverseProxyService(t, cfg, func() (*config.StartCommandConfig, error) {
+		reloadedCfg := *cfg
+		return &reloadedCfg, nil
+	})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%50 == 0 {
+				assert.NoError(t, s.Reload())
+			}
+			variant := i % 5
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/path-%d", variant), nil)
+			req.Header.Set("X-PROTTY-TRANSFORM-RESPONSE-BODY-SED", fmt.Sprintf("s|response|variant-%d|", variant))
+			req.Header.Set("X-PROTTY-ADDITIONAL-RESPONSE-HEADERS", fmt.Sprintf("X-Variant: %d", variant))
+			res := httptest.NewRecorder()
+
+			s.handleRequestAndRedirect(res, req)
+
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, fmt.Sprintf("variant-%d from /path-%d", variant, variant), res.Body.String())
+			assert.Equal(t, strconv.Itoa(variant), res.Header().Get("X-Variant"))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestReverseProxyService_logExchange(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", "1")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("response body"))
+	}))
+	defer upstream.Close()
+
+	cfg := config.GetStartCommandConfig()
+	markAsAddedToCLI(cfg)
+	cfg.RemoteURI.Value = upstream.URL
+	cfg.TraceLogBodyMaxSize.Value = 8
+	cfg.TransformRequestUrlSED.Value = "s|/source|/upstream|"
+	s := newTestReverseProxyService(t, cfg, nil)
+	s.logger.SetLevel(logrus.TraceLevel)
+	hook := logrustest.NewLocal(s.logger)
+
+	req := httptest.NewRequest(http.MethodPost, "/source?q=1", strings.NewReader("request body"))
+	req.Header.Set("X-Client", "1")
+	s.handleRequestAndRedirect(httptest.NewRecorder(), req)
+
+	entry := hook.LastEntry()
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, logrus.TraceLevel, entry.Level)
+		assert.Equal(t, http.MethodPost, entry.Data["method"])
+		assert.Equal(t, "/source?q=1", entry.Data["url"])
+		assert.Equal(t, upstream.URL+"/upstream?q=1", entry.Data["upstream_url"])
+		assert.Equal(t, http.StatusCreated, entry.Data["status"])
+		assert.Equal(t, len("request body"), entry.Data["request_size"])
+		assert.Equal(t, len("response body"), entry.Data["response_size"])
+		assert.Equal(t, "1", entry.Data["request_headers"].(http.Header).Get("X-Client"))
+		assert.Equal(t, "1", entry.Data["response_headers"].(http.Header).Get("X-Upstream"))
+		assert.Equal(t, "request ", entry.Data["request_body"])
+		assert.Equal(t, "response", entry.Data["response_body"])
+		assert.NotEmpty(t, entry.Data["upstream_duration"])
+	}
+}
+
+func TestGetChangesLogMessage(t *testing.T) {
+	cfg := config.GetStartCommandConfig()
+	tests := []struct {
+		msg         string
+		source      string
+		modified    string
+		diffMaxSize int
+		want        string
+	}{
+		{"Not changed", "a", "a", 100, "the 's|a|b|' TransformResponseBodySED expression didn't change the data"},
+		{"Diff disabled", "a", "bb", 0, "the 's|a|b|' TransformResponseBodySED expression changed the data. Length difference: 1"},
+		{
+			"Diff",
+			"a", "b", 100,
+			"the 's|a|b|' TransformResponseBodySED expression changed the data. Length difference: 0\n--- source\n+++ modified\n@@ -1,1 +1,1 @@\n-a\n+b",
+		},
+		{
+			"Truncated diff",
+			"a", "b", 10,
+			"the 's|a|b|' TransformResponseBodySED expression changed the data. Length difference: 0\n--- source\n... (truncated)",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			actual := getChangesLogMessage([]byte(tt.source), []byte(tt.modified), "s|a|b|", cfg.TransformResponseBodySED, tt.diffMaxSize, util.UnifiedDiff)
+			assert.Equal(t, tt.want, actual)
+		})
+	}
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_ContentEncoding(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestBody, _ := io.ReadAll(r.Body)
+		requestBody, err := util.DecodeBody(r.Header.Get("Content-Encoding"), requestBody, 0)
+		assert.NoError(t, err)
+
+		encoding := util.SelectContentEncoding(r.Header.Get("Accept-Encoding"), "")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			encoding = "gzip" // ignoring the client weights to check the re-encoding
+		}
+		body, err := util.EncodeBody(encoding, []byte(`{"status": "old", "request": "`+string(requestBody)+`"}`))
+		assert.NoError(t, err)
+		if encoding != "" {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		_, _ = w.Write(body)
+	}))
+	defer upstream.Close()
+
+	cfg := config.GetStartCommandConfig()
+	markAsAddedToCLI(cfg)
+	cfg.RemoteURI.Value = upstream.URL
+	cfg.TransformRequestBodySED.Value = []string{"s|old|new|"}
+	cfg.TransformResponseBodySED.Value = []string{"s|old|new|"}
+	s := newTestReverseProxyService(t, cfg, nil)
+
+	tests := []struct {
+		msg                 string
+		acceptEncoding      string
+		requestEncoding     string
+		wantContentEncoding string
+	}{
+		{"Identity", "", "", ""},
+		{"Gzip", "gzip", "gzip", "gzip"},
+		{"Deflate", "deflate", "deflate", "deflate"},
+		{"Brotli", "br", "br", "br"},
+		{"Zstd", "zstd", "zstd", "zstd"},
+		{"Preferred by the client", "gzip;q=0.5, br", "gzip", "br"},
+		{"Unsupported by protty", "compress", "", ""},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			requestBody, err := util.EncodeBody(tt.requestEncoding, []byte("old"))
+			assert.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(requestBody)))
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			req.Header.Set("Content-Encoding", tt.requestEncoding)
+			res := httptest.NewRecorder()
+
+			s.handleRequestAndRedirect(res, req)
+
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, tt.wantContentEncoding, res.Header().Get("Content-Encoding"))
+			assert.Equal(t, strconv.Itoa(res.Body.Len()), res.Header().Get("Content-Length"))
+			body, err := util.DecodeBody(res.Header().Get("Content-Encoding"), res.Body.Bytes(), 0)
+			assert.NoError(t, err)
+			assert.Equal(t, `{"status": "new", "request": "new"}`, string(body))
+		})
+	}
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_RewriteUndecodableResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", r.URL.Query().Get("encoding"))
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("not encoded"))
+	}))
+	defer upstream.Close()
+
+	cfg := config.GetStartCommandConfig()
+	markAsAddedToCLI(cfg)
+	cfg.RemoteURI.Value = upstream.URL
+	cfg.TransformResponseBodySED.Value = []string{"s|not|was|"}
+	s := newTestReverseProxyService(t, cfg, nil)
+
+	tests := []struct {
+		msg                 string
+		rule                string
+		wantStatus          int
+		wantRewrittenHeader string
+		wantBody            string
+		isBodyEncoded       bool
+	}{
+		{"Status and headers", `{"status": "404", "set-status": 503, "set-headers": {"X-Rewritten": "true"}}`, 503, "true", "not encoded", true},
+		{"Rule matching the body", `{"status": "404", "body": "encoded", "set-status": 503, "set-headers": {"X-Rewritten": "true"}}`, 404, "", "not encoded", true},
+		{"Body", `{"status": "404", "set-status": 503, "set-headers": {"X-Rewritten": "true"}, "set-body": "not available"}`, 503, "true", "was available", false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		// deflate is supported, but the body can't be decoded, compress isn't supported
+		for _, encoding := range []string{"deflate", "compress"} {
+			encoding := encoding
+			t.Run(tt.msg+" "+encoding, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, "/?encoding="+encoding, nil)
+				req.Header.Set("X-Protty-Rewrite-Response-Rules", tt.rule)
+				res := httptest.NewRecorder()
+
+				s.handleRequestAndRedirect(res, req)
+
+				wantContentEncoding := ""
+				if tt.isBodyEncoded {
+					wantContentEncoding = encoding
+				}
+				assert.Equal(t, tt.wantStatus, res.Code)
+				assert.Equal(t, tt.wantRewrittenHeader, res.Header().Get("X-Rewritten"))
+				assert.Equal(t, wantContentEncoding, res.Header().Get("Content-Encoding"))
+				assert.Equal(t, tt.wantBody, res.Body.String())
+			})
+		}
+	}
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_DecodedBodyMaxSize(t *testing.T) {
+	body := bytes.Repeat([]byte("a"), 1024)
+	encodedBody, err := util.EncodeBody("deflate", body)
+	assert.NoError(t, err)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "deflate")
+		_, _ = w.Write(encodedBody)
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		maxSize  int
+		wantBody string
+	}{
+		{len(body) - 1, string(body)},
+		{len(body), "b" + string(body[1:])},
+		{0, "b" + string(body[1:])},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(strconv.Itoa(tt.maxSize), func(t *testing.T) {
+			cfg := config.GetStartCommandConfig()
+			markAsAddedToCLI(cfg)
+			cfg.RemoteURI.Value = upstream.URL
+			cfg.DecodedBodyMaxSize.Value = tt.maxSize
+			cfg.TransformResponseBodySED.Value = []string{"s|a|b|"}
+			s := newTestReverseProxyService(t, cfg, nil)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			res := httptest.NewRecorder()
+
+			s.handleRequestAndRedirect(res, req)
+
+			decodedBody, err := util.DecodeBody(res.Header().Get("Content-Encoding"), res.Body.Bytes(), 0)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantBody, string(decodedBody))
+		})
+	}
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_Upgrade(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + r.Header.Get("Upgrade") + "\r\n\r\n")
+		_ = rw.Flush()
+		line, _ := rw.ReadString('\n')
+		_, _ = rw.WriteString("echo " + line)
+		_ = rw.Flush()
+	}))
+	defer upstream.Close()
+
+	cfg := config.GetStartCommandConfig()
+	markAsAddedToCLI(cfg)
+	cfg.RemoteURI.Value = upstream.URL
+	s := newTestReverseProxyService(t, cfg, nil)
+	proxy := httptest.NewServer(http.HandlerFunc(s.handleRequestAndRedirect))
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: test-protocol\r\n\r\n"))
+	assert.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+	assert.Equal(t, "test-protocol", res.Header.Get("Upgrade"))
+
+	_, err = conn.Write([]byte("ping\n"))
+	assert.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "echo ping\n", line)
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_Query(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.RawQuery))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		msg  string
+		set  func(cfg *config.StartCommandConfig)
+		want string
+	}{
+		{"Without transformations the original encoding is kept", func(cfg *config.StartCommandConfig) {}, "b=2&a=%7E1"},
+		{
+			"Transformed query is encoded",
+			func(cfg *config.StartCommandConfig) {
+				cfg.SetRequestQueryParams.Value = []string{"token=a b&c"}
+				cfg.TransformRequestQueryJQ.Value = []string{`del(.b)`}
+			},
+			"a=~1&token=a+b%26c",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			cfg := config.GetStartCommandConfig()
+			markAsAddedToCLI(cfg)
+			cfg.RemoteURI.Value = upstream.URL
+			tt.set(cfg)
+			s := newTestReverseProxyService(t, cfg, nil)
+			res := httptest.NewRecorder()
+
+			s.handleRequestAndRedirect(res, httptest.NewRequest(http.MethodGet, "/?b=2&a=%7E1", nil))
+
+			assert.Equal(t, http.StatusOK, res.Code)
+			assert.Equal(t, tt.want, res.Body.String())
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/mgerasimchuk/protty/pkg/util"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope name of the proxy spans
+const tracerName = "github.com/mgerasimchuk/protty"
+
+// Span names of the proxy pipeline stages, they are the children of the request span
+const (
+	spanTransformRequest  = "transform request"
+	spanThrottleWait      = "throttle wait"
+	spanUpstream          = "upstream round trip"
+	spanTransformResponse = "transform response"
+)
+
+// routeAttributeKey is the attribute of the matched route name
+const routeAttributeKey = attribute.Key("protty.route")
+
+// tracePropagator propagates the W3C traceparent and tracestate headers from the client and to the upstream
+var tracePropagator = propagation.TraceContext{}
+
+// newOTLPExporter returns the exporter sending the spans to the OTLP/HTTP endpoint of the config
+func newOTLPExporter(cfg config.StartCommandConfig) (sdktrace.SpanExporter, error) {
+	endpoint, err := url.Parse(cfg.TracingEndpoint.Value)
+	if err != nil {
+		return nil, cfg.TracingEndpoint.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(url.Parse), err))
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint.Host)}
+	if endpoint.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if endpoint.Path != "" && endpoint.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(endpoint.Path))
+	}
+	return otlptracehttp.New(context.Background(), opts...)
+}
+
+// newTracerProvider returns the provider exporting the sampled spans in batches
+func newTracerProvider(cfg config.StartCommandConfig, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio.Value))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.TracingServiceName.Value))),
+	)
+}
+
+// startRequestSpan starts the request span continuing the trace of the client (if the request has the traceparent header)
+func (s *ReverseProxyService) startRequestSpan(req *http.Request) (context.Context, trace.Span) {
+	ctx := tracePropagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	return s.tracer.Start(ctx, req.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		semconv.HTTPMethod(req.Method),
+		semconv.HTTPTarget(req.URL.RequestURI()),
+	))
+}
+
+// endRequestSpan ends the request span with the results of the completed exchange
+func endRequestSpan(span trace.Span, ex *exchange) {
+	if ex.route != "" {
+		span.SetName(ex.request.Method + " " + ex.route)
+		span.SetAttributes(routeAttributeKey.String(ex.route))
+	}
+	if ex.upstreamURL != "" {
+		span.SetAttributes(semconv.HTTPURL(ex.upstreamURL))
+	}
+	span.SetAttributes(semconv.HTTPStatusCode(ex.response.statusCode))
+	if ex.response.statusCode == 0 || ex.response.statusCode >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(ex.response.statusCode))
+	}
+	span.End()
+}
+
+// reportTransformFailure counts the transformation error in the metrics and records it in the span of the context
+func (s *ReverseProxyService) reportTransformFailure(ctx context.Context, err error) {
+	s.metrics.countTransformFailure(getExchange(ctx), err)
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,121 @@
+//go:build unit
+// +build unit
+
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestReverseProxyService_handleRequestAndRedirect_Tracing(t *testing.T) {
+	const clientTraceID, clientSpanID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("Traceparent")
+		_, _ = w.Write([]byte("not json"))
+	}))
+	defer upstream.Close()
+
+	cfg := config.GetStartCommandConfig()
+	markAsAddedToCLI(cfg)
+	cfg.RemoteURI.Value, cfg.ThrottleRateLimit.Value = upstream.URL, 100
+	cfg.Routes.Value = []string{`{"name": "api", "path-prefix": "/api", "options": {"transform-response-body-jq": [".name"]}}`}
+	s := newTestReverseProxyService(t, cfg, nil)
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := newTracerProvider(*cfg, exporter)
+	s.tracer = tracerProvider.Tracer(tracerName)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	req.Header.Set("Traceparent", "00-"+clientTraceID+"-"+clientSpanID+"-01")
+	s.handleRequestAndRedirect(httptest.NewRecorder(), req)
+	assert.NoError(t, tracerProvider.ForceFlush(context.Background()))
+
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		assert.Equal(t, clientTraceID, span.SpanContext.TraceID().String(), span.Name)
+		spans[span.Name] = span
+	}
+	if !assert.Len(t, spans, 5) {
+		return
+	}
+	requestSpan := spans["GET api"]
+	assert.Equal(t, clientSpanID, requestSpan.Parent.SpanID().String(), "the client trace is continued")
+	assert.Equal(t, trace.SpanKindServer, requestSpan.SpanKind)
+	for _, name := range []string{spanTransformRequest, spanThrottleWait, spanUpstream, spanTransformResponse} {
+		assert.Equal(t, requestSpan.SpanContext.SpanID(), spans[name].Parent.SpanID(), name)
+	}
+
+	upstreamSpan := spans[spanUpstream]
+	assert.Equal(t, trace.SpanKindClient, upstreamSpan.SpanKind)
+	assert.Equal(t, "00-"+clientTraceID+"-"+upstreamSpan.SpanContext.SpanID().String()+"-01", upstreamTraceparent)
+
+	transformSpan := spans[spanTransformResponse]
+	assert.Equal(t, codes.Error, transformSpan.Status.Code)
+	if assert.Len(t, transformSpan.Events, 1) {
+		assert.Equal(t, "exception", transformSpan.Events[0].Name)
+	}
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_TracingNotSampled(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := config.GetStartCommandConfig()
+	cfg.RemoteURI.Value, cfg.TracingSampleRatio.Value = upstream.URL, 0
+	s := newTestReverseProxyService(t, cfg, nil)
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := newTracerProvider(*cfg, exporter)
+	s.tracer = tracerProvider.Tracer(tracerName)
+
+	s.handleRequestAndRedirect(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.NoError(t, tracerProvider.ForceFlush(context.Background()))
+	assert.Empty(t, exporter.GetSpans())
+}
+
+func TestNewOTLPExporter(t *testing.T) {
+	tests := []struct {
+		msg      string
+		path     string
+		wantPath string
+	}{
+		{"Default path", "", "/v1/traces"},
+		{"Custom path", "/otlp/traces", "/otlp/traces"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			mu := sync.Mutex{}
+			var paths []string
+			collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				paths = append(paths, r.Method+" "+r.URL.Path)
+				mu.Unlock()
+			}))
+			defer collector.Close()
+
+			cfg := config.GetStartCommandConfig()
+			cfg.TracingEndpoint.Value = collector.URL + tt.path
+			exporter, err := newOTLPExporter(*cfg)
+			if !assert.NoError(t, err) {
+				return
+			}
+			tracerProvider := newTracerProvider(*cfg, exporter)
+			_, span := tracerProvider.Tracer(tracerName).Start(context.Background(), "test")
+			span.End()
+			assert.NoError(t, tracerProvider.Shutdown(context.Background()))
+
+			mu.Lock()
+			assert.Equal(t, []string{http.MethodPost + " " + tt.wantPath}, paths)
+			mu.Unlock()
+		})
+	}
+}
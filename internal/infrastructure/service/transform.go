@@ -0,0 +1,354 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/mgerasimchuk/protty/pkg/util"
+)
+
+// TransformService applies the body transformation pipelines, it's shared by the proxy and the transform command
+// to guarantee the same results
+type TransformService struct{}
+
+func NewTransformService() *TransformService {
+	return &TransformService{}
+}
+
+// TransformRequestBody applies the request body SED and then JQ pipelines.
+// It returns the changes log message for every stage (with the diff if diffMaxSize isn't 0), in the error case returns the original body
+func (s *TransformService) TransformRequestBody(cfg config.StartCommandConfig, match config.TransformMatchContext, body []byte, diffMaxSize int) ([]byte, []string, error) {
+	return s.transformBody(body, cfg.TransformRequestBodySED, cfg.TransformRequestBodyJQ, cfg.GetJQOptions(), match, diffMaxSize)
+}
+
+// TransformResponseBody applies the response body SED and then JQ pipelines.
+// It returns the changes log message for every stage (with the diff if diffMaxSize isn't 0), in the error case returns the original body
+func (s *TransformService) TransformResponseBody(cfg config.StartCommandConfig, match config.TransformMatchContext, body []byte, diffMaxSize int) ([]byte, []string, error) {
+	return s.transformBody(body, cfg.TransformResponseBodySED, cfg.TransformResponseBodyJQ, cfg.GetJQOptions(), match, diffMaxSize)
+}
+
+// RewriteResponse applies the matched response rewriting rules in order, every rule is matched against the response
+// rewritten by the previous ones. It returns the rewritten status code, headers and body and the log message for every
+// applied rule, in the error case returns the original ones
+func (s *TransformService) RewriteResponse(cfg config.StartCommandConfig, match config.TransformMatchContext, statusCode int, header http.Header, body []byte) (int, http.Header, []byte, []string, error) {
+	modifiedStatusCode, modifiedHeader, modifiedBody, changes := statusCode, header, body, []string{}
+	for i, value := range cfg.RewriteResponseRules.Value {
+		rule, err := config.ParseRewriteRule(value)
+		if err != nil {
+			return statusCode, header, body, changes, cfg.RewriteResponseRules.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(config.ParseRewriteRule), err))
+		}
+		match.StatusCode, match.ContentType = modifiedStatusCode, modifiedHeader.Get("Content-Type")
+		if !rule.MatchResponse(match, modifiedBody) {
+			continue
+		}
+		sourceStatusCode := modifiedStatusCode
+		modifiedStatusCode, modifiedHeader, modifiedBody = rule.Apply(modifiedStatusCode, modifiedHeader, modifiedBody)
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule-%d", i)
+		}
+		changes = append(changes, fmt.Sprintf("the %s %s rule has been applied, status: %d -> %d", name, cfg.RewriteResponseRules.Name, sourceStatusCode, modifiedStatusCode))
+	}
+	return modifiedStatusCode, modifiedHeader, modifiedBody, changes, nil
+}
+
+// TransformRequestHeaders applies the request headers transformations in the following order: removing, renaming,
+// setting, adding, SED and then JQ. It returns the transformed copy of the headers and the changes log message for
+// every SED and JQ stage, in the error case returns the original headers
+func (s *TransformService) TransformRequestHeaders(cfg config.StartCommandConfig, match config.TransformMatchContext, header http.Header, diffMaxSize int) (http.Header, []string, error) {
+	return s.transformHeaders(header, headersTransformOptions{
+		remove: cfg.RemoveRequestHeaders, rename: cfg.RenameRequestHeaders, set: cfg.SetRequestHeaders,
+		add: cfg.AdditionalRequestHeaders, sed: cfg.TransformRequestHeadersSED, jq: cfg.TransformRequestHeadersJQ,
+	}, match, diffMaxSize)
+}
+
+// TransformResponseHeaders is the TransformRequestHeaders version for the response headers
+func (s *TransformService) TransformResponseHeaders(cfg config.StartCommandConfig, match config.TransformMatchContext, header http.Header, diffMaxSize int) (http.Header, []string, error) {
+	return s.transformHeaders(header, headersTransformOptions{
+		remove: cfg.RemoveResponseHeaders, rename: cfg.RenameResponseHeaders, set: cfg.SetResponseHeaders,
+		add: cfg.AdditionalResponseHeaders, sed: cfg.TransformResponseHeadersSED, jq: cfg.TransformResponseHeadersJQ,
+	}, match, diffMaxSize)
+}
+
+// TransformRequestQuery applies the request query transformations in the following order: removing, renaming, setting,
+// adding and then JQ. It returns the transformed copy of the query and the changes log message for every JQ stage,
+// in the error case returns the original query
+func (s *TransformService) TransformRequestQuery(cfg config.StartCommandConfig, match config.TransformMatchContext, query url.Values, diffMaxSize int) (url.Values, []string, error) {
+	modified, changes := url.Values{}, []string{}
+	for name, values := range query {
+		modified[name] = append([]string(nil), values...)
+	}
+
+	for _, name := range cfg.RemoveRequestQueryParams.Value {
+		modified.Del(name)
+	}
+	for _, rename := range cfg.RenameRequestQueryParams.Value {
+		oldName, newName, err := util.ParseQueryParam(rename)
+		if err != nil {
+			return query, changes, cfg.RenameRequestQueryParams.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.ParseQueryParam), err))
+		}
+		if values, ok := modified[oldName]; ok {
+			modified.Del(oldName)
+			modified[newName] = values
+		}
+	}
+	for _, kv := range cfg.SetRequestQueryParams.Value {
+		name, value, err := util.ParseQueryParam(kv)
+		if err != nil {
+			return query, changes, cfg.SetRequestQueryParams.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.ParseQueryParam), err))
+		}
+		modified.Set(name, value)
+	}
+	for _, kv := range cfg.AdditionalRequestQueryParams.Value {
+		name, value, err := util.ParseQueryParam(kv)
+		if err != nil {
+			return query, changes, cfg.AdditionalRequestQueryParams.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.ParseQueryParam), err))
+		}
+		modified.Add(name, value)
+	}
+
+	// Transform query with JQ, the query is passed as an object of the value arrays
+	opt := cfg.TransformRequestQueryJQ
+	for _, value := range opt.Value {
+		stage, isMatched, err := getTransformStage(value, match)
+		if err != nil {
+			return query, changes, opt.WrapError(err)
+		}
+		if !isMatched {
+			changes = append(changes, getSkippedLogMessage(stage.Expr, opt))
+			continue
+		}
+		source, err := json.Marshal(modified)
+		if err != nil {
+			return query, changes, opt.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(json.Marshal), err))
+		}
+		transformed, _, err := util.JQ(stage.Expr, source, util.JQOptions{Output: util.JQOutputJSON})
+		if err != nil {
+			return query, changes, opt.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.JQ), err))
+		}
+		values, err := getValuesFromJSON(transformed, "query parameter")
+		if err != nil {
+			return query, changes, opt.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(getValuesFromJSON), err))
+		}
+		modified = values
+		result, _ := json.Marshal(modified)
+		changes = append(changes, getChangesLogMessage(source, result, stage.Expr, opt, diffMaxSize, util.JSONDiff))
+	}
+
+	return modified, changes, nil
+}
+
+type headersTransformOptions struct {
+	remove, rename, set, add, sed, jq config.Option[[]string]
+}
+
+func (s *TransformService) transformHeaders(header http.Header, opts headersTransformOptions, match config.TransformMatchContext, diffMaxSize int) (http.Header, []string, error) {
+	modified, changes := header.Clone(), []string{}
+	if modified == nil {
+		modified = http.Header{}
+	}
+
+	for _, name := range opts.remove.Value {
+		modified.Del(name)
+	}
+	for _, rename := range opts.rename.Value {
+		oldName, newName, err := util.ParseHeader(rename)
+		if err != nil {
+			return header, changes, opts.rename.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.ParseHeader), err))
+		}
+		if values := modified.Values(oldName); len(values) > 0 {
+			modified.Del(oldName)
+			modified[http.CanonicalHeaderKey(newName)] = values
+		}
+	}
+	for _, kv := range opts.set.Value {
+		name, value, err := util.ParseHeader(kv)
+		if err != nil {
+			return header, changes, opts.set.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.ParseHeader), err))
+		}
+		modified.Set(name, value)
+	}
+	for _, kv := range opts.add.Value {
+		name, value, err := util.ParseHeader(kv)
+		if err != nil {
+			return header, changes, opts.add.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.ParseHeader), err))
+		}
+		modified.Add(name, value)
+	}
+
+	// Transform header values with SED
+	for _, value := range opts.sed.Value {
+		stage, isMatched, err := getTransformStage(value, match)
+		if err != nil {
+			return header, changes, opts.sed.WrapError(err)
+		}
+		if !isMatched {
+			changes = append(changes, getSkippedLogMessage(stage.Expr, opts.sed))
+			continue
+		}
+		name, sedExpr, err := util.ParseHeader(stage.Expr)
+		if err != nil {
+			return header, changes, opts.sed.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.ParseHeader), err))
+		}
+		values := modified.Values(name)
+		modifiedValues := make([]string, 0, len(values))
+		for _, value := range values {
+			modifiedValue, source, err := util.SED(sedExpr, []byte(value))
+			if err != nil {
+				return header, changes, opts.sed.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.SED), err))
+			}
+			modifiedValues = append(modifiedValues, string(modifiedValue))
+			changes = append(changes, fmt.Sprintf("%s header: %s", name, getChangesLogMessage(source, modifiedValue, sedExpr, opts.sed, diffMaxSize, util.UnifiedDiff)))
+		}
+		if len(modifiedValues) > 0 {
+			modified[http.CanonicalHeaderKey(name)] = modifiedValues
+		}
+	}
+
+	// Transform headers with JQ, the headers are passed as an object of the value arrays
+	for _, value := range opts.jq.Value {
+		stage, isMatched, err := getTransformStage(value, match)
+		if err != nil {
+			return header, changes, opts.jq.WrapError(err)
+		}
+		if !isMatched {
+			changes = append(changes, getSkippedLogMessage(stage.Expr, opts.jq))
+			continue
+		}
+		jqExpr := stage.Expr
+		source, err := json.Marshal(modified)
+		if err != nil {
+			return header, changes, opts.jq.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(json.Marshal), err))
+		}
+		transformed, _, err := util.JQ(jqExpr, source, util.JQOptions{Output: util.JQOutputJSON})
+		if err != nil {
+			return header, changes, opts.jq.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.JQ), err))
+		}
+		if modified, err = getHeadersFromJSON(transformed); err != nil {
+			return header, changes, opts.jq.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(getHeadersFromJSON), err))
+		}
+		result, _ := json.Marshal(modified)
+		changes = append(changes, getChangesLogMessage(source, result, jqExpr, opts.jq, diffMaxSize, util.JSONDiff))
+	}
+
+	return modified, changes, nil
+}
+
+// getHeadersFromJSON converts the JQ result to the headers, the header values can be strings or arrays of strings
+func getHeadersFromJSON(data []byte) (http.Header, error) {
+	values, err := getValuesFromJSON(data, "header")
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	for name, values := range values {
+		for _, value := range values {
+			header.Add(name, value)
+		}
+	}
+	return header, nil
+}
+
+// getValuesFromJSON converts the JQ result to the multi-values map, the values can be strings or arrays of strings,
+// the kind is used in the error messages
+func getValuesFromJSON(data []byte, kind string) (map[string][]string, error) {
+	object := map[string]any{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("the result should be a single object: %w", err)
+	}
+	values := map[string][]string{}
+	for name, value := range object {
+		switch value := value.(type) {
+		case string:
+			values[name] = append(values[name], value)
+		case []any:
+			for _, item := range value {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s %s value should be a string or an array of strings", name, kind)
+				}
+				values[name] = append(values[name], s)
+			}
+		default:
+			return nil, fmt.Errorf("%s %s value should be a string or an array of strings", name, kind)
+		}
+	}
+	return values, nil
+}
+
+func (s *TransformService) transformBody(body []byte, sedOpt, jqOpt config.Option[[]string], jqOptions util.JQOptions, match config.TransformMatchContext, diffMaxSize int) ([]byte, []string, error) {
+	var source []byte
+	modified, changes := body, []string{}
+
+	// Transform body with SED
+	for _, value := range sedOpt.Value {
+		stage, isMatched, err := getTransformStage(value, match)
+		if err != nil {
+			return body, changes, sedOpt.WrapError(err)
+		}
+		if !isMatched {
+			changes = append(changes, getSkippedLogMessage(stage.Expr, sedOpt))
+			continue
+		}
+		sedExpr := stage.Expr
+		modified, source, err = util.SED(sedExpr, modified)
+		if err != nil {
+			return body, changes, sedOpt.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.SED), err))
+		}
+		changes = append(changes, getChangesLogMessage(source, modified, sedExpr, sedOpt, diffMaxSize, util.UnifiedDiff))
+	}
+
+	// Transform body with JQ
+	for _, value := range jqOpt.Value {
+		stage, isMatched, err := getTransformStage(value, match)
+		if err != nil {
+			return body, changes, jqOpt.WrapError(err)
+		}
+		if !isMatched {
+			changes = append(changes, getSkippedLogMessage(stage.Expr, jqOpt))
+			continue
+		}
+		jqExpr := stage.Expr
+		modified, source, err = util.JQ(jqExpr, modified, jqOptions)
+		if err != nil {
+			return body, changes, jqOpt.WrapError(fmt.Errorf("%s: %w", util.GetFuncName(util.JQ), err))
+		}
+		changes = append(changes, getChangesLogMessage(source, modified, jqExpr, jqOpt, diffMaxSize, util.JSONDiff))
+	}
+
+	return modified, changes, nil
+}
+
+// getTransformStage parses the pipeline stage and checks if the exchange matches its conditions
+func getTransformStage(value string, match config.TransformMatchContext) (*config.TransformStage, bool, error) {
+	stage, err := config.ParseTransformStage(value)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", util.GetFuncName(config.ParseTransformStage), err)
+	}
+	return stage, stage.Match(match), nil
+}
+
+func getSkippedLogMessage[T config.OptionValueType](expr string, o config.Option[T]) string {
+	return fmt.Sprintf("the '%s' %s expression has been skipped, the conditions aren't met", expr, o.Name)
+}
+
+// getChangesLogMessage adds the diff made by the diff func to the message if diffMaxSize isn't 0
+func getChangesLogMessage[T config.OptionValueType](source, modified []byte, expr string, o config.Option[T], diffMaxSize int, diff func(source, modified []byte) string) string {
+	if string(source) == string(modified) {
+		return fmt.Sprintf("the '%s' %s expression didn't change the data", expr, o.Name)
+	}
+	message := fmt.Sprintf("the '%s' %s expression changed the data. Length difference: %d",
+		expr, o.Name, int(math.Abs(float64(len(source)-len(modified)))))
+	if diffMaxSize > 0 {
+		changes := diff(source, modified)
+		if len(changes) > diffMaxSize {
+			changes = changes[:diffMaxSize] + "\n... (truncated)"
+		}
+		message += "\n" + changes
+	}
+	return message
+}
@@ -0,0 +1,281 @@
+//go:build unit
+// +build unit
+
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransformService_TransformResponseBody(t *testing.T) {
+	tests := []struct {
+		msg         string
+		sed         []string
+		jq          []string
+		body        string
+		want        string
+		wantChanges int
+		wantErr     string
+	}{
+		{"Without pipelines", nil, nil, `{"a": 1}`, `{"a": 1}`, 0, ""},
+		{"SED then JQ", []string{"s|old|new|"}, []string{".a"}, `{"a": "old"}`, `new`, 2, ""},
+		{"Unchanged stage", []string{"s|absent|new|"}, nil, `text`, `text`, 1, ""},
+		{"Invalid JQ returns the original body", []string{"s|old|new|"}, []string{".["}, `{"a": "old"}`, `{"a": "old"}`, 1, "transform-response-body-jq"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			cfg := config.GetStartCommandConfig()
+			cfg.TransformResponseBodySED.Value = tt.sed
+			cfg.TransformResponseBodyJQ.Value = tt.jq
+
+			got, changes, err := NewTransformService().TransformResponseBody(*cfg, config.TransformMatchContext{}, []byte(tt.body), 1024)
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, string(got))
+			assert.Len(t, changes, tt.wantChanges)
+		})
+	}
+}
+
+func TestTransformService_TransformResponseHeaders(t *testing.T) {
+	tests := []struct {
+		msg     string
+		set     func(cfg *config.StartCommandConfig)
+		want    http.Header
+		wantErr string
+	}{
+		{"Without transformations", func(cfg *config.StartCommandConfig) {}, http.Header{"Location": {"https://example.com/path"}, "Set-Cookie": {"a=1", "b=2"}}, ""},
+		{
+			"Remove, rename, set and add",
+			func(cfg *config.StartCommandConfig) {
+				cfg.RemoveResponseHeaders.Value = []string{"set-cookie"}
+				cfg.RenameResponseHeaders.Value = []string{"Location: X-Original-Location"}
+				cfg.SetResponseHeaders.Value = []string{"Location: /new", "Set-Cookie: c=3"}
+				cfg.AdditionalResponseHeaders.Value = []string{"Set-Cookie: d=4"}
+			},
+			http.Header{"X-Original-Location": {"https://example.com/path"}, "Location": {"/new"}, "Set-Cookie": {"c=3", "d=4"}},
+			"",
+		},
+		{
+			"SED for every value",
+			func(cfg *config.StartCommandConfig) {
+				cfg.TransformResponseHeadersSED.Value = []string{"Location: s|https://example.com|http://localhost|", "Set-Cookie: s|=|=changed-|", "Absent: s|a|b|"}
+			},
+			http.Header{"Location": {"http://localhost/path"}, "Set-Cookie": {"a=changed-1", "b=changed-2"}},
+			"",
+		},
+		{
+			"JQ",
+			func(cfg *config.StartCommandConfig) {
+				cfg.TransformResponseHeadersJQ.Value = []string{`del(.["Set-Cookie"]) | .["x-count"] = "1"`, `.Location |= map(ascii_upcase)`}
+			},
+			http.Header{"Location": {"HTTPS://EXAMPLE.COM/PATH"}, "X-Count": {"1"}},
+			"",
+		},
+		{
+			"JQ result isn't an object",
+			func(cfg *config.StartCommandConfig) { cfg.TransformResponseHeadersJQ.Value = []string{`.Location`} },
+			http.Header{"Location": {"https://example.com/path"}, "Set-Cookie": {"a=1", "b=2"}},
+			"transform-response-headers-jq",
+		},
+		{
+			"JQ result value isn't a string",
+			func(cfg *config.StartCommandConfig) { cfg.TransformResponseHeadersJQ.Value = []string{`.Location = 1`} },
+			http.Header{"Location": {"https://example.com/path"}, "Set-Cookie": {"a=1", "b=2"}},
+			"Location header value should be a string",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			cfg := config.GetStartCommandConfig()
+			tt.set(cfg)
+			header := http.Header{"Location": {"https://example.com/path"}, "Set-Cookie": {"a=1", "b=2"}}
+
+			got, _, err := NewTransformService().TransformResponseHeaders(*cfg, config.TransformMatchContext{}, header, 0)
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, http.Header{"Location": {"https://example.com/path"}, "Set-Cookie": {"a=1", "b=2"}}, header, "the source headers shouldn't be changed")
+		})
+	}
+}
+
+func TestTransformService_TransformResponseBody_Conditions(t *testing.T) {
+	cfg := config.GetStartCommandConfig()
+	cfg.TransformResponseBodySED.Value = []string{`{"expr": "s|old|new|", "method": "^POST$", "path": "^/api/"}`}
+	cfg.TransformResponseBodyJQ.Value = []string{`{"expr": ".data", "content-type": "application/*json", "status": "2xx"}`}
+	json := config.TransformMatchContext{Method: http.MethodPost, Path: "/api/users", ContentType: "application/json; charset=utf-8", StatusCode: http.StatusOK}
+
+	tests := []struct {
+		msg   string
+		match func(match config.TransformMatchContext) config.TransformMatchContext
+		body  string
+		want  string
+	}{
+		{"All conditions are met", func(m config.TransformMatchContext) config.TransformMatchContext { return m }, `{"data": "old"}`, `new`},
+		{"Not matched method", func(m config.TransformMatchContext) config.TransformMatchContext { m.Method = http.MethodGet; return m }, `{"data": "old"}`, `old`},
+		{"Not matched path", func(m config.TransformMatchContext) config.TransformMatchContext { m.Path = "/health"; return m }, `{"data": "old"}`, `old`},
+		{"HTML error page", func(m config.TransformMatchContext) config.TransformMatchContext {
+			m.ContentType, m.StatusCode = "text/html", http.StatusInternalServerError
+			return m
+		}, `<html>old</html>`, `<html>new</html>`},
+		{"Not modified", func(m config.TransformMatchContext) config.TransformMatchContext {
+			m.StatusCode = http.StatusNotModified
+			return m
+		}, ``, ``},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			got, changes, err := NewTransformService().TransformResponseBody(*cfg, tt.match(json), []byte(tt.body), 0)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+			assert.Len(t, changes, 2)
+		})
+	}
+}
+
+func TestTransformService_RewriteResponse(t *testing.T) {
+	tests := []struct {
+		msg        string
+		rules      []string
+		wantStatus int
+		wantHeader http.Header
+		wantBody   string
+		wantErr    bool
+	}{
+		{"Without rules", nil, http.StatusNotFound, http.Header{"Content-Type": {"text/plain"}}, "not found", false},
+		{
+			"Not matched rule",
+			[]string{`{"status": "5xx", "set-status": 200}`},
+			http.StatusNotFound, http.Header{"Content-Type": {"text/plain"}}, "not found", false,
+		},
+		{
+			"Stub body",
+			[]string{`{"status": "404", "path": "^/api/", "set-status": 200, "set-body": "[]", "set-headers": {"Content-Type": "application/json"}}`},
+			http.StatusOK, http.Header{"Content-Type": {"application/json"}}, "[]", false,
+		},
+		{
+			"Rules are matched against the rewritten response",
+			[]string{`{"status": "404", "set-status": 200}`, `{"status": "200", "set-status": 503}`, `{"status": "404", "set-body": "skipped"}`},
+			http.StatusServiceUnavailable, http.Header{"Content-Type": {"text/plain"}}, "not found", false,
+		},
+		{
+			"Body condition",
+			[]string{`{"body": "^found$", "set-status": 200}`, `{"body": "not", "set-headers": {"X-Not-Found": "1"}}`},
+			http.StatusNotFound, http.Header{"Content-Type": {"text/plain"}, "X-Not-Found": {"1"}}, "not found", false,
+		},
+		{
+			"Invalid rule",
+			[]string{`{"status": "404", "set-status": 200}`, `{"status": "404"}`},
+			http.StatusNotFound, http.Header{"Content-Type": {"text/plain"}}, "not found", true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			cfg := config.GetStartCommandConfig()
+			cfg.RewriteResponseRules.Value = tt.rules
+			match := config.TransformMatchContext{Method: http.MethodGet, Path: "/api/users"}
+
+			statusCode, header, body, _, err := NewTransformService().RewriteResponse(*cfg, match, http.StatusNotFound, http.Header{"Content-Type": {"text/plain"}}, []byte("not found"))
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), cfg.RewriteResponseRules.GetFlagName())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantStatus, statusCode)
+			assert.Equal(t, tt.wantHeader, header)
+			assert.Equal(t, tt.wantBody, string(body))
+		})
+	}
+}
+
+func TestTransformService_TransformRequestQuery(t *testing.T) {
+	tests := []struct {
+		msg     string
+		set     func(cfg *config.StartCommandConfig)
+		want    url.Values
+		wantErr string
+	}{
+		{"Without transformations", func(cfg *config.StartCommandConfig) {}, url.Values{"q": {"a b"}, "tag": {"x", "y"}, "utm_source": {"mail"}}, ""},
+		{
+			"Remove, rename, set and add",
+			func(cfg *config.StartCommandConfig) {
+				cfg.RemoveRequestQueryParams.Value = []string{"utm_source"}
+				cfg.RenameRequestQueryParams.Value = []string{"q=query", "absent=other"}
+				cfg.SetRequestQueryParams.Value = []string{"tag=z", "key=a&b=c"}
+				cfg.AdditionalRequestQueryParams.Value = []string{"tag=w", "empty="}
+			},
+			url.Values{"query": {"a b"}, "tag": {"z", "w"}, "key": {"a&b=c"}, "empty": {""}},
+			"",
+		},
+		{
+			"JQ",
+			func(cfg *config.StartCommandConfig) {
+				cfg.TransformRequestQueryJQ.Value = []string{`with_entries(select(.key | startswith("utm_") | not))`, `.page = "1"`}
+			},
+			url.Values{"q": {"a b"}, "tag": {"x", "y"}, "page": {"1"}},
+			"",
+		},
+		{
+			"JQ skipped by the conditions",
+			func(cfg *config.StartCommandConfig) {
+				cfg.TransformRequestQueryJQ.Value = []string{`{"expr": "{}", "method": "POST"}`}
+			},
+			url.Values{"q": {"a b"}, "tag": {"x", "y"}, "utm_source": {"mail"}},
+			"",
+		},
+		{
+			"JQ result value isn't a string",
+			func(cfg *config.StartCommandConfig) { cfg.TransformRequestQueryJQ.Value = []string{`.page = 1`} },
+			url.Values{"q": {"a b"}, "tag": {"x", "y"}, "utm_source": {"mail"}},
+			"page query parameter value should be a string",
+		},
+		{
+			"Invalid format",
+			func(cfg *config.StartCommandConfig) { cfg.AdditionalRequestQueryParams.Value = []string{"=value"} },
+			url.Values{"q": {"a b"}, "tag": {"x", "y"}, "utm_source": {"mail"}},
+			"additional-request-query-params",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			cfg := config.GetStartCommandConfig()
+			tt.set(cfg)
+			query := url.Values{"q": {"a b"}, "tag": {"x", "y"}, "utm_source": {"mail"}}
+
+			got, _, err := NewTransformService().TransformRequestQuery(*cfg, config.TransformMatchContext{Method: http.MethodGet}, query, 0)
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, url.Values{"q": {"a b"}, "tag": {"x", "y"}, "utm_source": {"mail"}}, query, "the source query shouldn't be modified")
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package service
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/mgerasimchuk/protty/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+// hashRingReplicas is the number of the consistent hashing ring points per upstream weight unit,
+// the more points the more even distribution of the keys
+const hashRingReplicas = 100
+
+// upstream is the pool member with its balancing and health state
+type upstream struct {
+	config.Upstream
+	inFlight atomic.Int64 // in-flight requests for the least-connections balancing
+
+	mu           sync.Mutex
+	isUnhealthy  bool // by the last active health check
+	failures     int  // consecutive failures for the passive ejection
+	ejectedUntil time.Time
+}
+
+func (u *upstream) isAvailable(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return !u.isUnhealthy && !now.Before(u.ejectedUntil)
+}
+
+// getURL returns the upstream URL of the request path, the path of the upstream URI is the prefix
+func (u *upstream) getURL(path string) string {
+	return u.URL.Scheme + "://" + u.URL.Host + strings.TrimSuffix(u.URL.Path, "/") + path
+}
+
+type hashRingPoint struct {
+	hash     uint32
+	upstream *upstream
+}
+
+// upstreamPool balances the requests between the upstreams skipping the unhealthy and the ejected ones (all of them
+// are used if none is available, so the client gets the upstream error instead of the protty one). It's created once
+// per config state for the upstream options (see getUpstreamPoolKey), so it's shared by the reverse proxies of the routes
+// and the request header variants, and its health checks are run until stop is called. It's safe for concurrent use
+type upstreamPool struct {
+	key           string // by getUpstreamPoolKey
+	refs          int    // number of the cached reverse proxies using the pool, it's guarded by the proxyState lock
+	cfg           config.StartCommandConfig
+	upstreams     []*upstream
+	ring          []hashRingPoint // sorted by hash
+	hashKeySource string
+	hashKeyName   string
+	next          atomic.Uint64 // round-robin counter
+	isUnavailable atomic.Bool   // all upstreams are unavailable, it's used to log only the availability changes
+
+	mu             sync.Mutex
+	currentWeights map[*upstream]int // smooth weighted round-robin state
+
+	healthClient *http.Client
+	stopOnce     sync.Once
+	stopChan     chan struct{}
+	logger       *logrus.Logger
+}
+
+func newUpstreamPool(cfg config.StartCommandConfig, logger *logrus.Logger) (*upstreamPool, error) {
+	p := &upstreamPool{cfg: cfg, currentWeights: map[*upstream]int{}, stopChan: make(chan struct{}), logger: logger}
+	for _, value := range cfg.UpstreamURIs.Value {
+		u, err := config.ParseUpstream(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", util.GetFuncName(config.ParseUpstream), err)
+		}
+		p.upstreams = append(p.upstreams, &upstream{Upstream: u})
+	}
+	if len(p.upstreams) == 0 {
+		return nil, fmt.Errorf("there are no upstream URIs")
+	}
+
+	if cfg.LoadBalancing.Value == config.LoadBalancingConsistentHash {
+		var err error
+		if p.hashKeySource, p.hashKeyName, err = config.ParseHashKey(cfg.LoadBalancingHashKey.Value); err != nil {
+			return nil, fmt.Errorf("%s: %w", util.GetFuncName(config.ParseHashKey), err)
+		}
+		for _, u := range p.upstreams {
+			for i := 0; i < u.Weight*hashRingReplicas; i++ {
+				p.ring = append(p.ring, hashRingPoint{hash: getRingHash(fmt.Sprintf("%s#%d", u.URL, i)), upstream: u})
+			}
+		}
+		sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	}
+
+	if cfg.HealthCheckPath.Value != "" {
+		p.healthClient = &http.Client{
+			Timeout:       time.Duration(cfg.HealthCheckTimeout.Value) * time.Millisecond,
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+		go p.runHealthChecks(time.Duration(cfg.HealthCheckInterval.Value) * time.Second)
+	}
+	return p, nil
+}
+
+// getUpstreamPoolKey returns the key of the pool in the config state, it's the upstream URIs with the options of the pool
+func getUpstreamPoolKey(cfg config.StartCommandConfig) string {
+	return fmt.Sprintf("%q %s %s %s %d %d %d %d", cfg.UpstreamURIs.Value, cfg.LoadBalancing.Value, cfg.LoadBalancingHashKey.Value,
+		cfg.HealthCheckPath.Value, cfg.HealthCheckInterval.Value, cfg.HealthCheckTimeout.Value, cfg.EjectionFailures.Value, cfg.EjectionDuration.Value)
+}
+
+// stop stops the health checks, it's called when the last reverse proxy using the pool is evicted (e.g. on the state
+// replacement)
+func (p *upstreamPool) stop() {
+	if p == nil {
+		return
+	}
+	p.stopOnce.Do(func() { close(p.stopChan) })
+}
+
+// pick returns the upstream for the request
+func (p *upstreamPool) pick(req *http.Request) *upstream {
+	available := p.getAvailable()
+	switch p.cfg.LoadBalancing.Value {
+	case config.LoadBalancingWeighted:
+		return p.pickWeighted(available)
+	case config.LoadBalancingLeastConnections:
+		return p.pickLeastConnections(available)
+	case config.LoadBalancingConsistentHash:
+		if key := p.getHashKey(req); key != "" {
+			return p.pickByHash(key, available)
+		}
+	}
+	return available[(p.next.Add(1)-1)%uint64(len(available))]
+}
+
+func (p *upstreamPool) getAvailable() []*upstream {
+	now := time.Now()
+	available := make([]*upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		if u.isAvailable(now) {
+			available = append(available, u)
+		}
+	}
+	if len(available) == 0 {
+		if !p.isUnavailable.Swap(true) {
+			p.logger.Warnf("All upstreams are unhealthy or ejected, the requests are balanced between all of them")
+		}
+		return p.upstreams
+	}
+	if p.isUnavailable.Swap(false) {
+		p.logger.Infof("Upstreams are available again")
+	}
+	return available
+}
+
+// pickWeighted is the smooth weighted round-robin (like nginx one), it spreads the requests of the heavy upstream
+// between the others instead of sending them in a row
+func (p *upstreamPool) pickWeighted(available []*upstream) *upstream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var picked *upstream
+	totalWeight := 0
+	for _, u := range available {
+		p.currentWeights[u] += u.Weight
+		totalWeight += u.Weight
+		if picked == nil || p.currentWeights[u] > p.currentWeights[picked] {
+			picked = u
+		}
+	}
+	p.currentWeights[picked] -= totalWeight
+	return picked
+}
+
+// pickLeastConnections returns the upstream with the least in-flight requests per weight,
+// the search starts from the next round-robin one to spread the requests between the equal upstreams
+func (p *upstreamPool) pickLeastConnections(available []*upstream) *upstream {
+	start := p.next.Add(1) - 1
+	var picked *upstream
+	var pickedInFlight int64
+	for i := range available {
+		u := available[(start+uint64(i))%uint64(len(available))]
+		inFlight := u.inFlight.Load()
+		if picked == nil || inFlight*int64(picked.Weight) < pickedInFlight*int64(u.Weight) {
+			picked, pickedInFlight = u, inFlight
+		}
+	}
+	return picked
+}
+
+// pickByHash returns the first available upstream clockwise from the key on the hash ring,
+// so the keys of the unavailable upstream are spread between the others and get back after its recovery
+func (p *upstreamPool) pickByHash(key string, available []*upstream) *upstream {
+	isAvailable := make(map[*upstream]bool, len(available))
+	for _, u := range available {
+		isAvailable[u] = true
+	}
+	hash := getRingHash(key)
+	i := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= hash })
+	for j := 0; j < len(p.ring); j++ {
+		if point := p.ring[(i+j)%len(p.ring)]; isAvailable[point.upstream] {
+			return point.upstream
+		}
+	}
+	return available[0]
+}
+
+// getRingHash returns the position on the hash ring, md5 spreads the short similar keys (e.g. user IDs) better than crc32
+func getRingHash(key string) uint32 {
+	sum := md5.Sum([]byte(key))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+func (p *upstreamPool) getHashKey(req *http.Request) string {
+	if p.hashKeySource == config.HashKeySourceCookie {
+		if cookie, err := req.Cookie(p.hashKeyName); err == nil {
+			return cookie.Value
+		}
+		return ""
+	}
+	return req.Header.Get(p.hashKeyName)
+}
+
+// reportResult counts the consecutive failures of the upstream and ejects it for the ejection duration
+// if there are too many of them, the success resets the counter
+func (p *upstreamPool) reportResult(u *upstream, isFailed bool) {
+	if p.cfg.EjectionFailures.Value == 0 {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !isFailed {
+		u.failures = 0
+		return
+	}
+	u.failures++
+	if u.failures >= p.cfg.EjectionFailures.Value {
+		u.failures = 0
+		u.ejectedUntil = time.Now().Add(time.Duration(p.cfg.EjectionDuration.Value) * time.Second)
+		p.logger.Warnf("Upstream %s has been ejected for %ds after %d consecutive failures", u.URL, p.cfg.EjectionDuration.Value, p.cfg.EjectionFailures.Value)
+	}
+}
+
+func (p *upstreamPool) runHealthChecks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.checkHealth()
+		select {
+		case <-ticker.C:
+		case <-p.stopChan:
+			return
+		}
+	}
+}
+
+// checkHealth checks all the upstreams concurrently
+func (p *upstreamPool) checkHealth() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-p.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for _, u := range p.upstreams {
+		wg.Add(1)
+		go func(u *upstream) {
+			defer wg.Done()
+			err := p.getHealthCheckError(ctx, u)
+			if ctx.Err() != nil { // stopped
+				return
+			}
+			u.mu.Lock()
+			wasUnhealthy := u.isUnhealthy
+			u.isUnhealthy = err != nil
+			u.mu.Unlock()
+			if err != nil && !wasUnhealthy {
+				p.logger.Warnf("Upstream %s has become unhealthy: %s", u.URL, err)
+			} else if err == nil && wasUnhealthy {
+				p.logger.Infof("Upstream %s has become healthy", u.URL)
+			}
+		}(u)
+	}
+	wg.Wait()
+}
+
+func (p *upstreamPool) getHealthCheckError(ctx context.Context, u *upstream) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.getURL(p.cfg.HealthCheckPath.Value), nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", util.GetFuncName(http.NewRequestWithContext), err)
+	}
+	resp, err := p.healthClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", util.GetFuncName(p.healthClient.Do), err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// upstreamPoolTransport sends the request to the upstream picked by the pool, it's inside the throttling transport,
+// so the in-flight requests of the least-connections balancing don't include the throttled ones
+type upstreamPoolTransport struct {
+	next http.RoundTripper
+	pool *upstreamPool
+}
+
+func (t *upstreamPoolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := t.pool.pick(req)
+	upstreamReq := req.Clone(req.Context())
+	upstreamReq.URL.Scheme, upstreamReq.URL.Host, upstreamReq.Host = u.URL.Scheme, u.URL.Host, u.URL.Host
+	upstreamReq.URL.Path, upstreamReq.URL.RawPath = joinURLPath(u.URL, req.URL)
+
+	u.inFlight.Add(1)
+	resp, err := t.next.RoundTrip(upstreamReq)
+	if err != nil {
+		u.inFlight.Add(-1)
+		// the client cancellation isn't the upstream failure
+		t.pool.reportResult(u, req.Context().Err() == nil)
+		return nil, err
+	}
+	t.pool.reportResult(u, resp.StatusCode >= 500)
+	resp.Body = &inFlightBody{ReadCloser: resp.Body, done: func() { u.inFlight.Add(-1) }}
+	return resp, nil
+}
+
+// joinURLPath prefixes the path of the URL with the path of the prefix URL, the escaped path is kept as is (e.g. %2F
+// isn't turned into /)
+func joinURLPath(prefix, u *url.URL) (path, rawPath string) {
+	return strings.TrimSuffix(prefix.Path, "/") + u.Path, strings.TrimSuffix(prefix.EscapedPath(), "/") + u.EscapedPath()
+}
+
+// inFlightBody calls done once the body is closed, cos the request is in-flight until the response body is read
+type inFlightBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *inFlightBody) Close() error {
+	b.once.Do(b.done)
+	return b.ReadCloser.Close()
+}
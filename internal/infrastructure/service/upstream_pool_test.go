@@ -0,0 +1,272 @@
+//go:build unit
+// +build unit
+
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mgerasimchuk/protty/internal/infrastructure/config"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestUpstreamPool(t *testing.T, set func(cfg *config.StartCommandConfig)) *upstreamPool {
+	cfg := config.GetStartCommandConfig()
+	cfg.UpstreamURIs.Value = []string{"http://a", "http://b", "http://c"}
+	set(cfg)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	pool, err := newUpstreamPool(*cfg, logger)
+	assert.NoError(t, err)
+	t.Cleanup(pool.stop)
+	return pool
+}
+
+func pickHosts(pool *upstreamPool, req *http.Request, count int) []string {
+	hosts := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		hosts = append(hosts, pool.pick(req).URL.Host)
+	}
+	return hosts
+}
+
+func TestUpstreamPool_pick(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	t.Run("Round-robin", func(t *testing.T) {
+		pool := newTestUpstreamPool(t, func(cfg *config.StartCommandConfig) {})
+		assert.Equal(t, []string{"a", "b", "c", "a"}, pickHosts(pool, req, 4))
+	})
+
+	t.Run("Smooth weighted round-robin", func(t *testing.T) {
+		pool := newTestUpstreamPool(t, func(cfg *config.StartCommandConfig) {
+			cfg.UpstreamURIs.Value, cfg.LoadBalancing.Value = []string{"http://a 5", "http://b", "http://c"}, config.LoadBalancingWeighted
+		})
+		assert.Equal(t, []string{"a", "a", "b", "a", "c", "a", "a", "a"}, pickHosts(pool, req, 8))
+	})
+
+	t.Run("Least connections per weight", func(t *testing.T) {
+		pool := newTestUpstreamPool(t, func(cfg *config.StartCommandConfig) {
+			cfg.UpstreamURIs.Value, cfg.LoadBalancing.Value = []string{"http://a", "http://b 4", "http://c"}, config.LoadBalancingLeastConnections
+		})
+		pool.upstreams[0].inFlight.Store(2)
+		pool.upstreams[1].inFlight.Store(4)
+		pool.upstreams[2].inFlight.Store(2)
+		assert.Equal(t, []string{"b", "b", "b"}, pickHosts(pool, req, 3))
+		pool.upstreams[1].inFlight.Store(12)
+		assert.Equal(t, []string{"a", "c"}, pickHosts(pool, req, 2)[0:2], "equal upstreams are picked in turn")
+	})
+
+	t.Run("Consistent hash by header", func(t *testing.T) {
+		pool := newTestUpstreamPool(t, func(cfg *config.StartCommandConfig) {
+			cfg.LoadBalancing.Value, cfg.LoadBalancingHashKey.Value = config.LoadBalancingConsistentHash, "header:X-User-Id"
+		})
+		picked := map[string]string{}
+		for _, userID := range []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"} {
+			userReq := httptest.NewRequest(http.MethodGet, "/", nil)
+			userReq.Header.Set("X-User-Id", userID)
+			hosts := pickHosts(pool, userReq, 3)
+			assert.Equal(t, []string{hosts[0], hosts[0], hosts[0]}, hosts)
+			picked[userID] = hosts[0]
+		}
+		assert.Len(t, map[string]bool{picked["1"]: true, picked["2"]: true, picked["3"]: true, picked["4"]: true, picked["5"]: true,
+			picked["6"]: true, picked["7"]: true, picked["8"]: true, picked["9"]: true, picked["10"]: true}, 3, "keys are spread between all upstreams")
+
+		// the keys of the ejected upstream are moved to the others and get back after its recovery
+		userReq := httptest.NewRequest(http.MethodGet, "/", nil)
+		userReq.Header.Set("X-User-Id", "1")
+		for _, u := range pool.upstreams {
+			if u.URL.Host == picked["1"] {
+				u.ejectedUntil = time.Now().Add(time.Hour)
+				assert.NotEqual(t, picked["1"], pool.pick(userReq).URL.Host)
+				u.ejectedUntil = time.Time{}
+			}
+		}
+		assert.Equal(t, picked["1"], pool.pick(userReq).URL.Host)
+		assert.Equal(t, []string{"a", "b", "c"}, pickHosts(pool, req, 3), "requests without the key are balanced in round-robin manner")
+	})
+
+	t.Run("Consistent hash by cookie", func(t *testing.T) {
+		pool := newTestUpstreamPool(t, func(cfg *config.StartCommandConfig) {
+			cfg.LoadBalancing.Value, cfg.LoadBalancingHashKey.Value = config.LoadBalancingConsistentHash, "cookie:session"
+		})
+		sessionReq := httptest.NewRequest(http.MethodGet, "/", nil)
+		sessionReq.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+		hosts := pickHosts(pool, sessionReq, 3)
+		assert.Equal(t, []string{hosts[0], hosts[0], hosts[0]}, hosts)
+	})
+
+	t.Run("Unavailable upstreams are skipped", func(t *testing.T) {
+		pool := newTestUpstreamPool(t, func(cfg *config.StartCommandConfig) {})
+		pool.upstreams[1].isUnhealthy = true
+		pool.upstreams[2].ejectedUntil = time.Now().Add(time.Hour)
+		assert.Equal(t, []string{"a", "a"}, pickHosts(pool, req, 2))
+
+		pool.upstreams[0].isUnhealthy = true
+		assert.ElementsMatch(t, []string{"a", "b", "c"}, pickHosts(pool, req, 3), "all upstreams are used if none is available")
+	})
+
+	t.Run("Unavailability is logged only when it changes", func(t *testing.T) {
+		pool := newTestUpstreamPool(t, func(cfg *config.StartCommandConfig) {})
+		hook := logrustest.NewLocal(pool.logger)
+		for _, u := range pool.upstreams {
+			u.isUnhealthy = true
+		}
+		pickHosts(pool, req, 3)
+		pool.upstreams[0].isUnhealthy = false
+		pickHosts(pool, req, 3)
+		pool.upstreams[0].isUnhealthy = true
+		pickHosts(pool, req, 3)
+
+		levels := make([]logrus.Level, 0, len(hook.AllEntries()))
+		for _, entry := range hook.AllEntries() {
+			levels = append(levels, entry.Level)
+		}
+		assert.Equal(t, []logrus.Level{logrus.WarnLevel, logrus.InfoLevel, logrus.WarnLevel}, levels)
+	})
+}
+
+func TestUpstreamPool_reportResult(t *testing.T) {
+	pool := newTestUpstreamPool(t, func(cfg *config.StartCommandConfig) {
+		cfg.EjectionFailures.Value, cfg.EjectionDuration.Value = 2, 60
+	})
+	u := pool.upstreams[0]
+
+	pool.reportResult(u, true)
+	pool.reportResult(u, false) // the success resets the consecutive failures
+	pool.reportResult(u, true)
+	assert.True(t, u.isAvailable(time.Now()))
+
+	pool.reportResult(u, true)
+	assert.False(t, u.isAvailable(time.Now()))
+	assert.True(t, u.isAvailable(time.Now().Add(61*time.Second)))
+
+	disabledPool := newTestUpstreamPool(t, func(cfg *config.StartCommandConfig) { cfg.EjectionFailures.Value = 0 })
+	for i := 0; i < 10; i++ {
+		disabledPool.reportResult(disabledPool.upstreams[0], true)
+	}
+	assert.True(t, disabledPool.upstreams[0].isAvailable(time.Now()))
+}
+
+func TestUpstreamPool_checkHealth(t *testing.T) {
+	isHealthy := atomic.Bool{}
+	isHealthy.Store(true)
+	checks := atomic.Int64{}
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/health", r.URL.Path)
+		checks.Add(1)
+		if !isHealthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer flaky.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	pool := newTestUpstreamPool(t, func(cfg *config.StartCommandConfig) {
+		cfg.UpstreamURIs.Value, cfg.HealthCheckPath.Value = []string{flaky.URL + "/api/", down.URL}, "/health"
+		cfg.HealthCheckInterval.Value = 3600 // the checks are run manually after the first one
+	})
+	assert.Eventually(t, func() bool { return checks.Load() == 1 }, time.Second, 10*time.Millisecond, "checks are run on the start")
+	assert.Eventually(t, func() bool { return !pool.upstreams[1].isAvailable(time.Now()) }, time.Second, 10*time.Millisecond)
+	assert.True(t, pool.upstreams[0].isAvailable(time.Now()))
+
+	isHealthy.Store(false)
+	pool.checkHealth()
+	assert.False(t, pool.upstreams[0].isAvailable(time.Now()))
+
+	isHealthy.Store(true)
+	pool.checkHealth()
+	assert.True(t, pool.upstreams[0].isAvailable(time.Now()))
+}
+
+func TestReverseProxyService_handleRequestAndRedirect_UpstreamPool(t *testing.T) {
+	newUpstream := func(name string, statusCode int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(statusCode)
+			_, _ = w.Write([]byte(name + " " + r.Host + " " + r.URL.RequestURI()))
+		}))
+	}
+	first, second, failing := newUpstream("first", http.StatusOK), newUpstream("second", http.StatusOK), newUpstream("failing", http.StatusBadGateway)
+	defer first.Close()
+	defer second.Close()
+	defer failing.Close()
+
+	cfg := config.GetStartCommandConfig()
+	cfg.UpstreamURIs.Value = []string{first.URL + "/v1", second.URL, failing.URL}
+	cfg.EjectionFailures.Value = 1
+	s := newTestReverseProxyService(t, cfg, nil)
+
+	getBody := func(target string) string {
+		res := httptest.NewRecorder()
+		s.handleRequestAndRedirect(res, httptest.NewRequest(http.MethodGet, target, nil))
+		return res.Body.String()
+	}
+	assert.Equal(t, "first "+first.Listener.Addr().String()+" /v1/a%2Fb?q=1", getBody("/a%2Fb?q=1"), "the escaped path is kept")
+	assert.Equal(t, "second "+second.Listener.Addr().String()+" /path?q=1", getBody("/path?q=1"))
+	assert.Equal(t, "failing "+failing.Listener.Addr().String()+" /path?q=1", getBody("/path?q=1"))
+	for i := 0; i < 4; i++ {
+		assert.NotContains(t, getBody("/path?q=1"), "failing", "the failing upstream has been ejected")
+	}
+}
+
+func TestReverseProxyService_getReverseProxyByParams_UpstreamPoolLifecycle(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	cfg := config.GetStartCommandConfig()
+	markAsAddedToCLI(cfg)
+	cfg.ReverseProxyCacheSize.Value = 2
+	cfg.UpstreamURIs.Value, cfg.HealthCheckPath.Value = []string{upstream.URL}, "/health"
+	nextCfg := *cfg
+	s := newTestReverseProxyService(t, cfg, func() (*config.StartCommandConfig, error) {
+		cfg := nextCfg
+		return &cfg, nil
+	})
+	state := s.state.Load()
+	getPool := func(cfg config.StartCommandConfig) *upstreamPool {
+		s.getReverseProxyByParams(state, cfg)
+		cached, _ := state.reverseProxies.Get(cfg.GetStateHash())
+		return cached.pool
+	}
+
+	pool := getPool(*cfg)
+	variantCfg := *cfg
+	variantCfg.SetResponseHeaders.Value = []string{"X-Variant: 1"}
+	assert.Same(t, pool, getPool(variantCfg), "the reverse proxies of the same upstreams share the pool")
+	assert.Equal(t, 1, len(state.upstreamPools))
+
+	otherCfg := *cfg
+	otherCfg.LoadBalancing.Value = config.LoadBalancingLeastConnections
+	other := getPool(otherCfg)
+	assert.NotSame(t, pool, other)
+	assert.Equal(t, 2, len(state.upstreamPools))
+	assert.False(t, isClosed(pool.stopChan), "the pool is kept while the cached variant reverse proxy uses it")
+
+	otherVariantCfg := otherCfg
+	otherVariantCfg.SetResponseHeaders.Value = []string{"X-Variant: 1"}
+	assert.Same(t, other, getPool(otherVariantCfg))
+	assert.Equal(t, 1, len(state.upstreamPools))
+	assert.True(t, isClosed(pool.stopChan), "the pool health checks are stopped with the eviction of the last reverse proxy using it")
+	assert.False(t, isClosed(other.stopChan))
+
+	assert.NoError(t, s.Reload())
+	assert.True(t, isClosed(other.stopChan), "the pools of the replaced state are stopped on the reload")
+	assert.NotSame(t, other, getPool(otherCfg), "the pools are created again for the new state")
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
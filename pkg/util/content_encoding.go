@@ -0,0 +1,217 @@
+package util
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ContentEncodingGzip    = "gzip"
+	ContentEncodingDeflate = "deflate"
+	ContentEncodingBrotli  = "br"
+	ContentEncodingZstd    = "zstd"
+)
+
+// supportedContentEncodings is ordered by the preference for the equal client weights
+var supportedContentEncodings = []string{ContentEncodingBrotli, ContentEncodingZstd, ContentEncodingGzip, ContentEncodingDeflate}
+
+// EncodeAll method of the zstd encoder is safe for the concurrent use
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// ErrDecodedBodyTooLarge is returned by DecodeBody if the decoded body exceeds the max size (e.g. the decompression bomb)
+var ErrDecodedBodyTooLarge = errors.New("decoded body is too large")
+
+// IsSupportedContentEncoding checks if all the encodings of the Content-Encoding header value are supported
+func IsSupportedContentEncoding(contentEncoding string) bool {
+	for _, encoding := range parseContentEncoding(contentEncoding) {
+		if normalizeContentEncoding(encoding) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// DecodeBody decodes the body by the Content-Encoding header value (the encodings are applied in the listed order,
+// so they are decoded in the reverse one). It returns ErrDecodedBodyTooLarge if any decoded body exceeds maxSize,
+// 0 maxSize disables the limit
+func DecodeBody(contentEncoding string, body []byte, maxSize int) ([]byte, error) {
+	readAll := func(r io.Reader, err error) ([]byte, error) {
+		if err != nil {
+			return nil, err
+		}
+		return readAllLimited(r, maxSize)
+	}
+	encodings := parseContentEncoding(contentEncoding)
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		switch normalizeContentEncoding(encodings[i]) {
+		case ContentEncodingGzip:
+			body, err = readAll(gzip.NewReader(bytes.NewReader(body)))
+		case ContentEncodingDeflate:
+			// deflate is the zlib format by RFC 9110, but some servers send the raw deflate stream
+			decoded, zlibErr := readAll(zlib.NewReader(bytes.NewReader(body)))
+			if errors.Is(zlibErr, ErrDecodedBodyTooLarge) {
+				err = zlibErr
+			} else if zlibErr != nil {
+				decoded, err = readAll(flate.NewReader(bytes.NewReader(body)), nil)
+			}
+			body = decoded
+		case ContentEncodingBrotli:
+			body, err = readAll(brotli.NewReader(bytes.NewReader(body)), nil)
+		case ContentEncodingZstd:
+			var decoder *zstd.Decoder
+			if decoder, err = zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1)); err == nil {
+				body, err = readAll(decoder, nil)
+				decoder.Close()
+			}
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %q", encodings[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", encodings[i], err)
+		}
+	}
+	return body, nil
+}
+
+// EncodeBody encodes the body by the Content-Encoding header value
+func EncodeBody(contentEncoding string, body []byte) ([]byte, error) {
+	for _, encoding := range parseContentEncoding(contentEncoding) {
+		b := bytes.Buffer{}
+		var w io.WriteCloser
+		switch normalizeContentEncoding(encoding) {
+		case ContentEncodingGzip:
+			w = gzip.NewWriter(&b)
+		case ContentEncodingDeflate:
+			w = zlib.NewWriter(&b)
+		case ContentEncodingBrotli:
+			w = brotli.NewWriter(&b)
+		case ContentEncodingZstd:
+			body = zstdEncoder.EncodeAll(body, nil)
+			continue
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+		}
+		if _, err := w.Write(body); err != nil {
+			return nil, fmt.Errorf("encoding %s: %w", encoding, err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("encoding %s: %w", encoding, err)
+		}
+		body = b.Bytes()
+	}
+	return body, nil
+}
+
+// SelectContentEncoding returns the supported encoding with the highest weight in the Accept-Encoding header value,
+// the preferred one wins for the equal weights if it's acceptable. Empty result means the identity encoding
+func SelectContentEncoding(acceptEncoding string, preferred string) string {
+	weights := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		encoding := strings.ToLower(strings.TrimSpace(params[0]))
+		if encoding == "" {
+			continue
+		}
+		weight := 1.0
+		for _, param := range params[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					weight = q
+				}
+			}
+		}
+		if encoding == "*" {
+			wildcard = weight
+			continue
+		}
+		if encoding = normalizeContentEncoding(encoding); encoding != "" {
+			weights[encoding] = weight
+		}
+	}
+
+	candidates := []string{}
+	for _, encoding := range supportedContentEncodings {
+		if _, ok := weights[encoding]; !ok && wildcard >= 0 {
+			weights[encoding] = wildcard
+		}
+		if weights[encoding] > 0 {
+			candidates = append(candidates, encoding)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	preferred = normalizeContentEncoding(preferred)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if weights[candidates[i]] != weights[candidates[j]] {
+			return weights[candidates[i]] > weights[candidates[j]]
+		}
+		return candidates[i] == preferred && candidates[j] != preferred
+	})
+	return candidates[0]
+}
+
+// FilterAcceptEncoding keeps only the supported encodings (with their weights) in the Accept-Encoding header value
+func FilterAcceptEncoding(acceptEncoding string) string {
+	parts := []string{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		encoding := strings.TrimSpace(strings.Split(part, ";")[0])
+		if normalizeContentEncoding(encoding) != "" {
+			parts = append(parts, strings.TrimSpace(part))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func parseContentEncoding(contentEncoding string) []string {
+	encodings := []string{}
+	for _, encoding := range strings.Split(contentEncoding, ",") {
+		if encoding = strings.ToLower(strings.TrimSpace(encoding)); encoding != "" && encoding != "identity" {
+			encodings = append(encodings, encoding)
+		}
+	}
+	return encodings
+}
+
+// normalizeContentEncoding returns the supported encoding name or empty string for the unsupported one
+func normalizeContentEncoding(encoding string) string {
+	switch strings.ToLower(encoding) {
+	case "gzip", "x-gzip":
+		return ContentEncodingGzip
+	case "deflate":
+		return ContentEncodingDeflate
+	case "br":
+		return ContentEncodingBrotli
+	case "zstd":
+		return ContentEncodingZstd
+	}
+	return ""
+}
+
+// readAllLimited reads at most maxSize bytes, it returns ErrDecodedBodyTooLarge if there are more of them
+func readAllLimited(r io.Reader, maxSize int) ([]byte, error) {
+	if maxSize <= 0 {
+		return io.ReadAll(r)
+	}
+	body, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSize {
+		return nil, fmt.Errorf("%w, the limit is %d bytes", ErrDecodedBodyTooLarge, maxSize)
+	}
+	return body, nil
+}
@@ -0,0 +1,80 @@
+//go:build unit
+// +build unit
+
+package util
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeBody_DecodeBody(t *testing.T) {
+	body := []byte(`{"message": "compressible compressible compressible compressible"}`)
+	for _, contentEncoding := range []string{"gzip", "x-gzip", "deflate", "br", "zstd", "gzip, br", "identity", ""} {
+		contentEncoding := contentEncoding
+		t.Run(contentEncoding, func(t *testing.T) {
+			t.Parallel()
+			encoded, err := EncodeBody(contentEncoding, body)
+			assert.NoError(t, err)
+			if contentEncoding != "" && contentEncoding != "identity" {
+				assert.NotEqual(t, body, encoded)
+			}
+			decoded, err := DecodeBody(contentEncoding, encoded, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, string(body), string(decoded))
+		})
+	}
+}
+
+func TestDecodeBody_Error(t *testing.T) {
+	for _, contentEncoding := range []string{"gzip", "deflate", "br", "zstd", "compress"} {
+		_, err := DecodeBody(contentEncoding, []byte("not encoded body"), 0)
+		assert.Error(t, err, contentEncoding)
+	}
+	_, err := EncodeBody("compress", []byte("body"))
+	assert.Error(t, err)
+	assert.False(t, IsSupportedContentEncoding("gzip, compress"))
+	assert.True(t, IsSupportedContentEncoding("gzip, br"))
+}
+
+func TestDecodeBody_MaxSize(t *testing.T) {
+	body := bytes.Repeat([]byte("a"), 1<<20)
+	for _, contentEncoding := range []string{"gzip", "deflate", "br", "zstd"} {
+		encoded, err := EncodeBody(contentEncoding, body)
+		assert.NoError(t, err)
+		_, err = DecodeBody(contentEncoding, encoded, len(body)-1)
+		assert.ErrorIs(t, err, ErrDecodedBodyTooLarge, contentEncoding)
+		decoded, err := DecodeBody(contentEncoding, encoded, 0)
+		assert.NoError(t, err, contentEncoding)
+		assert.Equal(t, len(body), len(decoded), contentEncoding)
+	}
+}
+
+func TestSelectContentEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		preferred      string
+		want           string
+	}{
+		{"", "gzip", ""},
+		{"identity", "gzip", ""},
+		{"gzip", "br", "gzip"},
+		{"gzip, br", "", "br"},
+		{"gzip, br", "gzip", "gzip"},
+		{"gzip;q=0.5, deflate;q=0.8", "gzip", "deflate"},
+		{"br;q=0, gzip", "br", "gzip"},
+		{"*", "zstd", "zstd"},
+		{"*;q=0.1, gzip", "zstd", "gzip"},
+		{"compress, GZIP", "", "gzip"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, SelectContentEncoding(tt.acceptEncoding, tt.preferred), "%q preferred %q", tt.acceptEncoding, tt.preferred)
+	}
+}
+
+func TestFilterAcceptEncoding(t *testing.T) {
+	assert.Equal(t, "gzip;q=0.8, br", FilterAcceptEncoding("gzip;q=0.8, compress, br, identity"))
+	assert.Equal(t, "", FilterAcceptEncoding("compress"))
+}
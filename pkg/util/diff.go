@@ -0,0 +1,213 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// diffMaxLCSCells limits the memory of the LCS table, bigger changed blocks are shown as fully replaced
+const diffMaxLCSCells = 1_000_000
+
+type diffLine struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+// UnifiedDiff returns the line based diff of the inputs in the unified format (with 3 context lines), empty for equal inputs
+func UnifiedDiff(source, modified []byte) string {
+	if string(source) == string(modified) {
+		return ""
+	}
+	lines := getDiffLines(strings.Split(string(source), "\n"), strings.Split(string(modified), "\n"))
+
+	const contextLines = 3
+	b := strings.Builder{}
+	b.WriteString("--- source\n+++ modified\n")
+	for start := 0; start < len(lines); {
+		// find the next changed line and the end of the hunk (changes separated by no more than 2*contextLines equal lines)
+		firstChange := start
+		for firstChange < len(lines) && lines[firstChange].kind == ' ' {
+			firstChange++
+		}
+		if firstChange == len(lines) {
+			break
+		}
+		end, equalCount := firstChange, 0
+		for i := firstChange; i < len(lines) && equalCount <= 2*contextLines; i++ {
+			if lines[i].kind == ' ' {
+				equalCount++
+			} else {
+				end, equalCount = i+1, 0
+			}
+		}
+		hunkStart, hunkEnd := Max(firstChange-contextLines, start), Min(end+contextLines, len(lines))
+
+		sourceLine, modifiedLine := 1, 1
+		for _, l := range lines[:hunkStart] {
+			sourceLine, modifiedLine = sourceLine+b2i(l.kind != '+'), modifiedLine+b2i(l.kind != '-')
+		}
+		sourceCount, modifiedCount := 0, 0
+		for _, l := range lines[hunkStart:hunkEnd] {
+			sourceCount, modifiedCount = sourceCount+b2i(l.kind != '+'), modifiedCount+b2i(l.kind != '-')
+		}
+		b.WriteString(fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", sourceLine, sourceCount, modifiedLine, modifiedCount))
+		for _, l := range lines[hunkStart:hunkEnd] {
+			b.WriteByte(l.kind)
+			b.WriteString(l.text)
+			b.WriteByte('\n')
+		}
+		start = hunkEnd
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// JSONDiff returns the structural diff of the JSON inputs (one line per changed path), empty for equal inputs.
+// If any of the inputs isn't a valid JSON, it returns the UnifiedDiff
+func JSONDiff(source, modified []byte) string {
+	var sourceObject, modifiedObject any
+	if json.Unmarshal(source, &sourceObject) != nil || json.Unmarshal(modified, &modifiedObject) != nil {
+		return UnifiedDiff(source, modified)
+	}
+	changes := []string{}
+	collectJSONChanges(".", sourceObject, modifiedObject, &changes)
+	return strings.Join(changes, "\n")
+}
+
+func collectJSONChanges(path string, source, modified any, changes *[]string) {
+	if reflect.DeepEqual(source, modified) {
+		return
+	}
+	childPath := func(key string) string {
+		if path == "." {
+			return "." + key
+		}
+		return path + "." + key
+	}
+
+	sourceMap, isSourceMap := source.(map[string]any)
+	modifiedMap, isModifiedMap := modified.(map[string]any)
+	if isSourceMap && isModifiedMap {
+		keys := []string{}
+		for k := range sourceMap {
+			keys = append(keys, k)
+		}
+		for k := range modifiedMap {
+			if _, ok := sourceMap[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sourceValue, inSource := sourceMap[k]
+			modifiedValue, inModified := modifiedMap[k]
+			switch {
+			case !inSource:
+				*changes = append(*changes, fmt.Sprintf("+ %s: %s", childPath(k), toJSON(modifiedValue)))
+			case !inModified:
+				*changes = append(*changes, fmt.Sprintf("- %s: %s", childPath(k), toJSON(sourceValue)))
+			default:
+				collectJSONChanges(childPath(k), sourceValue, modifiedValue, changes)
+			}
+		}
+		return
+	}
+
+	sourceSlice, isSourceSlice := source.([]any)
+	modifiedSlice, isModifiedSlice := modified.([]any)
+	if isSourceSlice && isModifiedSlice {
+		for i := 0; i < Max(len(sourceSlice), len(modifiedSlice)); i++ {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			if path == "." {
+				itemPath = fmt.Sprintf(".[%d]", i)
+			}
+			switch {
+			case i >= len(sourceSlice):
+				*changes = append(*changes, fmt.Sprintf("+ %s: %s", itemPath, toJSON(modifiedSlice[i])))
+			case i >= len(modifiedSlice):
+				*changes = append(*changes, fmt.Sprintf("- %s: %s", itemPath, toJSON(sourceSlice[i])))
+			default:
+				collectJSONChanges(itemPath, sourceSlice[i], modifiedSlice[i], changes)
+			}
+		}
+		return
+	}
+
+	*changes = append(*changes, fmt.Sprintf("~ %s: %s -> %s", path, toJSON(source), toJSON(modified)))
+}
+
+// getDiffLines returns the edit script based on the longest common subsequence of the lines
+func getDiffLines(source, modified []string) []diffLine {
+	prefix := 0
+	for prefix < len(source) && prefix < len(modified) && source[prefix] == modified[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(source)-prefix && suffix < len(modified)-prefix && source[len(source)-1-suffix] == modified[len(modified)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]diffLine, 0, len(source)+len(modified))
+	for _, l := range source[:prefix] {
+		lines = append(lines, diffLine{' ', l})
+	}
+	a, b := source[prefix:len(source)-suffix], modified[prefix:len(modified)-suffix]
+	if (len(a)+1)*(len(b)+1) > diffMaxLCSCells {
+		for _, l := range a {
+			lines = append(lines, diffLine{'-', l})
+		}
+		for _, l := range b {
+			lines = append(lines, diffLine{'+', l})
+		}
+	} else {
+		// lcs[i][j] is the LCS length of a[i:] and b[j:]
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = Max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(a) || j < len(b) {
+			switch {
+			case i < len(a) && j < len(b) && a[i] == b[j]:
+				lines = append(lines, diffLine{' ', a[i]})
+				i, j = i+1, j+1
+			case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+				lines = append(lines, diffLine{'-', a[i]})
+				i++
+			default:
+				lines = append(lines, diffLine{'+', b[j]})
+				j++
+			}
+		}
+	}
+	for _, l := range source[len(source)-suffix:] {
+		lines = append(lines, diffLine{' ', l})
+	}
+	return lines
+}
+
+func toJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+//go:build unit
+// +build unit
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		msg      string
+		source   string
+		modified string
+		want     string
+	}{
+		{"Equal inputs", "same", "same", ""},
+		{
+			"Single line",
+			"Hello sed",
+			"Hello world",
+			"--- source\n+++ modified\n@@ -1,1 +1,1 @@\n-Hello sed\n+Hello world",
+		},
+		{
+			"Context lines and separate hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12",
+			"1\nchanged\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13",
+			"--- source\n+++ modified\n@@ -1,5 +1,5 @@\n 1\n-2\n+changed\n 3\n 4\n 5\n@@ -10,3 +10,4 @@\n 10\n 11\n 12\n+13",
+		},
+		{
+			"Insertion and deletion",
+			"a\nb\nc",
+			"a\nc\nd",
+			"--- source\n+++ modified\n@@ -1,3 +1,3 @@\n a\n-b\n c\n+d",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, UnifiedDiff([]byte(tt.source), []byte(tt.modified)))
+		})
+	}
+}
+
+func TestJSONDiff(t *testing.T) {
+	tests := []struct {
+		msg      string
+		source   string
+		modified string
+		want     string
+	}{
+		{"Equal objects with different formatting", `{"a": 1, "b": [1, 2]}`, `{"b":[1,2],"a":1}`, ""},
+		{
+			"Changed, added and removed fields",
+			`{"a": 1, "b": {"c": "old", "d": true}, "list": [1, 2]}`,
+			`{"a": 2, "b": {"c": "new"}, "e": null, "list": [1]}`,
+			"~ .a: 1 -> 2\n~ .b.c: \"old\" -> \"new\"\n- .b.d: true\n+ .e: null\n- .list[1]: 2",
+		},
+		{"Root array", `[1, 2]`, `[1, 3]`, "~ .[1]: 2 -> 3"},
+		{"Root type change", `{"message": "text"}`, `"text"`, "~ .: {\"message\":\"text\"} -> \"text\""},
+		{"Not JSON", `{"message": "text"}`, `text`, "--- source\n+++ modified\n@@ -1,1 +1,1 @@\n-{\"message\": \"text\"}\n+text"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, JSONDiff([]byte(tt.source), []byte(tt.modified)))
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HARVersion is the version of the HAR format, see http://www.softwareishard.com/blog/har-12-spec/
+const HARVersion = "1.2"
+
+// harEncodingBase64 is the encoding of the binary bodies
+const harEncodingBase64 = "base64"
+
+// HAR is the HTTP Archive
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is the request/response pair. The custom _upstreamRequest and _upstreamResponse fields keep
+// the exchange with the upstream, if it differs from the client one (e.g. it's transformed by the proxy)
+type HAREntry struct {
+	StartedDateTime  time.Time    `json:"startedDateTime"`
+	Time             float64      `json:"time"`
+	Request          HARRequest   `json:"request"`
+	Response         HARResponse  `json:"response"`
+	Cache            struct{}     `json:"cache"`
+	Timings          HARTimings   `json:"timings"`
+	UpstreamRequest  *HARRequest  `json:"_upstreamRequest,omitempty"`
+	UpstreamResponse *HARResponse `json:"_upstreamResponse,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARPostData is the request body decoded by the Content-Encoding header, the custom _encoding field is base64 for
+// the binary bodies. The custom _contentEncoded field is true if the body is kept as is (e.g. its encoding isn't
+// supported)
+type HARPostData struct {
+	MimeType         string `json:"mimeType"`
+	Text             string `json:"text"`
+	Encoding         string `json:"_encoding,omitempty"`
+	IsContentEncoded bool   `json:"_contentEncoded,omitempty"`
+}
+
+// HARContent is the response body decoded by the Content-Encoding header, the encoding is base64 for the binary
+// bodies. The custom _contentEncoded field is true if the body is kept as is (e.g. its encoding isn't supported)
+type HARContent struct {
+	Size             int    `json:"size"`
+	MimeType         string `json:"mimeType"`
+	Text             string `json:"text"`
+	Encoding         string `json:"encoding,omitempty"`
+	IsContentEncoded bool   `json:"_contentEncoded,omitempty"`
+}
+
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// NewHARRequest creates the HAR request, the body is decoded by the Content-Encoding header (it's kept as is if it
+// can't be decoded or the decoded one exceeds decodedBodyMaxSize), bodySize is the size of the sent body
+func NewHARRequest(method string, u *url.URL, header http.Header, body []byte, decodedBodyMaxSize int) HARRequest {
+	r := HARRequest{
+		Method: method, URL: u.String(), HTTPVersion: "HTTP/1.1", Cookies: []HARNameValue{},
+		Headers: getHARNameValues(header), QueryString: getHARNameValues(u.Query()), HeadersSize: -1, BodySize: len(body),
+	}
+	if len(body) > 0 {
+		decodedBody, isContentEncoded := decodeHARBody(header, body, decodedBodyMaxSize)
+		text, encoding := encodeHARText(decodedBody)
+		r.PostData = &HARPostData{MimeType: header.Get("Content-Type"), Text: text, Encoding: encoding, IsContentEncoded: isContentEncoded}
+	}
+	return r
+}
+
+// NewHARResponse creates the HAR response, the body is decoded by the Content-Encoding header (it's kept as is if it
+// can't be decoded or the decoded one exceeds decodedBodyMaxSize), bodySize is the size of the sent body
+func NewHARResponse(statusCode int, header http.Header, body []byte, decodedBodyMaxSize int) HARResponse {
+	decodedBody, isContentEncoded := decodeHARBody(header, body, decodedBodyMaxSize)
+	text, encoding := encodeHARText(decodedBody)
+	return HARResponse{
+		Status: statusCode, StatusText: http.StatusText(statusCode), HTTPVersion: "HTTP/1.1", Cookies: []HARNameValue{},
+		Headers: getHARNameValues(header), RedirectURL: header.Get("Location"), HeadersSize: -1, BodySize: len(body),
+		Content: HARContent{
+			Size: len(decodedBody), MimeType: header.Get("Content-Type"), Text: text, Encoding: encoding,
+			IsContentEncoded: isContentEncoded,
+		},
+	}
+}
+
+// GetBody returns the request body decoded by the Content-Encoding header
+func (r HARRequest) GetBody() ([]byte, error) {
+	if r.PostData == nil {
+		return nil, nil
+	}
+	return decodeHARText(r.PostData.Text, r.PostData.Encoding)
+}
+
+// GetHeader returns the request headers
+func (r HARRequest) GetHeader() http.Header {
+	return getHTTPHeader(r.Headers)
+}
+
+// GetBody returns the response body decoded by the Content-Encoding header
+func (r HARResponse) GetBody() ([]byte, error) {
+	return decodeHARText(r.Content.Text, r.Content.Encoding)
+}
+
+// GetEncodedBody returns the response body encoded by the Content-Encoding header, as it's sent
+func (r HARResponse) GetEncodedBody() ([]byte, error) {
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	return encodeHARBody(r.GetHeader(), body, r.Content.IsContentEncoded)
+}
+
+// GetHeader returns the response headers
+func (r HARResponse) GetHeader() http.Header {
+	return getHTTPHeader(r.Headers)
+}
+
+// ReadHAR reads the HAR file
+func ReadHAR(path string) (*HAR, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", GetFuncName(os.ReadFile), err)
+	}
+	har := &HAR{}
+	if err = json.Unmarshal(content, har); err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", path, GetFuncName(json.Unmarshal), err)
+	}
+	return har, nil
+}
+
+// HARWriter writes the HAR file entry by entry, the file is a valid HAR after every written entry.
+// It's safe for concurrent use
+type HARWriter struct {
+	mu           sync.Mutex
+	file         *os.File
+	entriesEnd   int64 // offset of the entries array end
+	entriesCount int
+}
+
+// harEnding closes the entries array and the log and HAR objects
+const harEnding = "\n]}}\n"
+
+// NewHARWriter creates (or truncates) the HAR file
+func NewHARWriter(path string, creator HARCreator) (*HARWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", GetFuncName(os.Create), err)
+	}
+	creatorJSON, err := json.Marshal(creator)
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("%s: %w", GetFuncName(json.Marshal), err)
+	}
+	beginning := fmt.Sprintf(`{"log": {"version": %q, "creator": %s, "entries": [`, HARVersion, creatorJSON)
+	if _, err = file.WriteString(beginning + harEnding); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("%s: %w", GetFuncName(file.WriteString), err)
+	}
+	return &HARWriter{file: file, entriesEnd: int64(len(beginning))}, nil
+}
+
+// Write appends the entry to the file
+func (w *HARWriter) Write(entry HAREntry) error {
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("%s: %w", GetFuncName(json.Marshal), err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	separator := "\n"
+	if w.entriesCount > 0 {
+		separator = ",\n"
+	}
+	entryPart := separator + string(entryJSON)
+	if _, err = w.file.WriteAt([]byte(entryPart+harEnding), w.entriesEnd); err != nil {
+		return fmt.Errorf("%s: %w", GetFuncName(w.file.WriteAt), err)
+	}
+	w.entriesEnd += int64(len(entryPart))
+	w.entriesCount++
+	return nil
+}
+
+// Close closes the file
+func (w *HARWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// getHARNameValues returns the sorted by name values
+func getHARNameValues(values map[string][]string) []HARNameValue {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	nameValues := []HARNameValue{}
+	for _, name := range names {
+		for _, value := range values[name] {
+			nameValues = append(nameValues, HARNameValue{Name: name, Value: value})
+		}
+	}
+	return nameValues
+}
+
+func getHTTPHeader(nameValues []HARNameValue) http.Header {
+	header := http.Header{}
+	for _, nameValue := range nameValues {
+		header.Add(nameValue.Name, nameValue.Value)
+	}
+	return header
+}
+
+// decodeHARBody decodes the body by the Content-Encoding header, it returns the body as is and true if it can't be
+// decoded
+func decodeHARBody(header http.Header, body []byte, maxSize int) ([]byte, bool) {
+	if len(body) == 0 {
+		return body, false
+	}
+	decodedBody, err := DecodeBody(header.Get("Content-Encoding"), body, maxSize)
+	if err != nil {
+		return body, true
+	}
+	return decodedBody, false
+}
+
+// encodeHARBody encodes the body by the Content-Encoding header if it isn't encoded yet
+func encodeHARBody(header http.Header, body []byte, isContentEncoded bool) ([]byte, error) {
+	if isContentEncoded || len(body) == 0 {
+		return body, nil
+	}
+	encodedBody, err := EncodeBody(header.Get("Content-Encoding"), body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", GetFuncName(EncodeBody), err)
+	}
+	return encodedBody, nil
+}
+
+func encodeHARText(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), harEncodingBase64
+}
+
+func decodeHARText(text, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(text), nil
+	case harEncodingBase64:
+		body, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", GetFuncName(base64.StdEncoding.DecodeString), err)
+		}
+		return body, nil
+	}
+	return nil, fmt.Errorf("unsupported body encoding %q", encoding)
+}
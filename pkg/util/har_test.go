@@ -0,0 +1,94 @@
+//go:build unit
+// +build unit
+
+package util
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHARWriter_Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.har")
+	w, err := NewHARWriter(path, HARCreator{Name: "protty", Version: "test"})
+	assert.NoError(t, err)
+
+	har, err := ReadHAR(path)
+	assert.NoError(t, err, "the file without entries should be valid")
+	assert.Equal(t, HARVersion, har.Log.Version)
+	assert.Empty(t, har.Log.Entries)
+
+	u, _ := url.Parse("http://localhost/path?b=2&a=1")
+	binary := []byte{0x1f, 0x8b, 0xff, 0x00}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, w.Write(HAREntry{
+			StartedDateTime: time.Date(2020, 1, 1, 0, 0, i, 0, time.UTC),
+			Request:         NewHARRequest(http.MethodPost, u, http.Header{"Content-Type": {"application/json"}}, []byte(`{"a": 1}`), 0),
+			Response:        NewHARResponse(http.StatusOK, http.Header{"Content-Encoding": {"gzip"}}, binary, 0),
+		}))
+		har, err = ReadHAR(path)
+		assert.NoError(t, err, "the file should be valid after every entry")
+		assert.Len(t, har.Log.Entries, i+1)
+	}
+	assert.NoError(t, w.Close())
+
+	entry := har.Log.Entries[2]
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 2, 0, time.UTC), entry.StartedDateTime)
+	assert.Equal(t, []HARNameValue{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}}, entry.Request.QueryString)
+	requestBody, err := entry.Request.GetBody()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a": 1}`, string(requestBody))
+	assert.Equal(t, "application/json", entry.Request.GetHeader().Get("Content-Type"))
+	assert.Equal(t, "base64", entry.Response.Content.Encoding)
+	assert.True(t, entry.Response.Content.IsContentEncoded, "the body can't be decoded, so it's kept as is")
+	responseBody, err := entry.Response.GetEncodedBody()
+	assert.NoError(t, err)
+	assert.Equal(t, binary, responseBody)
+	assert.Nil(t, entry.UpstreamRequest)
+}
+
+func TestNewHARResponse_ContentEncoding(t *testing.T) {
+	body := []byte(strings.Repeat("compressible ", 10))
+	encodedBody, err := EncodeBody("gzip", body)
+	assert.NoError(t, err)
+
+	for _, maxSize := range []int{0, len(body)} {
+		r := NewHARResponse(http.StatusOK, http.Header{"Content-Encoding": {"gzip"}}, encodedBody, maxSize)
+		assert.Equal(t, string(body), r.Content.Text)
+		assert.Empty(t, r.Content.Encoding)
+		assert.False(t, r.Content.IsContentEncoded)
+		assert.Equal(t, len(body), r.Content.Size)
+		assert.Equal(t, len(encodedBody), r.BodySize)
+		replayedBody, err := r.GetEncodedBody()
+		assert.NoError(t, err)
+		decodedBody, err := DecodeBody("gzip", replayedBody, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, body, decodedBody)
+	}
+
+	r := NewHARResponse(http.StatusOK, http.Header{"Content-Encoding": {"gzip"}}, encodedBody, len(body)-1)
+	assert.True(t, r.Content.IsContentEncoded, "the decoded body exceeds the max size, so it's kept as is")
+	assert.Equal(t, len(encodedBody), r.Content.Size)
+	replayedBody, err := r.GetEncodedBody()
+	assert.NoError(t, err)
+	assert.Equal(t, encodedBody, replayedBody)
+
+	// the HAR files of the browsers keep the decoded bodies with the original Content-Encoding header
+	r = HARResponse{Headers: []HARNameValue{{Name: "Content-Encoding", Value: "br"}}, Content: HARContent{Text: string(body)}}
+	replayedBody, err = r.GetEncodedBody()
+	assert.NoError(t, err)
+	decodedBody, err := DecodeBody("br", replayedBody, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, body, decodedBody)
+}
+
+func TestReadHAR_Error(t *testing.T) {
+	_, err := ReadHAR(filepath.Join(t.TempDir(), "absent.har"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,15 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseHeader splits the header in format `Header: Value` to the name and the value
+func ParseHeader(header string) (string, string, error) {
+	kv := strings.SplitN(header, ": ", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return "", "", fmt.Errorf("%q isn't in format Header: Value", header)
+	}
+	return kv[0], kv[1], nil
+}
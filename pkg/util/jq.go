@@ -1,32 +1,98 @@
 package util
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/itchyny/gojq"
-	"reflect"
 )
 
+const (
+	// JQOutputRaw prints the string results without quotes like `jq -r`, other results are JSON encoded
+	JQOutputRaw = "raw"
+	// JQOutputJSON JSON encodes all the results
+	JQOutputJSON = "json"
+
+	// JQResultsNDJSON emits the results as newline-delimited JSON (one result per line, the same as jq does)
+	JQResultsNDJSON = "ndjson"
+	// JQResultsArray emits the results as a JSON array
+	JQResultsArray = "array"
+)
+
+// JQOptions defines the output of the JQ results
+type JQOptions struct {
+	Output  string // JQOutputRaw (default) or JQOutputJSON
+	Results string // JQResultsNDJSON (default) or JQResultsArray
+}
+
+// Validate checks the options values
+func (o JQOptions) Validate() error {
+	if o.Output != "" && o.Output != JQOutputRaw && o.Output != JQOutputJSON {
+		return fmt.Errorf("unknown JQ output %q, should be %s or %s", o.Output, JQOutputRaw, JQOutputJSON)
+	}
+	if o.Results != "" && o.Results != JQResultsNDJSON && o.Results != JQResultsArray {
+		return fmt.Errorf("unknown JQ results %q, should be %s or %s", o.Results, JQResultsNDJSON, JQResultsArray)
+	}
+	return nil
+}
+
+var jqPrograms = NewLRU[string, *JQProgram](compiledProgramsCacheSize, nil)
+
+// JQProgram is the compiled JQ expression, it's safe for the concurrent use
+type JQProgram struct {
+	expr string
+	code *gojq.Code
+}
+
+// CompileJQ compiles the jq expression, the compiled programs are cached by the expression
+func CompileJQ(jqExpr string) (*JQProgram, error) {
+	if p, ok := jqPrograms.Get(jqExpr); ok {
+		return p, nil
+	}
+	query, err := gojq.Parse(jqExpr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", GetFuncName(gojq.Parse), err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", GetFuncName(gojq.Compile), err)
+	}
+	p := &JQProgram{expr: jqExpr, code: code}
+	jqPrograms.Add(jqExpr, p)
+	return p, nil
+}
+
 // JQ transform the input by jq expression
 // in the error case returns the original input
-func JQ(jqExpr string, input []byte) ([]byte, []byte, error) {
+func JQ(jqExpr string, input []byte, opts JQOptions) ([]byte, []byte, error) {
 	if len(input) == 0 || len(jqExpr) == 0 {
 		return input, input, nil
 	}
-
-	query, err := gojq.Parse(jqExpr)
+	p, err := CompileJQ(jqExpr)
 	if err != nil {
-		return input, input, fmt.Errorf("%s: %w", GetFuncName(gojq.Parse), err)
+		return input, input, err
+	}
+	return p.Run(input, opts)
+}
+
+// Run transform the input by the compiled jq expression
+// in the error case returns the original input
+func (p *JQProgram) Run(input []byte, opts JQOptions) ([]byte, []byte, error) {
+	if len(input) == 0 || len(p.expr) == 0 {
+		return input, input, nil
+	}
+	if err := opts.Validate(); err != nil {
+		return input, input, err
 	}
 
 	var inputObject any
-	err = json.Unmarshal(input, &inputObject)
+	err := json.Unmarshal(input, &inputObject)
 	if err != nil {
 		return input, input, fmt.Errorf("%s: %w", GetFuncName(json.Unmarshal), err)
 	}
 
-	iter := query.Run(inputObject)
-	var transformed any
+	iter := p.code.Run(inputObject)
+	results := []any{}
 	for {
 		v, ok := iter.Next()
 		if !ok {
@@ -35,17 +101,28 @@ func JQ(jqExpr string, input []byte) ([]byte, []byte, error) {
 		if err, ok = v.(error); ok {
 			return input, input, fmt.Errorf("%s: %w", GetFuncName(iter.Next), err)
 		}
-		transformed = v
+		results = append(results, v)
 	}
-	var transformedJSON []byte
-	if reflect.TypeOf(transformed).Kind() == reflect.Map {
-		transformedJSON, err = json.Marshal(transformed)
+
+	if opts.Results == JQResultsArray {
+		transformed, err := gojq.Marshal(results)
 		if err != nil {
-			return input, input, fmt.Errorf("%s: %w", GetFuncName(json.Marshal), err)
+			return input, input, fmt.Errorf("%s: %w", GetFuncName(gojq.Marshal), err)
 		}
-	} else {
-		transformedJSON = []byte(fmt.Sprintf("%v", transformed))
+		return transformed, input, nil
 	}
 
-	return transformedJSON, input, nil
+	encoded := make([][]byte, 0, len(results))
+	for _, v := range results {
+		if s, ok := v.(string); ok && opts.Output != JQOutputJSON {
+			encoded = append(encoded, []byte(s))
+			continue
+		}
+		b, err := gojq.Marshal(v)
+		if err != nil {
+			return input, input, fmt.Errorf("%s: %w", GetFuncName(gojq.Marshal), err)
+		}
+		encoded = append(encoded, b)
+	}
+	return bytes.Join(encoded, []byte("\n")), input, nil
 }
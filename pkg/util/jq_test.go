@@ -0,0 +1,77 @@
+//go:build unit
+// +build unit
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJQ_Success(t *testing.T) {
+	const input = `{"str": "text", "int": 1, "float": 1.5, "big": 100000000000000000000, "bool": true, "null": null, "list": [1, "a"], "obj": {"k": "v"}}`
+	raw, json := JQOptions{Output: JQOutputRaw}, JQOptions{Output: JQOutputJSON}
+	array := JQOptions{Results: JQResultsArray}
+	tests := []struct {
+		msg  string
+		expr string
+		opts JQOptions
+		want string
+	}{
+		{"Raw string", ".str", raw, `text`},
+		{"JSON string", ".str", json, `"text"`},
+		{"Default output is raw", ".str", JQOptions{}, `text`},
+		{"Int", ".int", raw, `1`},
+		{"Float", ".float", raw, `1.5`},
+		{"Big float", ".big * .big", raw, `1e+40`},
+		{"Big int", "9223372036854775807 * 10", json, `92233720368547758070`},
+		{"NaN is null", "nan", json, `null`},
+		{"Bool", ".bool", raw, `true`},
+		{"Null", ".null", raw, `null`},
+		{"Missing field is null", ".absent", json, `null`},
+		{"Array", ".list", raw, `[1,"a"]`},
+		{"Object", ".obj", raw, `{"k":"v"}`},
+		{"Nested strings are JSON encoded in the raw output", "[.str]", raw, `["text"]`},
+		{"Multiple results as NDJSON", ".list[]", raw, "1\na"},
+		{"Multiple results as JSON NDJSON", ".list[]", json, "1\n\"a\""},
+		{"Multiple results as array", ".list[]", array, `[1,"a"]`},
+		{"Single result as array", ".str", array, `["text"]`},
+		{"No results", "empty", raw, ``},
+		{"No results as array", "empty", array, `[]`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			actual, source, err := JQ(tt.expr, []byte(input), tt.opts)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(actual))
+			assert.Equal(t, input, string(source))
+		})
+	}
+}
+
+func TestJQ_Error(t *testing.T) {
+	tests := []struct {
+		msg   string
+		expr  string
+		input string
+		opts  JQOptions
+	}{
+		{"Invalid expression", ".[", `{}`, JQOptions{}},
+		{"Invalid input", ".", `not json`, JQOptions{}},
+		{"Runtime error", ".a.b", `{"a": 1}`, JQOptions{}},
+		{"Unknown output", ".", `{}`, JQOptions{Output: "yaml"}},
+		{"Unknown results", ".", `{}`, JQOptions{Results: "csv"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.msg, func(t *testing.T) {
+			t.Parallel()
+			actual, _, err := JQ(tt.expr, []byte(tt.input), tt.opts)
+			assert.Error(t, err)
+			assert.Equal(t, tt.input, string(actual))
+		})
+	}
+}
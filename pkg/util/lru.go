@@ -0,0 +1,144 @@
+package util
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRU is a concurrency-safe cache with the fixed size, the least recently used item is evicted on overflow
+type LRU[K comparable, V any] struct {
+	mu      sync.Mutex
+	size    int
+	items   map[K]*list.Element
+	order   *list.List
+	onEvict func(key K, value V)
+	stats   LRUStats
+}
+
+// LRUStats is the usage statistics of the cache
+type LRUStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+type lruItem[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// NewLRU creates the cache, onEvict (can be nil) is called for every evicted item
+func NewLRU[K comparable, V any](size int, onEvict func(key K, value V)) *LRU[K, V] {
+	if size < 1 {
+		size = 1
+	}
+	return &LRU[K, V]{size: size, items: map[K]*list.Element{}, order: list.New(), onEvict: onEvict}
+}
+
+// Get returns the value and marks it as recently used
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.stats.Hits++
+		c.order.MoveToFront(element)
+		return element.Value.(*lruItem[K, V]).value, true
+	}
+	c.stats.Misses++
+	var zero V
+	return zero, false
+}
+
+// Add adds or replaces the value and evicts the least recently used item if the cache is full
+func (c *LRU[K, V]) Add(key K, value V) {
+	c.mu.Lock()
+	if element, ok := c.items[key]; ok {
+		c.order.MoveToFront(element)
+		element.Value.(*lruItem[K, V]).value = value
+		c.mu.Unlock()
+		return
+	}
+	evicted := c.push(key, value)
+	c.mu.Unlock()
+
+	c.evict(evicted)
+}
+
+// GetOrAdd returns the cached value or atomically adds the one made by the create func,
+// so concurrent callers with the same key get the same value
+func (c *LRU[K, V]) GetOrAdd(key K, create func() V) (V, bool) {
+	c.mu.Lock()
+	if element, ok := c.items[key]; ok {
+		c.stats.Hits++
+		c.order.MoveToFront(element)
+		c.mu.Unlock()
+		return element.Value.(*lruItem[K, V]).value, true
+	}
+	c.stats.Misses++
+	value := create()
+	evicted := c.push(key, value)
+	c.mu.Unlock()
+
+	c.evict(evicted)
+	return value, false
+}
+
+// Len returns the number of items in the cache
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Purge removes all the items, onEvict is called for every one (the evictions stats isn't changed)
+func (c *LRU[K, V]) Purge() {
+	c.mu.Lock()
+	purged := make([]*lruItem[K, V], 0, c.order.Len())
+	for element := c.order.Back(); element != nil; element = element.Prev() {
+		purged = append(purged, element.Value.(*lruItem[K, V]))
+	}
+	c.items, c.order = map[K]*list.Element{}, list.New()
+	c.mu.Unlock()
+
+	for _, item := range purged {
+		c.evict(item)
+	}
+}
+
+// Range calls fn for every item from the most to the least recently used one without marking them as used,
+// fn is called under the lock, so it shouldn't use the cache
+func (c *LRU[K, V]) Range(fn func(key K, value V)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		item := element.Value.(*lruItem[K, V])
+		fn(item.key, item.value)
+	}
+}
+
+// push adds the new item and removes the least recently used one if the cache is full, should be called under the lock
+func (c *LRU[K, V]) push(key K, value V) *lruItem[K, V] {
+	c.items[key] = c.order.PushFront(&lruItem[K, V]{key: key, value: value})
+	if c.order.Len() <= c.size {
+		return nil
+	}
+	evicted := c.order.Remove(c.order.Back()).(*lruItem[K, V])
+	delete(c.items, evicted.key)
+	c.stats.Evictions++
+	return evicted
+}
+
+// evict calls the callback without the lock to allow it to use the cache
+func (c *LRU[K, V]) evict(evicted *lruItem[K, V]) {
+	if evicted != nil && c.onEvict != nil {
+		c.onEvict(evicted.key, evicted.value)
+	}
+}
+
+// Stats returns the usage statistics of the cache
+func (c *LRU[K, V]) Stats() LRUStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
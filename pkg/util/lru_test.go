@@ -0,0 +1,117 @@
+//go:build unit
+// +build unit
+
+package util
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU(t *testing.T) {
+	var evictedKeys []string
+	c := NewLRU[string, int](2, func(key string, value int) { evictedKeys = append(evictedKeys, key) })
+
+	c.Add("a", 1)
+	c.Add("b", 2)
+	_, _ = c.Get("a") // "b" becomes the least recently used
+	c.Add("c", 3)
+
+	_, ok := c.Get("b")
+	assert.False(t, ok)
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	v, ok = c.Get("c")
+	assert.True(t, ok)
+	assert.Equal(t, 3, v)
+
+	c.Add("c", 4) // replacing doesn't evict
+	v, _ = c.Get("c")
+	assert.Equal(t, 4, v)
+
+	assert.Equal(t, 2, c.Len())
+	assert.Equal(t, []string{"b"}, evictedKeys)
+	assert.Equal(t, LRUStats{Hits: 4, Misses: 1, Evictions: 1}, c.Stats())
+}
+
+func TestLRU_Purge(t *testing.T) {
+	var evictedKeys []string
+	c := NewLRU[string, int](3, func(key string, value int) { evictedKeys = append(evictedKeys, key) })
+	c.Add("a", 1)
+	c.Add("b", 2)
+
+	c.Purge()
+	assert.Equal(t, 0, c.Len())
+	assert.Equal(t, []string{"a", "b"}, evictedKeys)
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+
+	c.Add("c", 3)
+	v, ok := c.Get("c")
+	assert.True(t, ok)
+	assert.Equal(t, 3, v)
+	assert.Equal(t, uint64(0), c.Stats().Evictions)
+}
+
+func TestLRU_Range(t *testing.T) {
+	c := NewLRU[string, int](3, nil)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3)
+	c.Get("a")
+
+	var keys []string
+	var sum int
+	c.Range(func(key string, value int) {
+		keys = append(keys, key)
+		sum += value
+	})
+	assert.Equal(t, []string{"a", "c", "b"}, keys)
+	assert.Equal(t, 6, sum)
+	assert.Equal(t, LRUStats{Hits: 1}, c.Stats(), "the items aren't marked as used")
+}
+
+func TestLRU_GetOrAdd(t *testing.T) {
+	c := NewLRU[string, *int](10, nil)
+	createdCount := int32(0)
+	values := make([]*int, 100)
+	wg := sync.WaitGroup{}
+	for i := 0; i < len(values); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = c.GetOrAdd("key", func() *int {
+				atomic.AddInt32(&createdCount, 1)
+				return new(int)
+			})
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), createdCount)
+	for _, v := range values {
+		assert.Same(t, values[0], v)
+	}
+}
+
+func TestLRU_Concurrency(t *testing.T) {
+	c := NewLRU[string, int](10, nil)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key-%d", i%20)
+			c.Add(key, i)
+			_, _ = c.Get(key)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 10, c.Len())
+	assert.Equal(t, uint64(100), c.Stats().Hits+c.Stats().Misses)
+	assert.GreaterOrEqual(t, c.Stats().Evictions, uint64(10))
+}
@@ -0,0 +1,17 @@
+package util
+
+// Min returns the smaller of the numbers
+func Min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Max returns the larger of the numbers
+func Max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,16 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseQueryParam splits the query parameter in format `name=value` to the name and the value,
+// the value isn't URL encoded, it's encoded on building the query
+func ParseQueryParam(param string) (string, string, error) {
+	kv := strings.SplitN(param, "=", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+		return "", "", fmt.Errorf("%q isn't in format name=value", param)
+	}
+	return kv[0], kv[1], nil
+}
@@ -4,15 +4,69 @@ import (
 	"fmt"
 	"github.com/rwtodd/Go.Sed/sed"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
+// compiledProgramsCacheSize is the max number of cached compiled SED and JQ programs of every kind
+const compiledProgramsCacheSize = 1000
+
+var sedPrograms = NewLRU[string, *SEDProgram](compiledProgramsCacheSize, nil)
+
+// SEDProgram is the compiled SED expression, it's safe for the concurrent use.
+// The sed engine isn't: the range conditions (e.g. `/start/,/end/d`) keep their state inside the engine
+// between the runs, so every run takes the engine from the pool, and the engines with the range conditions
+// are never reused
+type SEDProgram struct {
+	expr      string
+	stateful  bool
+	engines   sync.Pool
+	newEngine func() (*sed.Engine, error)
+}
+
+// CompileSED compiles the sed expression, the compiled programs are cached by the expression
+func CompileSED(sedExpr string) (*SEDProgram, error) {
+	if p, ok := sedPrograms.Get(sedExpr); ok {
+		return p, nil
+	}
+	newEngine := func() (*sed.Engine, error) { return sed.New(strings.NewReader(sedExpr)) }
+	engine, err := newEngine()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", GetFuncName(sed.New), err)
+	}
+	p := &SEDProgram{expr: sedExpr, stateful: hasSEDRangeAddress(sedExpr), newEngine: newEngine}
+	if !p.stateful {
+		p.engines.Put(engine)
+	}
+	// the r command reads the file on the compilation, so the programs with the file commands aren't cached to pick up
+	// the file changes
+	if !HasSEDFileCommands(sedExpr) {
+		sedPrograms.Add(sedExpr, p)
+	}
+	return p, nil
+}
+
 // SED replace the input by sed expression
 // in the error case returns the original input
 func SED(sedExpr string, input []byte) ([]byte, []byte, error) {
 	if len(input) == 0 || len(sedExpr) == 0 {
 		return input, input, nil
 	}
-	engine, err := sed.New(strings.NewReader(sedExpr))
+	p, err := CompileSED(sedExpr)
+	if err != nil {
+		return input, input, err
+	}
+	return p.Run(input)
+}
+
+// Run replace the input by the compiled sed expression
+// in the error case returns the original input
+func (p *SEDProgram) Run(input []byte) ([]byte, []byte, error) {
+	if len(input) == 0 || len(p.expr) == 0 {
+		return input, input, nil
+	}
+	engine, err := p.getEngine()
 	if err != nil {
 		return input, input, fmt.Errorf("%s: %w", GetFuncName(sed.New), err)
 	}
@@ -20,9 +74,120 @@ func SED(sedExpr string, input []byte) ([]byte, []byte, error) {
 	if err != nil {
 		return input, input, fmt.Errorf("%s: %w", GetFuncName(engine.RunString), err)
 	}
+	if !p.stateful {
+		p.engines.Put(engine)
+	}
 	// remove last \n cos sed adds this by default
 	if !strings.HasSuffix(string(input), "\n") && strings.HasSuffix(output, "\n") {
 		output = output[:len(output)-1]
 	}
 	return []byte(output), input, nil
 }
+
+func (p *SEDProgram) getEngine() (*sed.Engine, error) {
+	if engine, ok := p.engines.Get().(*sed.Engine); ok {
+		return engine, nil
+	}
+	return p.newEngine()
+}
+
+// HasSEDFileCommands checks if the expression has the commands reading (r) or writing (w) the files, the expressions
+// which can't be scanned (e.g. with the multibyte delimiters) are considered having them
+func HasSEDFileCommands(expr string) bool {
+	return scanSEDCommands(expr, func(command byte) bool { return strings.IndexByte("rRwW", command) >= 0 })
+}
+
+// hasSEDRangeAddress checks if the expression has the range addresses (e.g. `/start/,/end/d`), they are the only ones
+// keeping the state in the engine between the runs (the hold space is reset on every run)
+func hasSEDRangeAddress(expr string) bool {
+	return scanSEDCommands(expr, func(command byte) bool { return command == ',' })
+}
+
+// scanSEDCommands calls isFound for the commands and addresses characters of the expression until it returns true.
+// The expression is scanned the same way the sed lexer does it, so the regular expressions, replacements, labels,
+// file names and texts are skipped. The expressions which can't be scanned (the s and y commands with the multibyte
+// delimiters) are considered found
+func scanSEDCommands(expr string, isFound func(command byte) bool) bool {
+	for i := 0; i < len(expr); i++ {
+		command := expr[i]
+		if isFound(command) {
+			return true
+		}
+		switch command {
+		case '#':
+			i = skipSEDLines(expr, i+1, false)
+		case 'a', 'i', 'c':
+			i = skipSEDLines(expr, i+1, true)
+		case '/':
+			i = skipSEDDelimited(expr, i+1, '/')
+		case 's', 'y':
+			if i+1 >= len(expr) || expr[i+1] >= utf8.RuneSelf {
+				return true
+			}
+			delimiter := expr[i+1]
+			i = skipSEDDelimited(expr, i+2, delimiter)
+			if command == 's' {
+				i = skipSEDReplacement(expr, i+1, delimiter)
+				i = skipSEDIdentifier(expr, i+1)
+			} else {
+				i = skipSEDDelimited(expr, i+1, delimiter)
+			}
+		case ':', 'b', 't', 'r', 'w':
+			i = skipSEDIdentifier(expr, i+1)
+		}
+	}
+	return false
+}
+
+// skipSEDDelimited returns the index of the delimiter ending the regular expression started at the index
+func skipSEDDelimited(expr string, start int, delimiter byte) int {
+	i := start
+	for ; i < len(expr) && expr[i] != '\n'; i++ {
+		if expr[i] == delimiter && (i == start || expr[i-1] != '\\') {
+			break
+		}
+	}
+	return i
+}
+
+// skipSEDReplacement returns the index of the delimiter ending the replacement started at the index, the escaped
+// backslash doesn't escape the next character
+func skipSEDReplacement(expr string, i int, delimiter byte) int {
+	var previous byte
+	for ; i < len(expr); i++ {
+		character := expr[i]
+		if previous == '\\' {
+			if character == '\\' {
+				character = ' '
+			}
+		} else if character == delimiter || character == '\n' {
+			break
+		}
+		previous = character
+	}
+	return i
+}
+
+// skipSEDIdentifier returns the index of the last character of the label, file name or substitution flags started
+// after the spaces at the index
+func skipSEDIdentifier(expr string, i int) int {
+	for i < len(expr) && expr[i] != '\n' && unicode.IsSpace(rune(expr[i])) {
+		i++
+	}
+	for i < len(expr) && expr[i] != ';' && !unicode.IsSpace(rune(expr[i])) {
+		i++
+	}
+	return i - 1
+}
+
+// skipSEDLines returns the index of the newline ending the comment or the text of the a, i, c commands started at
+// the index, the text lines ending with the backslash are continued if isContinued
+func skipSEDLines(expr string, start int, isContinued bool) int {
+	i := start
+	for ; i < len(expr); i++ {
+		if expr[i] == '\n' && (!isContinued || i == start || expr[i-1] != '\\') {
+			break
+		}
+	}
+	return i
+}
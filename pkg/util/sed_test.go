@@ -5,6 +5,10 @@ package util
 
 import (
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -50,3 +54,124 @@ func TestSED_Success(t *testing.T) {
 		})
 	}
 }
+
+func TestSED_Error(t *testing.T) {
+	for _, expr := range []string{"s|unclosed", "x|", "s|(|b|"} {
+		_, err := CompileSED(expr)
+		assert.Error(t, err, expr)
+		actual, _, err := SED(expr, []byte("input"))
+		assert.Error(t, err, expr)
+		assert.Equal(t, "input", string(actual))
+	}
+}
+
+func TestSEDProgram_Run_RangeConditionsDoNotLeakBetweenRuns(t *testing.T) {
+	p, err := CompileSED(`/start/,/end/d`)
+	assert.NoError(t, err)
+	assert.True(t, p.stateful)
+
+	// the range isn't closed in the first input, so the engine would stay in the range for the next one if reused
+	actual, _, err := p.Run([]byte("a\nstart\nb"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(actual))
+	actual, _, err = p.Run([]byte("c\nd"))
+	assert.NoError(t, err)
+	assert.Equal(t, "c\nd", string(actual))
+}
+
+func TestSEDProgram_Run_HoldSpaceDoesNotLeakBetweenRuns(t *testing.T) {
+	p, err := CompileSED(`1h;1!H;${x;s|\n|,|g;p};d`)
+	assert.NoError(t, err)
+	assert.False(t, p.stateful)
+
+	for _, input := range []string{"a\nb", "c\nd"} {
+		actual, _, err := p.Run([]byte(input))
+		assert.NoError(t, err)
+		assert.Equal(t, strings.ReplaceAll(input, "\n", ","), string(actual))
+	}
+}
+
+func TestHasSEDRangeAddress(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`/start/,/end/d`, true},
+		{`1,3d`, true},
+		{`2,$ s|a|b|`, true},
+		{`/a\/,b/d`, false},
+		{`/a/d;3,4p`, true},
+		{`s|a,b|c,d|g`, false},
+		{`s|a\|,|b|g`, false},
+		{`s|a|\\|;1,2d`, true},
+		{`s|a|b|g;y|,|;|`, false},
+		{`s,a,b,`, false},
+		{`1{h;x;G;g;H}`, false},
+		{"/a/a text, with comma\n1d", false},
+		{"/a/i\\\ntext,\n1d", false},
+		{"/a/c text\n1,2d", true},
+		{"# comment, with comma\ns|a|b|", false},
+		{`:label,with,commas`, false},
+		{":l,c\n/a/b l,c", false},
+		{`/a/w out,file`, false},
+		{`s/a/b/g ; 1,2d`, true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, hasSEDRangeAddress(tt.expr), tt.expr)
+	}
+}
+
+func TestHasSEDFileCommands(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`/a/r /etc/passwd`, true},
+		{`1w out.txt`, true},
+		{`s|a|b|;$r file`, true},
+		{"s|a|b|\nw out.txt", true},
+		{`s|r|w|g`, false},
+		{`/rw/d`, false},
+		{"/a/a read, write\n1d", false},
+		{`:read`, false},
+		{`y|rw|wr|`, false},
+		{`s│a│b│`, true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, HasSEDFileCommands(tt.expr), tt.expr)
+	}
+}
+
+func TestCompileSED_FileCommandsArentCached(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appended.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("first"), 0o600))
+	output, _, err := SED("1r "+path, []byte("line"))
+	assert.NoError(t, err)
+	assert.Equal(t, "line\nfirst", string(output))
+
+	assert.NoError(t, os.WriteFile(path, []byte("second"), 0o600))
+	output, _, err = SED("1r "+path, []byte("line"))
+	assert.NoError(t, err)
+	assert.Equal(t, "line\nsecond", string(output), "the file is read again")
+}
+
+func TestSEDProgram_Run_Concurrency(t *testing.T) {
+	for _, expr := range []string{`s|sed|world|`, `/start/,/end/d`} {
+		p, err := CompileSED(expr)
+		assert.NoError(t, err)
+		want, _, err := p.Run([]byte("Hello sed\nstart\nskip\nend\nsed"))
+		assert.NoError(t, err)
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				actual, _, err := p.Run([]byte("Hello sed\nstart\nskip\nend\nsed"))
+				assert.NoError(t, err)
+				assert.Equal(t, string(want), string(actual))
+			}()
+		}
+		wg.Wait()
+	}
+}